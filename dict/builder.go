@@ -55,6 +55,12 @@ type Options struct {
 	// If not set zstd.SpeedBestCompression will be used.
 	ZstdLevel zstd.EncoderLevel
 
+	// Cover will select the dictionary content with the COVER or fastCover
+	// algorithm of the Zstandard reference trainer instead of the default heuristic.
+	// If Cover.Size is 0, MaxDictSize is used. HashBytes is not used.
+	// See zstd.CoverParams for the parameters.
+	Cover *zstd.CoverParams
+
 	outFormat int
 }
 
@@ -91,6 +97,9 @@ func BuildRawDict(input [][]byte, o Options) ([]byte, error) {
 }
 
 func buildDict(input [][]byte, o Options) ([]byte, error) {
+	if o.Cover != nil {
+		return buildCoverDict(input, o)
+	}
 	matches := make(map[uint32]uint32)
 	offsets := make(map[uint32]int64)
 	var total uint64
@@ -416,13 +425,45 @@ func buildDict(input [][]byte, o Options) ([]byte, error) {
 		toWrite := dst[len(dst)-i-1]
 		out.Write(toWrite)
 	}
+	return formatDict(input, out.Bytes(), firstOffsets, o)
+}
+
+// buildCoverDict will build a dictionary with content selected by zstd.TrainCover.
+func buildCoverDict(input [][]byte, o Options) ([]byte, error) {
+	if len(input) == 0 {
+		return nil, fmt.Errorf("no input provided")
+	}
+	p := *o.Cover
+	if p.Size == 0 {
+		p.Size = o.MaxDictSize
+	}
+	if p.Level == 0 {
+		p.Level = o.ZstdLevel
+	}
+	if p.DebugOut == nil {
+		p.DebugOut = o.Output
+	}
+	if o.outFormat == formatS2 {
+		p.Size = min(p.Size, s2.MaxDictSize)
+	}
+	content, _, err := zstd.TrainCover(input, p)
+	if err != nil {
+		return nil, err
+	}
+	return formatDict(input, content, nil, o)
+}
+
+// formatDict will output the dictionary content in the format selected in o.
+// firstOffsets contains offsets from the end of the content
+// that are likely to be used first.
+func formatDict(input [][]byte, content []byte, firstOffsets []int, o Options) ([]byte, error) {
 	if o.outFormat == formatRaw {
-		return out.Bytes(), nil
+		return content, nil
 	}
 
 	if o.outFormat == formatS2 {
 		dOff := 0
-		dBytes := out.Bytes()
+		dBytes := content
 		if len(dBytes) > s2.MaxDictSize {
 			dBytes = dBytes[:s2.MaxDictSize]
 		}
@@ -443,16 +484,18 @@ func buildDict(input [][]byte, o Options) ([]byte, error) {
 
 	offsetsZstd := [3]int{1, 4, 8}
 	for i, off := range firstOffsets {
-		if i >= 3 || off == 0 || off >= out.Len() {
+		if i >= 3 || off == 0 || off >= len(content) {
 			break
 		}
 		offsetsZstd[i] = off
 	}
-	println("\nCompressing. Offsets:", offsetsZstd)
+	if o.Output != nil {
+		fmt.Fprintln(o.Output, "\nCompressing. Offsets:", offsetsZstd)
+	}
 	return zstd.BuildDict(zstd.BuildDictOptions{
 		ID:         o.ZstdDictID,
 		Contents:   input,
-		History:    out.Bytes(),
+		History:    content,
 		Offsets:    offsetsZstd,
 		CompatV155: o.ZstdDictCompat,
 		Level:      o.ZstdLevel,
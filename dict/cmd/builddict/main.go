@@ -27,6 +27,9 @@ var (
 	wantZstdCompat = flag.Bool("zcompat", true, "Generate dictionary compatible with zstd 1.5.5 and older")
 	wantZstdLevel  = flag.Int("zlevel", 0, "Zstd compression level. 0-4")
 	quiet          = flag.Bool("q", false, "Do not print progress")
+	wantCover      = flag.String("cover", "", `Select content with "cover" or "fastcover" instead of the default heuristic`)
+	wantCoverK     = flag.Int("k", 0, "Segment size for cover. Default (0) will try a range of sizes")
+	wantCoverD     = flag.Int("d", 0, "Dmer size for cover. Default (0) will try 6 and 8")
 )
 
 func main() {
@@ -43,6 +46,17 @@ func main() {
 	if *wantOutput == "" || *quiet {
 		o.Output = nil
 	}
+	switch *wantCover {
+	case "":
+	case "cover", "fastcover":
+		o.Cover = &zstd.CoverParams{
+			K:    *wantCoverK,
+			D:    *wantCoverD,
+			Fast: *wantCover == "fastcover",
+		}
+	default:
+		log.Fatalf("unknown cover algorithm %q", *wantCover)
+	}
 	var input [][]byte
 	base := flag.Arg(0)
 	if base == "" {
@@ -0,0 +1,205 @@
+// Copyright 2025+ Klaus Post. All rights reserved.
+// License information can be found in the LICENSE file.
+
+package zstd
+
+import (
+	"errors"
+	"fmt"
+)
+
+// BlockEncoder compresses blocks without frame or block headers,
+// similar to ZSTD_compressBlock in the reference implementation.
+// This can be used to store compressed blocks in another container format.
+//
+// Blocks use the blocks before them as history, up to the window size,
+// and must be decoded in the same order by a BlockDecoder with the same window size.
+// The output can be decoded by ZSTD_decompressBlock in the reference implementation,
+// when blocks are decompressed into a contiguous buffer.
+//
+// A BlockEncoder cannot be used concurrently.
+type BlockEncoder struct {
+	o   encoderOptions
+	enc encoder
+	blk *blockEnc
+}
+
+// NewBlockEncoder returns a BlockEncoder with the given options.
+// The level, window size and match finder options are used.
+// Dictionaries are not supported, but history can be given to Reset.
+func NewBlockEncoder(opts ...EOption) (*BlockEncoder, error) {
+	initPredefined()
+	var e BlockEncoder
+	e.o.setDefault()
+	for _, o := range opts {
+		if err := o(&e.o); err != nil {
+			return nil, err
+		}
+	}
+	if err := e.o.validate(); err != nil {
+		return nil, err
+	}
+	if e.o.dict != nil {
+		return nil, errors.New("dictionaries cannot be used with BlockEncoder")
+	}
+	e.enc = e.o.encoder()
+	e.Reset(nil)
+	return &e, nil
+}
+
+// Reset will start a new sequence of blocks.
+// history is used as content before the first block,
+// which matches can reference. It may be nil.
+// Only the last window size bytes of history are used.
+// The same history must be given to the BlockDecoder.
+func (e *BlockEncoder) Reset(history []byte) {
+	if len(history) > e.o.windowSize {
+		history = history[len(history)-e.o.windowSize:]
+	}
+	e.enc.Reset(nil, false)
+	e.blk = e.enc.Block()
+	for len(history) > 0 {
+		todo := history[:min(len(history), e.o.blockSize)]
+		history = history[len(todo):]
+		e.enc.Encode(e.blk, todo)
+		e.blk.reset(nil)
+	}
+	e.blk.initNewEncode()
+}
+
+// WindowSize returns the window size of the encoder.
+// Blocks can reference this many bytes of the blocks before them.
+func (e *BlockEncoder) WindowSize() int {
+	return e.o.windowSize
+}
+
+// BlockSize returns the maximum size of a block.
+// This is 128KB, or the window size if smaller.
+func (e *BlockEncoder) BlockSize() int {
+	return e.o.blockSize
+}
+
+// EncodeBlock will compress src and append it to dst.
+// src must be at most BlockSize bytes.
+// If src cannot be compressed, dst is returned unchanged.
+// The block must then be stored uncompressed and given to BlockDecoder.InsertBlock,
+// so it is included in the history of the following blocks.
+// Empty blocks are never compressed.
+func (e *BlockEncoder) EncodeBlock(dst, src []byte) ([]byte, error) {
+	if len(src) > e.o.blockSize {
+		return dst, fmt.Errorf("block size %d exceeds maximum of %d", len(src), e.o.blockSize)
+	}
+	if len(src) == 0 {
+		return dst, nil
+	}
+	blk := e.blk
+	blk.pushOffsets()
+	e.enc.Encode(blk, src)
+	err := blk.encode(src, e.o.noEntropy, !e.o.allLitEntropy)
+	defer blk.reset(nil)
+	if err != nil {
+		return dst, err
+	}
+	bh := uint32(blk.output[0]) | (uint32(blk.output[1]) << 8) | (uint32(blk.output[2]) << 16)
+	switch blockType((bh >> 1) & 3) {
+	case blockTypeCompressed:
+		return append(dst, blk.output[3:]...), nil
+	case blockTypeRLE:
+		// RLE blocks cannot be represented, so output RLE literals without sequences.
+		var lh literalsHeader
+		lh.setType(literalsBlockRLE)
+		lh.setSize(len(src))
+		dst = lh.appendTo(dst)
+		return append(dst, src[0], 0), nil
+	}
+	return dst, nil
+}
+
+// BlockDecoder decodes blocks compressed by BlockEncoder,
+// or by ZSTD_compressBlock in the reference implementation.
+// Blocks must be decoded in the order they were compressed.
+// After an error, Reset must be called before decoding more blocks.
+//
+// A BlockDecoder cannot be used concurrently.
+type BlockDecoder struct {
+	o    decoderOptions
+	blk  *blockDec
+	hist history
+}
+
+// NewBlockDecoder returns a BlockDecoder for blocks compressed with the given window size.
+// The window size must be at least the window size of the encoder,
+// and at most the maximum window size set with WithDecoderMaxWindow.
+// Only the low memory and maximum window size options are used.
+func NewBlockDecoder(windowSize int, opts ...DOption) (*BlockDecoder, error) {
+	initPredefined()
+	var d BlockDecoder
+	d.o.setDefault()
+	for _, o := range opts {
+		if err := o(&d.o); err != nil {
+			return nil, err
+		}
+	}
+	switch {
+	case windowSize < MinWindowSize:
+		return nil, ErrWindowSizeTooSmall
+	case uint64(windowSize) > d.o.maxWindowSize:
+		return nil, ErrWindowSizeExceeded
+	}
+	d.blk = newBlockDec(d.o.lowMem)
+	d.hist.windowSize = windowSize
+	if !d.o.lowMem || windowSize < maxBlockSize {
+		d.hist.allocFrameBuffer = windowSize * 2
+	} else {
+		d.hist.allocFrameBuffer = windowSize + maxBlockSize/2
+	}
+	d.Reset(nil)
+	return &d, nil
+}
+
+// Reset will start a new sequence of blocks.
+// history is used as content before the first block,
+// and must match the history given to the encoder.
+// Only the last window size bytes of history are used.
+func (d *BlockDecoder) Reset(history []byte) {
+	d.hist.reset()
+	if cap(d.hist.b) < d.hist.allocFrameBuffer {
+		d.hist.b = make([]byte, 0, d.hist.allocFrameBuffer)
+	}
+	d.hist.append(history)
+}
+
+// DecodeBlock will decode the compressed block src and append the output to dst.
+func (d *BlockDecoder) DecodeBlock(dst, src []byte) ([]byte, error) {
+	switch {
+	case len(src) > maxCompressedBlockSize || len(src) > d.hist.windowSize:
+		return dst, ErrCompressedSizeTooBig
+	case len(src) < 2:
+		return dst, ErrBlockTooSmall
+	}
+	b := d.blk
+	b.WindowSize = uint64(d.hist.windowSize)
+	b.Type = blockTypeCompressed
+	b.Last = false
+	b.RLESize = 0
+	b.data = src
+	if cap(b.dst) <= maxCompressedBlockSizeAlloc {
+		b.dst = make([]byte, 0, maxCompressedBlockSizeAlloc+1)
+	}
+	d.hist.ensureBlock()
+	before := len(d.hist.b)
+	err := b.decodeBuf(&d.hist)
+	b.data = nil
+	d.hist.decoders.out, d.hist.decoders.literals = nil, nil
+	if err != nil {
+		return dst, err
+	}
+	return append(dst, d.hist.b[before:]...), nil
+}
+
+// InsertBlock adds an uncompressed block to the history,
+// similar to ZSTD_insertBlock in the reference implementation.
+// This must be used for blocks that BlockEncoder.EncodeBlock did not compress.
+func (d *BlockDecoder) InsertBlock(b []byte) {
+	d.hist.append(b)
+}
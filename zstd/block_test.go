@@ -0,0 +1,128 @@
+package zstd
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"testing"
+)
+
+func TestBlockEncoder(t *testing.T) {
+	text, err := os.ReadFile("../testdata/Mark.Twain-Tom.Sawyer.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	history, text := text[:20000], text[20000:]
+	// Add incompressible and RLE content.
+	random := make([]byte, 100000)
+	rng := uint32(1)
+	for i := range random {
+		rng = rng*1664525 + 1013904223
+		random[i] = byte(rng >> 24)
+	}
+	segments := [][]byte{text[:200000], random, bytes.Repeat([]byte{'a'}, 50000), text[200000:]}
+	in := bytes.Join(segments, nil)
+
+	for _, level := range []EncoderLevel{SpeedFastest, SpeedDefault, SpeedBetterCompression, SpeedBestCompression} {
+		for _, window := range []int{64 << 10, 8 << 20} {
+			for _, hist := range [][]byte{nil, history} {
+				t.Run(fmt.Sprintf("%v-%d-%d", level, window, len(hist)), func(t *testing.T) {
+					enc, err := NewBlockEncoder(WithEncoderLevel(level), WithWindowSize(window))
+					if err != nil {
+						t.Fatal(err)
+					}
+					dec, err := NewBlockDecoder(enc.WindowSize())
+					if err != nil {
+						t.Fatal(err)
+					}
+					enc.Reset(hist)
+					dec.Reset(hist)
+
+					// Also write the blocks as a frame, which must decode to the same.
+					frame := frameHeader{WindowSize: uint32(window)}.appendTo(nil)
+					var out []byte
+					var compressed, raw, rle int
+					var blocks [][]byte
+					for _, b := range segments {
+						for len(b) > 0 {
+							n := min(len(b), enc.BlockSize(), 1000+len(blocks)*10000)
+							blocks = append(blocks, b[:n])
+							b = b[n:]
+						}
+					}
+					for i, block := range blocks {
+						comp, err := enc.EncodeBlock(nil, block)
+						if err != nil {
+							t.Fatal(err)
+						}
+						var bh blockHeader
+						bh.setLast(i == len(blocks)-1)
+						if len(comp) == 0 {
+							raw++
+							dec.InsertBlock(block)
+							out = append(out, block...)
+							bh.setType(blockTypeRaw)
+							bh.setSize(uint32(len(block)))
+							frame = append(bh.appendTo(frame), block...)
+							continue
+						}
+						compressed++
+						if literalsBlockType(comp[0]&3) == literalsBlockRLE {
+							rle++
+						}
+						out, err = dec.DecodeBlock(out, comp)
+						if err != nil {
+							t.Fatalf("block %d: %v", i, err)
+						}
+						bh.setType(blockTypeCompressed)
+						bh.setSize(uint32(len(comp)))
+						frame = append(bh.appendTo(frame), comp...)
+					}
+					if !bytes.Equal(out, in) {
+						t.Fatal("output mismatch")
+					}
+					if compressed == 0 || raw == 0 || rle == 0 {
+						t.Errorf("got %d compressed, %d raw and %d rle blocks", compressed, raw, rle)
+					}
+					fdec, err := NewReader(nil)
+					if err != nil {
+						t.Fatal(err)
+					}
+					defer fdec.Close()
+					got, err := fdec.DecodeAllWithPrefix(hist, frame, nil)
+					if err != nil {
+						t.Fatal(err)
+					}
+					if !bytes.Equal(got, in) {
+						t.Fatal("frame output mismatch")
+					}
+				})
+			}
+		}
+	}
+
+	enc, err := NewBlockEncoder()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := enc.EncodeBlock(nil, make([]byte, enc.BlockSize()+1)); err == nil {
+		t.Error("no error for too big block")
+	}
+	if _, err := NewBlockDecoder(MinWindowSize - 1); err != ErrWindowSizeTooSmall {
+		t.Errorf("got %v, want ErrWindowSizeTooSmall", err)
+	}
+	dec, err := NewBlockDecoder(enc.WindowSize())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := dec.DecodeBlock(nil, []byte{0}); err != ErrBlockTooSmall {
+		t.Errorf("got %v, want ErrBlockTooSmall", err)
+	}
+	comp, err := enc.EncodeBlock(nil, text[:100000])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := dec.DecodeBlock(nil, comp[:len(comp)/2]); err == nil {
+		t.Error("no error for truncated block")
+	}
+}
@@ -95,8 +95,12 @@ type blockDec struct {
 		seqData  []byte
 		seqSize  int // Size of uncompressed sequences
 		fcs      uint64
+		stats    FrameStats // Statistics of the frame, if this is the last block.
 	}
 
+	// Statistics of the block.
+	stats FrameStats
+
 	// Block is RLE, this is the size.
 	RLESize uint32
 
@@ -208,6 +212,15 @@ func (b *blockDec) reset(br byteBuffer, windowSize uint64) error {
 	if cap(b.dst) <= maxSize {
 		b.dst = make([]byte, 0, maxSize+1)
 	}
+	b.stats = FrameStats{Compressed: 3 + int64(cSize)}
+	switch b.Type {
+	case blockTypeRaw:
+		b.stats.RawBlocks = 1
+	case blockTypeRLE:
+		b.stats.RLEBlocks = 1
+	case blockTypeCompressed:
+		b.stats.CompressedBlocks = 1
+	}
 	return nil
 }
 
@@ -277,6 +290,7 @@ func (b *blockDec) decodeLiterals(in []byte, hist *history) (remain []byte, err
 	if len(in) < 2 {
 		return in, ErrBlockTooSmall
 	}
+	inSize := len(in)
 
 	litType := literalsBlockType(in[0] & 3)
 	var litRegenSize int
@@ -470,6 +484,7 @@ func (b *blockDec) decodeLiterals(in []byte, hist *history) (remain []byte, err
 		}
 	}
 	hist.decoders.literals = literals
+	b.stats.addLiterals(litType, litRegenSize, inSize-len(in))
 	return in, nil
 }
 
@@ -511,6 +526,7 @@ func (b *blockDec) prepareSequences(in []byte, hist *history) (err error) {
 	if len(in) < 1 {
 		return ErrBlockTooSmall
 	}
+	b.stats.SequencesSize = int64(len(in))
 	var nSeqs int
 	seqHeader := in[0]
 	switch {
@@ -538,6 +554,7 @@ func (b *blockDec) prepareSequences(in []byte, hist *history) (err error) {
 		return ErrUnexpectedBlockSize
 	}
 
+	b.stats.Sequences = int64(nSeqs)
 	var seqs = &hist.decoders
 	seqs.nSeqs = nSeqs
 	if nSeqs > 0 {
@@ -28,6 +28,9 @@ type blockEnc struct {
 	recentOffsets     [3]uint32
 	prevRecentOffsets [3]uint32
 
+	// Statistics of the encoded blocks since they were collected.
+	stats FrameStats
+
 	last   bool
 	lowMem bool
 }
@@ -78,6 +81,7 @@ func (b *blockEnc) initNewEncode() {
 	b.recentOffsets = [3]uint32{1, 4, 8}
 	b.litEnc.Reuse = huff0.ReusePolicyNone
 	b.coders.setPrev(nil, nil, nil)
+	b.stats = FrameStats{}
 }
 
 // reset will reset the block for a new encode, but in the same stream,
@@ -313,6 +317,8 @@ func (b *blockEnc) encodeRaw(a []byte) {
 	bh.setType(blockTypeRaw)
 	b.output = bh.appendTo(b.output[:0])
 	b.output = append(b.output, a...)
+	b.stats.RawBlocks++
+	b.stats.Compressed += int64(len(b.output))
 	if debugEncoder {
 		println("Adding RAW block, length", len(a), "last:", b.last)
 	}
@@ -326,6 +332,8 @@ func (b *blockEnc) encodeRawTo(dst, src []byte) []byte {
 	bh.setType(blockTypeRaw)
 	dst = bh.appendTo(dst)
 	dst = append(dst, src...)
+	b.stats.RawBlocks++
+	b.stats.Compressed += 3 + int64(len(src))
 	if debugEncoder {
 		println("Adding RAW block, length", len(src), "last:", b.last)
 	}
@@ -346,6 +354,8 @@ func (b *blockEnc) encodeLits(lits []byte, raw bool) error {
 		bh.setType(blockTypeRaw)
 		b.output = bh.appendTo(b.output)
 		b.output = append(b.output, lits...)
+		b.stats.RawBlocks++
+		b.stats.Compressed += 3 + int64(len(lits))
 		return nil
 	}
 
@@ -385,6 +395,8 @@ func (b *blockEnc) encodeLits(lits []byte, raw bool) error {
 		bh.setType(blockTypeRaw)
 		b.output = bh.appendTo(b.output)
 		b.output = append(b.output, lits...)
+		b.stats.RawBlocks++
+		b.stats.Compressed += 3 + int64(len(lits))
 		return nil
 	case huff0.ErrUseRLE:
 		if debugEncoder {
@@ -393,6 +405,8 @@ func (b *blockEnc) encodeLits(lits []byte, raw bool) error {
 		bh.setType(blockTypeRLE)
 		b.output = bh.appendTo(b.output)
 		b.output = append(b.output, lits[0])
+		b.stats.RLEBlocks++
+		b.stats.Compressed += 4
 		return nil
 	case nil:
 	default:
@@ -425,6 +439,10 @@ func (b *blockEnc) encodeLits(lits []byte, raw bool) error {
 	b.output = append(b.output, out...)
 	// No sequences.
 	b.output = append(b.output, 0)
+	b.stats.CompressedBlocks++
+	b.stats.Compressed += 3 + int64(lh.size()+len(out)+1)
+	b.stats.addLiterals(literalsBlockType(lh&3), len(lits), lh.size()+len(out))
+	b.stats.SequencesSize++
 	return nil
 }
 
@@ -436,6 +454,8 @@ func (b *blockEnc) encodeRLE(val byte, length uint32) {
 	bh.setType(blockTypeRLE)
 	b.output = bh.appendTo(b.output)
 	b.output = append(b.output, val)
+	b.stats.RLEBlocks++
+	b.stats.Compressed += 4
 }
 
 // fuzzFseEncoder can be used to fuzz the FSE encoder.
@@ -595,6 +615,7 @@ func (b *blockEnc) encode(org []byte, raw, rawAllLits bool) error {
 		return err
 	}
 	// Sequence compression
+	seqStart := len(b.output)
 
 	// Write the number of sequences
 	switch {
@@ -822,9 +843,114 @@ func (b *blockEnc) encode(org []byte, raw, rawAllLits bool) error {
 	}
 	_ = bh.appendTo(b.output[bhOffset:bhOffset])
 	b.coders.setPrev(llEnc, mlEnc, ofEnc)
+	b.stats.CompressedBlocks++
+	b.stats.Compressed += int64(len(b.output) - bhOffset)
+	b.stats.addLiterals(literalsBlockType(lh&3), len(b.literals), seqStart-bhOffset-3)
+	b.stats.Sequences += int64(len(b.sequences))
+	b.stats.SequencesSize += int64(len(b.output) - seqStart)
 	return nil
 }
 
+// encodeLimit will encode the block like encode if the output fits in limit bytes.
+// Otherwise the longest prefix ending at a sequence or literal that fits is encoded
+// as the last block and the number of input bytes in it is returned.
+// If no input fits, nothing is written and 0 is returned.
+// Entropy coder state and statistics are restored before each attempt,
+// so they match the block that is written.
+func (b *blockEnc) encodeLimit(org []byte, limit int, raw, rawAllLits bool) (int, error) {
+	lits, seqs := b.literals, b.sequences
+	coders, dictLitEnc, reuse, stats := b.coders, b.dictLitEnc, b.litEnc.Reuse, b.stats
+	var litTable huff0.Scratch
+	litTable.TransferCTable(b.litEnc)
+	// setPrev swaps the encoders and may clear the previous tables.
+	encs := [...]*fseEncoder{coders.llEnc, coders.llPrev, coders.ofEnc, coders.ofPrev, coders.mlEnc, coders.mlPrev}
+	var states [len(encs)]struct {
+		symbolLen uint16
+		reUsed    bool
+	}
+	for i, enc := range encs {
+		states[i].symbolLen, states[i].reUsed = enc.symbolLen, enc.reUsed
+	}
+
+	var err error
+	fits := func(nSeqs, nLits int) bool {
+		b.coders = coders
+		for i, enc := range encs {
+			enc.symbolLen, enc.reUsed = states[i].symbolLen, states[i].reUsed
+		}
+		b.litEnc.TransferCTable(&litTable)
+		b.litEnc.Reuse = reuse
+		b.dictLitEnc = dictLitEnc
+		b.stats = stats
+		b.sequences = seqs[:nSeqs]
+		b.literals = lits[:nLits]
+		b.size = nLits
+		for _, s := range b.sequences {
+			b.size += int(s.matchLen) + zstdMinMatch
+		}
+		b.output = b.output[:0]
+		err = b.encode(org[:b.size], raw, rawAllLits)
+		return err == nil && len(b.output) <= limit
+	}
+	// litsBefore returns the number of literals before sequence n.
+	litsBefore := func(n int) int {
+		l := 0
+		for _, s := range seqs[:n] {
+			l += int(s.litLen)
+		}
+		return l
+	}
+	if fits(len(seqs), len(lits)) {
+		return len(org), nil
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	// Find the number of sequences that fit,
+	// then add as many of the following literals as possible.
+	b.last = true
+	lo, hi := 0, len(seqs)-1
+	for lo < hi {
+		mid := lo + (hi-lo+1)/2
+		if fits(mid, litsBefore(mid)) {
+			lo = mid
+		} else if err != nil {
+			return 0, err
+		} else {
+			hi = mid - 1
+		}
+	}
+	nSeqs := lo
+	lo = litsBefore(nSeqs)
+	hi = len(lits)
+	if nSeqs < len(seqs) {
+		hi = lo + int(seqs[nSeqs].litLen)
+	}
+	for lo < hi {
+		mid := lo + (hi-lo+1)/2
+		if fits(nSeqs, mid) {
+			lo = mid
+		} else if err != nil {
+			return 0, err
+		} else {
+			hi = mid - 1
+		}
+	}
+	if nSeqs == 0 && lo == 0 {
+		b.output = b.output[:0]
+		b.stats = stats
+		return 0, nil
+	}
+	if !fits(nSeqs, lo) {
+		if err == nil {
+			err = fmt.Errorf("block of %d bytes does not fit in %d bytes", len(b.output), limit)
+		}
+		return 0, err
+	}
+	return b.size, nil
+}
+
 var errIncompressible = errors.New("incompressible")
 
 func (b *blockEnc) genCodes() {
@@ -0,0 +1,736 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"math/bits"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+
+	"github.com/snissn/compress/zstd"
+)
+
+var (
+	decompress = flag.Bool("d", false, "Decompress")
+	test       = flag.Bool("t", false, "Test integrity of compressed files")
+	list       = flag.Bool("l", false, "List information about compressed files")
+	level      = flag.Int("level", 3, "Compression level 1-19. Can also be given as -#, for example -19")
+	ultra      = flag.Bool("ultra", false, "Enable compression levels above 19")
+	threads    = flag.Int("T", runtime.GOMAXPROCS(0), "Use this amount of threads. 0 will use all cores")
+	dictFile   = flag.String("D", "", "Use file as dictionary")
+	rsyncable  = flag.Bool("rsyncable", false, "Compress so that small input changes only change nearby output, for rsync and deduplication")
+	adapt      = flag.Bool("adapt", false, "Adapt compression level to the output speed")
+	check      = flag.Bool("check", true, "Add checksum to compressed output")
+	memory     = flag.String("memory", "", "Maximum window size when decompressing. Examples: 128M, 1G")
+	stdout     = flag.Bool("c", false, "Write all output to stdout. Multiple input files will be concatenated")
+	out        = flag.String("o", "", "Write output to another file. Single input file only")
+	force      = flag.Bool("f", false, "Overwrite output files")
+	quiet      = flag.Bool("q", false, "Don't write any output to terminal, except errors")
+	help       = flag.Bool("help", false, "Display help")
+
+	// remove is set with "--rm" and cleared with "-k".
+	remove = new(bool)
+	long   longFlag
+
+	version = "(dev)"
+	date    = "(unknown)"
+)
+
+const zstdExt = ".zst"
+
+// longAliases contains long names of the reference implementation and the matching flag.
+var longAliases = map[string]string{
+	"--decompress": "-d",
+	"--uncompress": "-d",
+	"--test":       "-t",
+	"--list":       "-l",
+	"--stdout":     "-c",
+	"--force":      "-f",
+	"--keep":       "-k",
+	"--quiet":      "-q",
+	"--no-check":   "-check=false",
+	"--threads":    "-T",
+	"--memory":     "-memory",
+}
+
+func main() {
+	flag.Var(&long, "long", "Enable long distance matching with a window of 2^n bytes. Default n is 27")
+	flag.Var(removeFlag{v: remove, remove: true}, "rm", "Delete source file(s) after successful compression or decompression. Ignored with -c")
+	flag.Var(removeFlag{v: remove, remove: false}, "k", "Keep source file(s). This is the default. The last of -k and --rm is used")
+	flag.CommandLine.Parse(expandArgs(os.Args[1:]))
+
+	args := flag.Args()
+	if len(args) == 0 || *help {
+		_, _ = fmt.Fprintf(os.Stderr, "zstd v%v, built at %v.\n\n", version, date)
+		_, _ = fmt.Fprintf(os.Stderr, "Copyright (c) 2025+ Klaus Post. All rights reserved.\n\n")
+		_, _ = fmt.Fprintln(os.Stderr, `Usage: zstd [options] file1 file2
+
+Compresses all files supplied as input separately.
+Output files are written as 'filename.ext`+zstdExt+`'.
+With -d, input files must end with '`+zstdExt+`', '.zstd' or '.tzst' and the extension is removed.
+Existing output files are not overwritten unless -f is given.
+Use - as the only file name to read from stdin and write to stdout.
+
+Options are compatible with the reference implementation,
+so -19, -dc, -T4 and --long=27 are accepted.
+Options may also be given after file names.
+
+Wildcards are accepted: testdir/*.txt will compress all files in testdir ending with .txt
+
+Options:`)
+		flag.PrintDefaults()
+		os.Exit(0)
+	}
+	if *threads == 0 {
+		*threads = runtime.GOMAXPROCS(0)
+	}
+	if *threads < 0 {
+		exitErr(errors.New("-T must not be negative"))
+	}
+	if *level > 19 && !*ultra {
+		fmt.Fprintf(os.Stderr, "Warning: level %d requires --ultra, using level 19\n", *level)
+		*level = 19
+	}
+	if *level > 22 {
+		*level = 22
+	}
+	if *level == 0 {
+		*level = 3
+	}
+	if *out != "" && *stdout {
+		exitErr(errors.New("-o and -c cannot be used together"))
+	}
+	*quiet = *quiet || *stdout
+
+	if *list {
+		var files []string
+		if len(args) == 1 && args[0] == "-" {
+			files = args
+		} else {
+			files = globFiles(args)
+		}
+		exitErr(listFiles(os.Stdout, files))
+		return
+	}
+
+	var dict []byte
+	if *dictFile != "" {
+		var err error
+		dict, err = os.ReadFile(*dictFile)
+		exitErr(err)
+	}
+
+	if *decompress || *test {
+		dec, err := zstd.NewReader(nil, decoderOptions(dict)...)
+		exitErr(err)
+		defer dec.Close()
+
+		if len(args) == 1 && args[0] == "-" {
+			exitErr(processStdin(func(w io.Writer) error {
+				if *test {
+					w = io.Discard
+				}
+				if err := dec.Reset(os.Stdin); err != nil {
+					return err
+				}
+				_, err := dec.WriteTo(w)
+				return err
+			}))
+			return
+		}
+		files := globFiles(args)
+		if *out != "" && len(files) > 1 {
+			exitErr(errors.New("-o parameter can only be used with one input"))
+		}
+		failed := false
+		for _, filename := range files {
+			if err := decompressFile(dec, filename); err != nil {
+				printErr(fmt.Errorf("%s: %w", filename, err))
+				failed = true
+			}
+		}
+		if failed {
+			os.Exit(1)
+		}
+		return
+	}
+
+	enc, err := zstd.NewWriter(nil, encoderOptions(dict)...)
+	exitErr(err)
+	defer enc.Close()
+
+	if len(args) == 1 && args[0] == "-" {
+		// Catch interrupt, so we don't exit at once.
+		// os.Stdin will return EOF, so we should be able to get everything.
+		signal.Notify(make(chan os.Signal, 1), os.Interrupt)
+		exitErr(processStdin(func(w io.Writer) error {
+			enc.Reset(w)
+			_, err := enc.ReadFrom(os.Stdin)
+			if err != nil {
+				return err
+			}
+			return enc.Close()
+		}))
+		return
+	}
+	files := globFiles(args)
+	if *out != "" && len(files) > 1 {
+		exitErr(errors.New("-o parameter can only be used with one input"))
+	}
+	failed := false
+	for _, filename := range files {
+		if err := compressFile(enc, filename); err != nil {
+			printErr(fmt.Errorf("%s: %w", filename, err))
+			failed = true
+		}
+	}
+	if failed {
+		os.Exit(1)
+	}
+}
+
+// encoderLevel returns the encoder level closest to the level given on the command line.
+// Levels above 19 are only allowed with --ultra and use SpeedUltraCompression.
+func encoderLevel() zstd.EncoderLevel {
+	if *level > 19 {
+		return zstd.SpeedUltraCompression
+	}
+	return zstd.EncoderLevelFromZstd(*level)
+}
+
+// encoderOptions returns the encoder options given on the command line.
+func encoderOptions(dict []byte) []zstd.EOption {
+	opts := []zstd.EOption{
+		zstd.WithEncoderLevel(encoderLevel()),
+		zstd.WithEncoderConcurrency(*threads),
+		zstd.WithEncoderCRC(*check),
+	}
+	window := 8 << 20
+	if encoderLevel() == zstd.SpeedFastest {
+		window = 4 << 20
+	}
+	if long > 0 {
+		window = 1 << long
+		opts = append(opts, zstd.WithWindowSize(window), zstd.WithLongDistanceMatching(true))
+	}
+	if *adapt {
+		if *rsyncable {
+			exitErr(errors.New("--adapt cannot be combined with --rsyncable"))
+		}
+		opts = append(opts, zstd.WithEncoderAdapt(zstd.SpeedFastest, zstd.SpeedBestCompression))
+	} else if *threads > 1 {
+		// Split streams into jobs like the reference implementation.
+		opts = append(opts, zstd.WithEncoderJobSize(max(4*window, 1<<20)))
+	}
+	if *rsyncable {
+		opts = append(opts, zstd.WithEncoderRsyncable(true))
+	}
+	if len(dict) > 0 {
+		if isDict(dict) {
+			opts = append(opts, zstd.WithEncoderDict(dict))
+		} else {
+			opts = append(opts, zstd.WithEncoderDictRaw(0, dict))
+		}
+	}
+	return opts
+}
+
+// decoderOptions returns the decoder options given on the command line.
+func decoderOptions(dict []byte) []zstd.DOption {
+	opts := []zstd.DOption{zstd.WithDecoderConcurrency(*threads)}
+	if long > 0 {
+		opts = append(opts, zstd.WithDecoderMaxWindow(max(uint64(1)<<long, 512<<20)))
+	}
+	if *memory != "" {
+		n, err := toSize(*memory)
+		exitErr(err)
+		opts = append(opts, zstd.WithDecoderMaxWindow(uint64(n)))
+	}
+	if len(dict) > 0 {
+		if isDict(dict) {
+			opts = append(opts, zstd.WithDecoderDicts(dict))
+		} else {
+			opts = append(opts, zstd.WithDecoderDictRaw(0, dict))
+		}
+	}
+	return opts
+}
+
+// isDict returns whether b is in the zstd dictionary format.
+// Other content is used as a raw dictionary.
+func isDict(b []byte) bool {
+	return len(b) >= 8 && string(b[:4]) == "\x37\xa4\x30\xec"
+}
+
+// processStdin will call fn with stdout or the file given with -o.
+func processStdin(fn func(w io.Writer) error) error {
+	if *out == "" || *test {
+		bw := bufio.NewWriterSize(os.Stdout, 4<<20)
+		if err := fn(bw); err != nil {
+			return err
+		}
+		return bw.Flush()
+	}
+	if !*force {
+		if _, err := os.Stat(*out); !os.IsNotExist(err) {
+			return fmt.Errorf("%s already exists; not overwritten", *out)
+		}
+	}
+	dstFile, err := os.OpenFile(*out, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0o666)
+	if err != nil {
+		return err
+	}
+	bw := bufio.NewWriterSize(dstFile, 4<<20)
+	if err := fn(bw); err != nil {
+		dstFile.Close()
+		return err
+	}
+	if err := bw.Flush(); err != nil {
+		dstFile.Close()
+		return err
+	}
+	return dstFile.Close()
+}
+
+// compressFile will compress a single file.
+func compressFile(enc *zstd.Encoder, filename string) error {
+	dstFilename := filename + zstdExt
+	switch {
+	case *out != "":
+		dstFilename = *out
+	case *stdout:
+		dstFilename = "(stdout)"
+	case strings.HasSuffix(filename, zstdExt):
+		if !*quiet {
+			fmt.Fprintln(os.Stderr, filename, "already has", zstdExt, "suffix -- ignored")
+		}
+		return nil
+	}
+	return processFile(filename, dstFilename, "Compressing", func(dst io.Writer, src io.Reader, size int64) error {
+		enc.ResetContentSize(dst, size)
+		if _, err := enc.ReadFrom(src); err != nil {
+			enc.Close()
+			return err
+		}
+		return enc.Close()
+	})
+}
+
+// decompressFile will decompress or test a single file.
+func decompressFile(dec *zstd.Decoder, filename string) error {
+	var dstFilename string
+	switch {
+	case *test:
+		dstFilename = "(test)"
+	case *out != "":
+		dstFilename = *out
+	case *stdout:
+		dstFilename = "(stdout)"
+	case strings.HasSuffix(filename, zstdExt):
+		dstFilename = strings.TrimSuffix(filename, zstdExt)
+	case strings.HasSuffix(filename, ".zstd"):
+		dstFilename = strings.TrimSuffix(filename, ".zstd")
+	case strings.HasSuffix(filename, ".tzst"):
+		dstFilename = strings.TrimSuffix(filename, ".tzst") + ".tar"
+	default:
+		return errors.New("unknown suffix -- ignored")
+	}
+	return processFile(filename, dstFilename, "Decompressing", func(dst io.Writer, src io.Reader, size int64) error {
+		if err := dec.Reset(src); err != nil {
+			return err
+		}
+		_, err := dec.WriteTo(dst)
+		return err
+	})
+}
+
+// processFile will open filename and dstFilename and call fn with them.
+// If dstFilename is in parentheses, output is written to stdout or discarded.
+// Size is the size of the input or -1 if unknown.
+// Statistics are printed and the input is removed if requested.
+func processFile(filename, dstFilename, verb string, fn func(dst io.Writer, src io.Reader, size int64) error) error {
+	file, err := os.Open(filename)
+	if err != nil {
+		return err
+	}
+	var closeOnce sync.Once
+	defer closeOnce.Do(func() { file.Close() })
+	st, err := file.Stat()
+	if err != nil {
+		return err
+	}
+	size := int64(-1)
+	if st.Mode().IsRegular() {
+		size = st.Size()
+	}
+	rc := &rCounter{in: bufio.NewReaderSize(file, 1<<20)}
+
+	var dstFile *os.File
+	var dst io.Writer
+	switch dstFilename {
+	case "(test)":
+		dst = io.Discard
+	case "(stdout)":
+		dst = os.Stdout
+	default:
+		if !*force {
+			if _, err := os.Stat(dstFilename); !os.IsNotExist(err) {
+				return fmt.Errorf("%s already exists; not overwritten", dstFilename)
+			}
+		}
+		dstFile, err = os.OpenFile(dstFilename, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, st.Mode().Perm())
+		if err != nil {
+			return err
+		}
+		defer dstFile.Close()
+		dst = dstFile
+	}
+	if !*quiet {
+		fmt.Fprint(os.Stderr, verb, " ", filename, " -> ", dstFilename)
+	}
+	bw := bufio.NewWriterSize(dst, 4<<20)
+	wc := &wCounter{out: bw}
+	start := time.Now()
+	err = fn(wc, rc, size)
+	if err == nil {
+		err = bw.Flush()
+	}
+	if err == nil && dstFile != nil {
+		err = dstFile.Close()
+	}
+	if err != nil {
+		if dstFile != nil {
+			dstFile.Close()
+			os.Remove(dstFilename)
+		}
+		if !*quiet {
+			fmt.Fprintln(os.Stderr)
+		}
+		return err
+	}
+	if !*quiet {
+		uncompressed := rc.n
+		if *decompress || *test {
+			uncompressed = wc.n
+		}
+		elapsed := time.Since(start)
+		mbPerSec := (float64(uncompressed) / (1024 * 1024)) / (float64(elapsed) / (float64(time.Second)))
+		pct := float64(wc.n) * 100 / float64(max(rc.n, 1))
+		fmt.Fprintf(os.Stderr, " %d -> %d [%.02f%%]; %.01fMB/s\n", rc.n, wc.n, pct, mbPerSec)
+	}
+	// Like the reference implementation, --rm is ignored when writing to stdout.
+	if *remove && !*test && !*stdout {
+		closeOnce.Do(func() {
+			file.Close()
+			if !*quiet {
+				fmt.Fprintln(os.Stderr, "Removing", filename)
+			}
+			err = os.Remove(filename)
+		})
+	}
+	return err
+}
+
+// listFiles will print information about the frames of each file, similar to "zstd -l".
+func listFiles(w io.Writer, files []string) error {
+	fmt.Fprintf(w, "%6s %6s %12s %14s %7s %6s  %s\n", "Frames", "Skips", "Compressed", "Uncompressed", "Ratio", "Check", "Filename")
+	var failed bool
+	for _, filename := range files {
+		var r io.Reader = os.Stdin
+		if filename != "-" {
+			f, err := os.Open(filename)
+			if err != nil {
+				printErr(err)
+				failed = true
+				continue
+			}
+			r = f
+		}
+		var frames, skips int
+		var compressed, uncompressed int64
+		check := "None"
+		err := zstd.WalkFrames(r, func(f *zstd.FrameInfo) error {
+			compressed += f.Size
+			if f.Header.Skippable {
+				skips++
+				return nil
+			}
+			frames++
+			if f.Header.HasCheckSum {
+				check = "XXH64"
+			}
+			if uncompressed >= 0 {
+				if n := f.DecompressedSize(); n >= 0 {
+					uncompressed += n
+				} else {
+					uncompressed = -1
+				}
+			}
+			return nil
+		})
+		if c, ok := r.(io.Closer); ok && filename != "-" {
+			c.Close()
+		}
+		if err != nil {
+			printErr(fmt.Errorf("%s: %w", filename, err))
+			failed = true
+			continue
+		}
+		ratio, uncomp := "", ""
+		if uncompressed >= 0 {
+			uncomp = sizeString(uncompressed)
+			if compressed > 0 {
+				ratio = fmt.Sprintf("%.3f", float64(uncompressed)/float64(compressed))
+			}
+		}
+		fmt.Fprintf(w, "%6d %6d %12s %14s %7s %6s  %s\n", frames, skips, sizeString(compressed), uncomp, ratio, check, filename)
+	}
+	if failed {
+		return errors.New("unable to list all files")
+	}
+	return nil
+}
+
+// sizeString returns n in human-readable units.
+func sizeString(n int64) string {
+	switch {
+	case n >= 1<<30:
+		return fmt.Sprintf("%.2f GiB", float64(n)/(1<<30))
+	case n >= 1<<20:
+		return fmt.Sprintf("%.2f MiB", float64(n)/(1<<20))
+	case n >= 1<<10:
+		return fmt.Sprintf("%.2f KiB", float64(n)/(1<<10))
+	}
+	return fmt.Sprintf("%d B", n)
+}
+
+// globFiles expands the patterns to file names.
+func globFiles(patterns []string) []string {
+	var files []string
+	for _, pattern := range patterns {
+		found, err := filepath.Glob(pattern)
+		exitErr(err)
+		if len(found) == 0 {
+			exitErr(fmt.Errorf("unable to find file %v", pattern))
+		}
+		files = append(files, found...)
+	}
+	return files
+}
+
+// expandArgs rewrites arguments in the style of the reference implementation
+// to arguments understood by the flag package.
+// "-19" is rewritten to "-level=19", "-T4" to "-T=4" and combined
+// single letter flags like "-dcf" are split into "-d -c -f".
+// Flags found after file names are moved before them.
+func expandArgs(args []string) []string {
+	var flags, files []string
+	for i := 0; i < len(args); i++ {
+		a := args[i]
+		if alias, ok := longAliases[strings.SplitN(a, "=", 2)[0]]; ok {
+			if _, v, ok := strings.Cut(a, "="); ok {
+				alias += "=" + v
+			}
+			a = alias
+		}
+		switch {
+		case a == "--":
+			files = append(files, args[i+1:]...)
+			return append(flags, files...)
+		case a == "-" || !strings.HasPrefix(a, "-"):
+			files = append(files, a)
+			continue
+		}
+		name := strings.TrimLeft(a, "-")
+		if strings.HasPrefix(a, "--") || strings.Contains(name, "=") || flag.Lookup(name) != nil {
+			flags = append(flags, a)
+			if !strings.Contains(name, "=") && takesValue(name) && i+1 < len(args) {
+				i++
+				flags = append(flags, args[i])
+			}
+			continue
+		}
+		for len(name) > 0 {
+			c := name[0]
+			switch {
+			case c >= '0' && c <= '9':
+				n := strings.IndexFunc(name, func(r rune) bool { return !unicode.IsDigit(r) })
+				if n < 0 {
+					n = len(name)
+				}
+				flags = append(flags, "-level="+name[:n])
+				name = name[n:]
+				continue
+			case takesValue(name[:1]):
+				if len(name) > 1 {
+					flags = append(flags, "-"+name[:1]+"="+name[1:])
+				} else {
+					flags = append(flags, "-"+name)
+					if i+1 < len(args) {
+						i++
+						flags = append(flags, args[i])
+					}
+				}
+				name = ""
+				continue
+			}
+			flags = append(flags, "-"+name[:1])
+			name = name[1:]
+		}
+	}
+	return append(flags, files...)
+}
+
+// takesValue returns whether the flag with the given name requires a value.
+func takesValue(name string) bool {
+	f := flag.Lookup(name)
+	if f == nil {
+		return false
+	}
+	b, ok := f.Value.(interface{ IsBoolFlag() bool })
+	return !ok || !b.IsBoolFlag()
+}
+
+// longFlag is the value of the "--long" flag.
+// Without a value the window log is 27, like the reference implementation.
+type longFlag int
+
+func (l *longFlag) String() string {
+	if l == nil {
+		return "0"
+	}
+	return strconv.Itoa(int(*l))
+}
+
+func (l *longFlag) Set(s string) error {
+	switch s {
+	case "true":
+		*l = 27
+		return nil
+	case "false":
+		*l = 0
+		return nil
+	}
+	n, err := strconv.Atoi(s)
+	if err != nil {
+		return err
+	}
+	// The window size is limited by the encoder.
+	if n < minWindowLog || n > maxWindowLog {
+		return fmt.Errorf("window log must be between %d and %d", minWindowLog, maxWindowLog)
+	}
+	*l = longFlag(n)
+	return nil
+}
+
+func (l *longFlag) IsBoolFlag() bool {
+	return true
+}
+
+var (
+	minWindowLog = bits.Len(zstd.MinWindowSize) - 1
+	maxWindowLog = bits.Len(zstd.MaxWindowSize) - 1
+)
+
+// removeFlag is the value of the "--rm" and "-k" flags.
+// Both set whether source files are removed,
+// so the last one given is used like the reference implementation.
+type removeFlag struct {
+	v      *bool
+	remove bool
+}
+
+func (f removeFlag) String() string {
+	if f.v == nil {
+		return "false"
+	}
+	return strconv.FormatBool(*f.v == f.remove)
+}
+
+func (f removeFlag) Set(s string) error {
+	b, err := strconv.ParseBool(s)
+	if err != nil {
+		return err
+	}
+	*f.v = b == f.remove
+	return nil
+}
+
+func (f removeFlag) IsBoolFlag() bool {
+	return true
+}
+
+func printErr(err error) {
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "ERROR:", err.Error())
+	}
+}
+
+func exitErr(err error) {
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "\nERROR:", err.Error())
+		os.Exit(2)
+	}
+}
+
+// toSize converts a size indication to bytes.
+func toSize(size string) (int64, error) {
+	size = strings.ToUpper(strings.TrimSpace(size))
+	firstLetter := strings.IndexFunc(size, unicode.IsLetter)
+	if firstLetter == -1 {
+		firstLetter = len(size)
+	}
+
+	bytesString, multiple := size[:firstLetter], size[firstLetter:]
+	sz, err := strconv.ParseInt(bytesString, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("unable to parse size: %v", err)
+	}
+	if sz < 0 {
+		return 0, errors.New("negative size given")
+	}
+	switch multiple {
+	case "G", "GB", "GIB":
+		return sz * 1 << 30, nil
+	case "M", "MB", "MIB":
+		return sz * 1 << 20, nil
+	case "K", "KB", "KIB":
+		return sz * 1 << 10, nil
+	case "B", "":
+		return sz, nil
+	default:
+		return 0, fmt.Errorf("unknown size suffix: %v", multiple)
+	}
+}
+
+type wCounter struct {
+	n   int64
+	out io.Writer
+}
+
+func (w *wCounter) Write(p []byte) (n int, err error) {
+	n, err = w.out.Write(p)
+	w.n += int64(n)
+	return n, err
+}
+
+type rCounter struct {
+	n  int64
+	in io.Reader
+}
+
+func (w *rCounter) Read(p []byte) (n int, err error) {
+	n, err = w.in.Read(p)
+	w.n += int64(n)
+	return n, err
+}
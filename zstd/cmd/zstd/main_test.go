@@ -0,0 +1,115 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/snissn/compress/zstd"
+)
+
+func TestRemoveStdout(t *testing.T) {
+	in := bytes.Repeat([]byte("compress this content\n"), 1000)
+	enc, err := zstd.NewWriter(nil, zstd.WithEncoderConcurrency(1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer enc.Close()
+	dec, err := zstd.NewReader(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer dec.Close()
+
+	oldStdout, oldRemove, oldToStdout, oldQuiet, oldThreads := os.Stdout, *remove, *stdout, *quiet, *threads
+	defer func() {
+		os.Stdout, *remove, *stdout, *quiet, *threads = oldStdout, oldRemove, oldToStdout, oldQuiet, oldThreads
+	}()
+	*remove, *quiet, *threads = true, true, 1
+
+	for _, toStdout := range []bool{true, false} {
+		dir := t.TempDir()
+		src := filepath.Join(dir, "file.txt")
+		if err := os.WriteFile(src, in, 0o644); err != nil {
+			t.Fatal(err)
+		}
+		out, err := os.Create(filepath.Join(dir, "stdout"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		os.Stdout = out
+		*stdout = toStdout
+		err = compressFile(enc, src)
+		out.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		dstFilename := src + zstdExt
+		if toStdout {
+			dstFilename = out.Name()
+		}
+		compressed, err := os.ReadFile(dstFilename)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, err := dec.DecodeAll(compressed, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(got, in) {
+			t.Fatal("output mismatch")
+		}
+		_, err = os.Stat(src)
+		if toStdout && err != nil {
+			t.Error("source removed when writing to stdout:", err)
+		}
+		if !toStdout && !os.IsNotExist(err) {
+			t.Error("source not removed:", err)
+		}
+	}
+}
+
+func TestFlags(t *testing.T) {
+	for _, test := range []struct {
+		args []string
+		want bool
+	}{
+		{args: nil, want: false},
+		{args: []string{"--rm"}, want: true},
+		{args: []string{"--rm", "-k"}, want: false},
+		{args: []string{"-k", "--rm"}, want: true},
+	} {
+		var rm bool
+		fs := flag.NewFlagSet("test", flag.ContinueOnError)
+		fs.Var(removeFlag{v: &rm, remove: true}, "rm", "")
+		fs.Var(removeFlag{v: &rm, remove: false}, "k", "")
+		if err := fs.Parse(test.args); err != nil {
+			t.Fatal(err)
+		}
+		if rm != test.want {
+			t.Errorf("%v: got remove %v, want %v", test.args, rm, test.want)
+		}
+	}
+
+	var l longFlag
+	for _, test := range []struct {
+		s    string
+		want longFlag
+		err  bool
+	}{
+		{s: "true", want: 27},
+		{s: "29", want: 29},
+		{s: "10", want: 10},
+		{s: "30", err: true},
+		{s: "9", err: true},
+	} {
+		l = 0
+		err := l.Set(test.s)
+		if (err != nil) != test.err || l != test.want {
+			t.Errorf("--long=%s: got %d, %v", test.s, l, err)
+		}
+	}
+}
@@ -0,0 +1,189 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+
+	"github.com/snissn/compress/zstd"
+)
+
+var (
+	jsonOut = flag.Bool("json", false, "Output as JSON")
+	verbose = flag.Bool("v", false, "List all blocks")
+	help    = flag.Bool("help", false, "Display help")
+
+	version = "(dev)"
+	date    = "(unknown)"
+)
+
+// fileInfo is the JSON output for a file.
+type fileInfo struct {
+	File             string
+	CompressedSize   int64
+	DecompressedSize int64 // -1 if unknown.
+	Frames           []zstd.FrameInfo
+	Error            string `json:",omitempty"`
+}
+
+func main() {
+	flag.Parse()
+	args := flag.Args()
+	if len(args) == 0 || *help {
+		_, _ = fmt.Fprintf(os.Stderr, "zstdinfo v%v, built at %v.\n\n", version, date)
+		_, _ = fmt.Fprintf(os.Stderr, "Copyright (c) 2025+ Klaus Post. All rights reserved.\n\n")
+		_, _ = fmt.Fprintln(os.Stderr, `Usage: zstdinfo [options] file1 file2
+
+Lists frames and blocks of zstd compressed files, similar to 'zstd -lv'.
+Only headers are read, so content and checksums are not verified.
+Use - as the only file name to read from stdin.
+
+Wildcards are accepted: testdir/*.zst will list all files in testdir ending with .zst
+
+Options:`)
+		flag.PrintDefaults()
+		os.Exit(0)
+	}
+
+	var files []string
+	for _, pattern := range args {
+		if pattern == "-" {
+			files = append(files, pattern)
+			continue
+		}
+		found, err := filepath.Glob(pattern)
+		exitErr(err)
+		if len(found) == 0 {
+			exitErr(fmt.Errorf("unable to find file %v", pattern))
+		}
+		files = append(files, found...)
+	}
+
+	var infos []fileInfo
+	failed := false
+	for _, filename := range files {
+		info, err := readFile(filename)
+		if err != nil {
+			failed = true
+			info.Error = err.Error()
+		}
+		if *jsonOut {
+			infos = append(infos, info)
+			continue
+		}
+		printInfo(os.Stdout, &info)
+	}
+	if *jsonOut {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		exitErr(enc.Encode(infos))
+	}
+	if failed {
+		os.Exit(1)
+	}
+}
+
+// readFile will read all frames of a file.
+func readFile(filename string) (fileInfo, error) {
+	info := fileInfo{File: filename, Frames: []zstd.FrameInfo{}}
+	var r io.Reader = os.Stdin
+	if filename != "-" {
+		f, err := os.Open(filename)
+		if err != nil {
+			return info, err
+		}
+		defer f.Close()
+		r = f
+	}
+	err := zstd.WalkFrames(r, func(f *zstd.FrameInfo) error {
+		fi := *f
+		fi.Blocks = append([]zstd.BlockInfo(nil), f.Blocks...)
+		info.CompressedSize += f.Size
+		if info.DecompressedSize >= 0 {
+			if n := f.DecompressedSize(); n >= 0 {
+				info.DecompressedSize += n
+			} else {
+				info.DecompressedSize = -1
+			}
+		}
+		info.Frames = append(info.Frames, fi)
+		return nil
+	})
+	return info, err
+}
+
+// printInfo will print info as text.
+func printInfo(w io.Writer, info *fileInfo) {
+	fmt.Fprintf(w, "%s:\n", info.File)
+	for i, f := range info.Frames {
+		h := &f.Header
+		if h.Skippable {
+			fmt.Fprintf(w, "  Frame %d @%d: skippable, id %d, %d bytes\n", i, f.Offset, h.SkippableID, f.Size)
+			continue
+		}
+		fmt.Fprintf(w, "  Frame %d @%d: %d bytes", i, f.Offset, f.Size)
+		if n := f.DecompressedSize(); n >= 0 {
+			fmt.Fprintf(w, ", decompressed %d bytes", n)
+		} else {
+			fmt.Fprintf(w, ", decompressed size unknown")
+		}
+		if h.SingleSegment {
+			fmt.Fprintf(w, ", single segment")
+		} else {
+			fmt.Fprintf(w, ", window %s", sizeString(h.WindowSize))
+		}
+		if h.DictionaryID != 0 {
+			fmt.Fprintf(w, ", dict ID %d", h.DictionaryID)
+		}
+		if h.HasCheckSum {
+			fmt.Fprintf(w, ", checksum %08x", f.CheckSum)
+		}
+		fmt.Fprintf(w, ", %d blocks\n", len(f.Blocks))
+		if !*verbose {
+			continue
+		}
+		for j, b := range f.Blocks {
+			fmt.Fprintf(w, "    Block %d @%d: %s, %d bytes", j, b.Offset, b.Type, b.CompressedSize)
+			if b.DecompressedSize >= 0 {
+				fmt.Fprintf(w, ", decompressed %d bytes", b.DecompressedSize)
+			}
+			if b.Last {
+				fmt.Fprintf(w, ", last")
+			}
+			fmt.Fprintln(w)
+		}
+	}
+	if info.DecompressedSize >= 0 {
+		fmt.Fprintf(w, "  Total: %d frames, %d -> %d bytes", len(info.Frames), info.CompressedSize, info.DecompressedSize)
+		if info.CompressedSize > 0 {
+			fmt.Fprintf(w, " (ratio %.3f)", float64(info.DecompressedSize)/float64(info.CompressedSize))
+		}
+		fmt.Fprintln(w)
+	} else {
+		fmt.Fprintf(w, "  Total: %d frames, %d bytes, decompressed size unknown\n", len(info.Frames), info.CompressedSize)
+	}
+	if info.Error != "" {
+		fmt.Fprintf(w, "  ERROR: %s\n", info.Error)
+	}
+}
+
+// sizeString returns n with a binary unit if possible.
+func sizeString(n uint64) string {
+	switch {
+	case n >= 1<<20 && n%(1<<20) == 0:
+		return fmt.Sprintf("%dMiB", n>>20)
+	case n >= 1<<10 && n%(1<<10) == 0:
+		return fmt.Sprintf("%dKiB", n>>10)
+	}
+	return fmt.Sprintf("%dB", n)
+}
+
+func exitErr(err error) {
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "\nERROR:", err.Error())
+		os.Exit(2)
+	}
+}
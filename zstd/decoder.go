@@ -7,7 +7,9 @@ package zstd
 import (
 	"context"
 	"encoding/binary"
+	"fmt"
 	"io"
+	"math/bits"
 	"sync"
 
 	"github.com/snissn/compress/zstd/internal/xxhash"
@@ -35,6 +37,9 @@ type Decoder struct {
 		enabled      bool
 		inFrame      bool
 		dstBuf       []byte
+
+		// Used instead of br if WithDecoderRecovery is set.
+		recover recoverDecoder
 	}
 
 	frame *frameDec
@@ -42,6 +47,9 @@ type Decoder struct {
 	// Custom dictionaries.
 	dicts map[uint32]*dict
 
+	// Resolver for dictionaries not in dicts.
+	dictResolver *dictResolver
+
 	// streamWg is the waitgroup for all streams
 	streamWg sync.WaitGroup
 }
@@ -58,6 +66,9 @@ type decoderState struct {
 	// cancel remaining output.
 	cancel context.CancelFunc
 
+	// ctx of the current stream.
+	ctx context.Context
+
 	// crc of current frame
 	crc *xxhash.Digest
 
@@ -96,6 +107,7 @@ func NewReader(r io.Reader, opts ...DOption) (*Decoder, error) {
 	}
 	d.current.crc = xxhash.New()
 	d.current.flushed = true
+	d.current.ctx = context.Background()
 
 	if r == nil {
 		d.current.err = ErrDecoderNilInput
@@ -107,6 +119,9 @@ func NewReader(r io.Reader, opts ...DOption) (*Decoder, error) {
 		d.dicts[dc.id] = dc
 	}
 	d.o.dicts = nil
+	if d.o.dictResolver != nil {
+		d.dictResolver = newDictResolver(d.o.dictResolver, d.o.dictCacheSize)
+	}
 
 	// Create decoders
 	d.decoders = make(chan *blockDec, d.o.concurrent)
@@ -169,6 +184,13 @@ func (d *Decoder) Read(p []byte) (int, error) {
 // After being called with a nil reader, no other operations than Reset or DecodeAll or Close
 // should be used.
 func (d *Decoder) Reset(r io.Reader) error {
+	return d.ResetContext(context.Background(), r)
+}
+
+// ResetContext is like Reset, but decoding of the stream is stopped when ctx is done.
+// When that happens, Read and WriteTo will return ctx.Err() and background decoding is stopped.
+// Cancellation is checked between blocks, so a Read blocked on r is not interrupted.
+func (d *Decoder) ResetContext(ctx context.Context, r io.Reader) error {
 	if d.current.err == ErrDecoderClosed {
 		return d.current.err
 	}
@@ -176,6 +198,8 @@ func (d *Decoder) Reset(r io.Reader) error {
 	d.drainOutput()
 
 	d.syncStream.br.r = nil
+	d.syncStream.recover.br.r = nil
+	d.current.ctx = ctx
 	if r == nil {
 		d.current.err = ErrDecoderNilInput
 		if len(d.current.b) > 0 {
@@ -197,7 +221,7 @@ func (d *Decoder) Reset(r io.Reader) error {
 			dst = d.syncStream.dstBuf[:0]
 		}
 
-		dst, err := d.DecodeAll(b, dst)
+		dst, err := d.decodeAll(ctx, b, dst, nil, nil)
 		if err == nil {
 			err = io.EOF
 		}
@@ -225,19 +249,109 @@ func (d *Decoder) Reset(r io.Reader) error {
 		d.frame = newFrameDec(d.o)
 	}
 
-	if d.o.concurrent == 1 {
+	if d.o.concurrent == 1 || d.o.recovery {
 		return d.startSyncDecoder(r)
 	}
 
-	d.current.output = make(chan decodeOutput, d.o.concurrent)
-	ctx, cancel := context.WithCancel(context.Background())
+	ctx, cancel := context.WithCancel(ctx)
 	d.current.cancel = cancel
 	d.streamWg.Add(1)
+	if d.o.parallelMem > 0 && !d.o.limitToCap && d.o.format == FormatZstd1 {
+		// Output is sent when it is requested, so memory can be released.
+		d.current.output = make(chan decodeOutput)
+		go d.startParallelDecoder(ctx, r, d.current.output)
+		return nil
+	}
+	d.current.output = make(chan decodeOutput, d.o.concurrent)
 	go d.startStreamDecoder(ctx, r, d.current.output)
 
 	return nil
 }
 
+// ResetWithOptions will apply opts to the options of the decoder and reset it
+// to decode r, like Reset.
+// Options that are not given keep their current value.
+// Dictionaries given are added to the registered dictionaries,
+// replacing dictionaries with the same ID.
+// Block decoders and their buffers are kept, so options of a pooled decoder
+// can be changed without allocating all state again.
+// ResetWithOptions must not be called concurrently with other methods, including DecodeAll.
+// If an error is returned, the decoder is unchanged.
+func (d *Decoder) ResetWithOptions(r io.Reader, opts ...DOption) error {
+	return d.resetWithOptions(r, false, opts)
+}
+
+// ResetWithDict will reset the decoder to decode r like Reset,
+// and replace the registered dictionaries with dicts.
+// dicts must be in the format accepted by WithDecoderDicts.
+// If no dictionaries are given, all registered dictionaries are removed.
+// Dictionaries returned by the function set with WithDecoderDictResolver are still used.
+// See ResetWithOptions for details.
+func (d *Decoder) ResetWithDict(r io.Reader, dicts ...[]byte) error {
+	return d.resetWithOptions(r, true, []DOption{WithDecoderDicts(dicts...)})
+}
+
+// resetWithOptions will apply opts and reset the decoder to decode r.
+// If replaceDicts is set, registered dictionaries are replaced by the dictionaries in opts.
+func (d *Decoder) resetWithOptions(r io.Reader, replaceDicts bool, opts []DOption) error {
+	if d.current.err == ErrDecoderClosed {
+		return d.current.err
+	}
+	o := d.o
+	o.dictResolver = nil
+	for _, opt := range opts {
+		if err := opt(&o); err != nil {
+			return err
+		}
+	}
+
+	// Stop the current stream, so all block decoders are returned.
+	d.drainOutput()
+	d.streamWg.Wait()
+
+	if replaceDicts {
+		// The map may be shared with a stopped parallel decoder.
+		d.dicts = make(map[uint32]*dict, len(o.dicts))
+	}
+	for _, dc := range o.dicts {
+		d.dicts[dc.id] = dc
+	}
+	o.dicts = nil
+	fn := o.dictResolver
+	if fn == nil {
+		o.dictResolver = d.o.dictResolver
+	}
+	if o.dictResolver != nil && (fn != nil || o.dictCacheSize != d.o.dictCacheSize) {
+		d.dictResolver = newDictResolver(o.dictResolver, o.dictCacheSize)
+	}
+	d.o = o
+
+	// Update the block decoders, and add or remove decoders if the concurrency changed.
+	decoders := d.decoders
+	if cap(decoders) != o.concurrent {
+		d.decoders = make(chan *blockDec, o.concurrent)
+	}
+	for n := len(decoders); n > 0; n-- {
+		dec := <-decoders
+		if len(d.decoders) == o.concurrent {
+			dec.Close()
+			continue
+		}
+		dec.lowMem = o.lowMem
+		dec.localFrame.setOptions(o)
+		d.decoders <- dec
+	}
+	for len(d.decoders) < o.concurrent {
+		dec := newBlockDec(o.lowMem)
+		dec.localFrame = newFrameDec(o)
+		d.decoders <- dec
+	}
+	if d.frame != nil {
+		d.frame.setOptions(o)
+	}
+	return d.Reset(r)
+}
+
 // drainOutput will drain the output until errEndOfStream is sent.
 func (d *Decoder) drainOutput() {
 	if d.current.cancel != nil {
@@ -307,12 +421,65 @@ func (d *Decoder) WriteTo(w io.Writer) (int64, error) {
 // DecodeAll can be used concurrently.
 // The Decoder concurrency limits will be respected.
 func (d *Decoder) DecodeAll(input, dst []byte) ([]byte, error) {
+	return d.decodeAll(context.Background(), input, dst, nil, nil)
+}
+
+// DecodeAllContext is like DecodeAll, but stops decoding and returns ctx.Err() when ctx is done.
+// Cancellation is checked while waiting for a decoder and between blocks.
+// DecodeAllContext can be used concurrently.
+func (d *Decoder) DecodeAllContext(ctx context.Context, input, dst []byte) ([]byte, error) {
+	return d.decodeAll(ctx, input, dst, nil, nil)
+}
+
+// DecodeAllWithPrefix will decode input compressed with Encoder.EncodeAllWithPrefix
+// and append the output to dst.
+// The prefix must be identical to the one used for compression,
+// and is used for all frames in input instead of any dictionaries.
+// Frames with a dictionary ID will return ErrUnknownDictionary.
+// This is equivalent to ZSTD_DCtx_refPrefix and "zstd -d --patch-from" in the reference implementation.
+// The frame window must be allowed by WithDecoderMaxWindow.
+// DecodeAllWithPrefix can be used concurrently.
+func (d *Decoder) DecodeAllWithPrefix(prefix, input, dst []byte) ([]byte, error) {
+	if len(prefix) == 0 {
+		return d.DecodeAll(input, dst)
+	}
+	if bits.UintSize > 32 && uint(len(prefix)) > dictMaxLength {
+		return dst, fmt.Errorf("prefix of size %d > 2GiB too large", len(prefix))
+	}
+	return d.decodeAll(context.Background(), input, dst, &dict{content: prefix, offsets: [3]int{1, 4, 8}}, nil)
+}
+
+// DecodeAllDict will decode all frames in input using dict and append the output to dst.
+// dict is used instead of dictionaries registered with the decoder.
+// Frames with a dictionary ID other than the ID of dict will return ErrUnknownDictionary.
+// Frames without a dictionary ID are decoded using dict.
+// If dict is nil, DecodeAll is used.
+// This allows a single Decoder to be used with different dictionaries.
+// DecodeAllDict can be used concurrently.
+func (d *Decoder) DecodeAllDict(dict *Dict, input, dst []byte) ([]byte, error) {
+	if dict == nil {
+		return d.DecodeAll(input, dst)
+	}
+	return d.decodeAll(context.Background(), input, dst, dict.d, nil)
+}
+
+// decodeAll will decode input and append it to dst.
+// If prefix is not nil, it is used as dictionary for all frames instead of registered dictionaries.
+// If stats is not nil, the statistics of a frame are stored in it
+// instead of being sent to the function set with WithDecoderStats.
+// Decoding is stopped if ctx is done.
+func (d *Decoder) decodeAll(ctx context.Context, input, dst []byte, prefix *dict, stats *FrameStats) ([]byte, error) {
 	if d.decoders == nil {
 		return dst, ErrDecoderClosed
 	}
 
 	// Grab a block decoder and frame decoder.
-	block := <-d.decoders
+	var block *blockDec
+	select {
+	case <-ctx.Done():
+		return dst, ctx.Err()
+	case block = <-d.decoders:
+	}
 	frame := block.localFrame
 	initialSize := len(dst)
 	defer func() {
@@ -321,13 +488,26 @@ func (d *Decoder) DecodeAll(input, dst []byte) ([]byte, error) {
 		}
 		frame.rawInput = nil
 		frame.bBuf = nil
+		frame.statsOut = nil
+		if prefix != nil {
+			// Don't keep a reference to the prefix.
+			// The literal table of the dictionary must not be returned to the pool.
+			frame.history.freeHuffDecoder()
+			frame.history.huffTree = nil
+			frame.history.dict = nil
+			frame.history.decoders.dict = nil
+		}
 		if frame.history.decoders.br != nil {
 			frame.history.decoders.br.in = nil
 			frame.history.decoders.br.cursor = 0
 		}
 		d.decoders <- block
 	}()
+	if d.o.recovery {
+		return d.decodeAllRecover(ctx, block, input, dst, prefix)
+	}
 	frame.bBuf = input
+	frame.statsOut = stats
 
 	for {
 		frame.history.reset()
@@ -341,7 +521,12 @@ func (d *Decoder) DecodeAll(input, dst []byte) ([]byte, error) {
 			}
 			return dst, err
 		}
-		if err = d.setDict(frame); err != nil {
+		if prefix != nil {
+			if frame.DictionaryID != 0 && frame.DictionaryID != prefix.id {
+				return dst, ErrUnknownDictionary
+			}
+			frame.history.setDict(prefix)
+		} else if err = d.setDict(frame); err != nil {
 			return nil, err
 		}
 		if frame.WindowSize > d.o.maxWindowSize {
@@ -382,7 +567,7 @@ func (d *Decoder) DecodeAll(input, dst []byte) ([]byte, error) {
 			dst = make([]byte, 0, size)
 		}
 
-		dst, err = frame.runDecoder(dst, block)
+		dst, err = frame.runDecoder(ctx, dst, block)
 		if err != nil {
 			return dst, err
 		}
@@ -410,6 +595,10 @@ func (d *Decoder) nextBlock(blocking bool) (ok bool) {
 		return false
 	}
 	d.current.b = d.current.b[:0]
+	if err := d.current.ctx.Err(); err != nil {
+		d.current.err = err
+		return false
+	}
 
 	// SYNC:
 	if d.syncStream.enabled {
@@ -435,8 +624,11 @@ func (d *Decoder) nextBlock(blocking bool) (ok bool) {
 		}
 	}
 	if !ok {
-		// This should not happen, so signal error state...
+		// This should not happen unless the stream was canceled, so signal error state...
 		d.current.err = io.ErrUnexpectedEOF
+		if err := d.current.ctx.Err(); err != nil {
+			d.current.err = err
+		}
 		return false
 	}
 	next := d.current.decodeOutput
@@ -449,27 +641,32 @@ func (d *Decoder) nextBlock(blocking bool) (ok bool) {
 		println("got", len(d.current.b), "bytes, error:", d.current.err, "data crc:", tmp)
 	}
 
-	if d.o.ignoreChecksum {
-		return true
-	}
-
-	if len(next.b) > 0 {
-		d.current.crc.Write(next.b)
-	}
-	if next.err == nil && next.d != nil && next.d.hasCRC {
-		got := uint32(d.current.crc.Sum64())
-		if got != next.d.checkCRC {
-			if debugDecoder {
-				printf("CRC Check Failed: %08x (got) != %08x (on stream)\n", got, next.d.checkCRC)
-			}
-			d.current.err = ErrCRCMismatch
-		} else {
-			if debugDecoder {
-				printf("CRC ok %08x\n", got)
+	if !d.o.ignoreChecksum {
+		// Output without a block has been checked when it was decoded.
+		if len(next.b) > 0 && next.d != nil {
+			d.current.crc.Write(next.b)
+		}
+		if next.err == nil && next.d != nil && next.d.hasCRC {
+			got := uint32(d.current.crc.Sum64())
+			if got != next.d.checkCRC {
+				if debugDecoder {
+					printf("CRC Check Failed: %08x (got) != %08x (on stream)\n", got, next.d.checkCRC)
+				}
+				d.current.err = ErrCRCMismatch
+			} else {
+				if debugDecoder {
+					printf("CRC ok %08x\n", got)
+				}
 			}
 		}
 	}
-
+	if d.o.stats != nil && d.current.err == nil && next.err == nil {
+		if next.d != nil && next.d.Last {
+			d.o.stats(&next.d.async.stats)
+		} else if next.stats != nil {
+			d.o.stats(next.stats)
+		}
+	}
 	return true
 }
 
@@ -477,6 +674,11 @@ func (d *Decoder) nextBlockSync() (ok bool) {
 	if d.current.d == nil {
 		d.current.d = <-d.decoders
 	}
+	if d.o.recovery {
+		d.syncStream.recover.block = d.current.d
+		d.current.b, d.current.err = d.syncStream.recover.next()
+		return d.current.err == nil
+	}
 	for len(d.current.b) == 0 {
 		if !d.syncStream.inFrame {
 			d.frame.history.reset()
@@ -510,6 +712,7 @@ func (d *Decoder) nextBlockSync() (ok bool) {
 			println("error after:", d.current.err)
 			return false
 		}
+		d.frame.addStats(d.current.d)
 		d.current.b = d.frame.history.b[histBefore:]
 		if debugDecoder {
 			println("history after:", len(d.frame.history.b))
@@ -551,6 +754,9 @@ func (d *Decoder) nextBlockSync() (ok bool) {
 				}
 			}
 		}
+		if d.current.d.Last {
+			d.frame.sendStats(d.syncStream.decodedFrame)
+		}
 		d.syncStream.inFrame = !d.current.d.Last
 	}
 	return true
@@ -625,6 +831,10 @@ type decodeOutput struct {
 	d   *blockDec
 	b   []byte
 	err error
+
+	// stats of a frame decoded without a block decoder,
+	// sent when the output is returned.
+	stats *FrameStats
 }
 
 func (d *Decoder) startSyncDecoder(r io.Reader) error {
@@ -633,6 +843,10 @@ func (d *Decoder) startSyncDecoder(r io.Reader) error {
 	d.syncStream.inFrame = false
 	d.syncStream.enabled = true
 	d.syncStream.decodedFrame = 0
+	if d.o.recovery {
+		rec := &d.syncStream.recover
+		rec.reset(d, d.frame, nil, recoverReader{r: r, buf: rec.br.buf[:0]}, nil)
+	}
 	return nil
 }
 
@@ -710,6 +924,7 @@ func (d *Decoder) startStreamDecoder(ctx context.Context, r io.Reader, output ch
 		var decodedFrame uint64
 		var fcs uint64
 		var hasErr bool
+		var stats FrameStats
 		for block := range seqExecute {
 			out := decodeOutput{err: block.err, d: block}
 			if block.err != nil || hasErr {
@@ -739,6 +954,7 @@ func (d *Decoder) startStreamDecoder(ctx context.Context, r io.Reader, output ch
 				hist.b = hist.b[:0]
 				fcs = block.async.fcs
 				decodedFrame = 0
+				stats = FrameStats{}
 			}
 			do := decodeOutput{err: block.err, d: block}
 			switch block.Type {
@@ -790,6 +1006,12 @@ func (d *Decoder) startStreamDecoder(ctx context.Context, r io.Reader, output ch
 					do.err = ErrFrameSizeMismatch
 					hasErr = true
 				} else {
+					stats.add(&block.stats)
+					if block.Last {
+						// Add the blocks to the frame header and checksum sizes.
+						block.async.stats.add(&stats)
+						block.async.stats.Uncompressed = int64(decodedFrame)
+					}
 					if debugDecoder {
 						println("fcs ok", block.Last, fcs, decodedFrame)
 					}
@@ -907,6 +1129,7 @@ decodeStream:
 				} else {
 					dec.checkCRC = binary.LittleEndian.Uint32(crc)
 					dec.hasCRC = true
+					frame.stats.Compressed += 4
 					if debugDecoder {
 						printf("found crc to check: %08x\n", dec.checkCRC)
 					}
@@ -914,6 +1137,9 @@ decodeStream:
 			}
 			err = dec.err
 			last := dec.Last
+			if last && d.o.stats != nil {
+				dec.async.stats = frame.stats
+			}
 			decodeBlock(dec)
 			if err != nil {
 				break decodeStream
@@ -931,6 +1157,13 @@ decodeStream:
 
 func (d *Decoder) setDict(frame *frameDec) (err error) {
 	dict, ok := d.dicts[frame.DictionaryID]
+	if !ok && frame.DictionaryID != 0 && d.dictResolver != nil {
+		dict, err = d.dictResolver.get(frame.DictionaryID)
+		if err != nil {
+			return err
+		}
+		ok = true
+	}
 	if ok {
 		if debugDecoder {
 			println("setting dict", frame.DictionaryID)
@@ -7,6 +7,7 @@ package zstd
 import (
 	"errors"
 	"fmt"
+	"io"
 	"math/bits"
 	"runtime"
 )
@@ -24,6 +25,22 @@ type decoderOptions struct {
 	ignoreChecksum  bool
 	limitToCap      bool
 	decodeBufsBelow int
+	dictResolver    func(id uint32) ([]byte, error)
+	dictCacheSize   int
+	stats           func(s *FrameStats)
+
+	recovery         bool
+	recoveryFn       func(err *CorruptInputError) error
+	recoveryZeroFill bool
+
+	parallelMem int
+
+	skippableCB [16]func(r io.Reader) error
+
+	format FrameFormat
+	// Window size and dictionary ID of frames with FormatMinimal.
+	minimalWindow uint64
+	minimalDictID uint32
 }
 
 func (o *decoderOptions) setDefault() {
@@ -33,6 +50,8 @@ func (o *decoderOptions) setDefault() {
 		concurrent:      runtime.GOMAXPROCS(0),
 		maxWindowSize:   MaxWindowSize,
 		decodeBufsBelow: 128 << 10,
+		dictCacheSize:   16,
+		minimalWindow:   8 << 20,
 	}
 	if o.concurrent > 4 {
 		o.concurrent = 4
@@ -119,6 +138,94 @@ func WithDecoderDictRaw(id uint32, content []byte) DOption {
 	}
 }
 
+// WithDecoderSharedDicts registers one or more dictionaries shared with other encoders and decoders.
+// The dictionaries are not copied or parsed again. See Dict for details.
+//
+// If several dictionaries with the same ID are provided, the last one will be used.
+func WithDecoderSharedDicts(dicts ...*Dict) DOption {
+	return func(o *decoderOptions) error {
+		for _, d := range dicts {
+			if d == nil {
+				return errors.New("nil dictionary")
+			}
+			o.dicts = append(o.dicts, d.d)
+		}
+		return nil
+	}
+}
+
+// WithDecoderDictResolver sets a function that is called to look up dictionaries
+// for frames with a dictionary ID not registered with the decoder.
+// The returned slice may be in the [dictionary format] produced by "zstd --train",
+// in which case the ID of the dictionary must match, or it is used as raw content.
+// Returning a nil slice and no error means the dictionary is unknown.
+//
+// Resolved dictionaries are kept by the decoder, up to the number set with WithDecoderDictCacheSize,
+// and the least recently used are evicted when that is exceeded.
+// fn may be called concurrently, and may be called more than once for the same ID.
+//
+// If resolving fails, decoding returns a *DictResolveError.
+//
+// [dictionary format]: https://github.com/facebook/zstd/blob/dev/doc/zstd_compression_format.md#dictionary-format
+func WithDecoderDictResolver(fn func(id uint32) ([]byte, error)) DOption {
+	return func(o *decoderOptions) error {
+		o.dictResolver = fn
+		return nil
+	}
+}
+
+// WithDecoderDictCacheSize sets the maximum number of dictionaries
+// resolved by the function set with WithDecoderDictResolver to keep.
+// Default is 16.
+func WithDecoderDictCacheSize(n int) DOption {
+	return func(o *decoderOptions) error {
+		if n <= 0 {
+			return errors.New("dictionary cache size must be at least 1")
+		}
+		o.dictCacheSize = n
+		return nil
+	}
+}
+
+// WithDecoderStats will call fn with statistics for every successfully decoded frame.
+// Skippable frames are not reported.
+// Since DecodeAll can be called concurrently, fn may be called concurrently.
+// s is only valid until fn returns.
+// Supplying nil will disable statistics.
+func WithDecoderStats(fn func(s *FrameStats)) DOption {
+	return func(o *decoderOptions) error {
+		o.stats = fn
+		return nil
+	}
+}
+
+// WithDecoderRecovery will make the decoder skip corrupt input instead of failing.
+// When a frame cannot be decoded, the input is searched for the next frame or skippable frame,
+// starting after the frame header, block or checksum that failed, and decoding continues from there.
+// Output from blocks of the frame before the corrupt one is kept.
+// If fn is not nil, it is called with a description of every skipped part of the input.
+// If fn returns an error, decoding stops and the error is returned.
+// Errors reading the input, and ErrDecoderSizeExceeded from WithDecoderMaxMemory,
+// are not recovered.
+// Streams are decoded on a single goroutine when recovery is enabled.
+func WithDecoderRecovery(fn func(err *CorruptInputError) error) DOption {
+	return func(o *decoderOptions) error {
+		o.recovery = true
+		o.recoveryFn = fn
+		return nil
+	}
+}
+
+// WithDecoderRecoveryZeroFill will replace the missing content of corrupt frames with zeros
+// when the content size of the frame is known, so the position of the following output is kept.
+// Only used with WithDecoderRecovery.
+func WithDecoderRecoveryZeroFill(b bool) DOption {
+	return func(o *decoderOptions) error {
+		o.recoveryZeroFill = b
+		return nil
+	}
+}
+
 // WithDecoderMaxWindow allows to set a maximum window size for decodes.
 // This allows rejecting packets that will cause big memory usage.
 // The Decoder will likely allocate more memory based on the WithDecoderLowmem setting.
@@ -137,6 +244,62 @@ func WithDecoderMaxWindow(size uint64) DOption {
 	}
 }
 
+// WithDecoderSkippableCB will register a callback for skippable frames with the specified ID.
+// ID must be less than 16.
+// For each skippable frame with the ID, the callback is called with the content.
+// Content not read by the callback is skipped.
+// Any returned non-nil error will abort decompression.
+// Only one callback per ID is supported, latest sent will be used.
+// Sending a nil function will disable previous callbacks.
+// When frames are decoded concurrently or ahead of the output,
+// the callback may be called before the output of previous frames has been returned.
+// DecodeAll calls the callbacks on the calling goroutine, so callbacks
+// must be safe for concurrent use if DecodeAll is called concurrently.
+func WithDecoderSkippableCB(id uint8, fn func(r io.Reader) error) DOption {
+	return func(o *decoderOptions) error {
+		if id > 15 {
+			return fmt.Errorf("skippable frame id %d must be less than 16", id)
+		}
+		o.skippableCB[id] = fn
+		return nil
+	}
+}
+
+// WithDecoderFormat sets the format of the frames to decode.
+// With FormatMinimal the window size is 8MB and no dictionary is used,
+// matching the encoder defaults. Use WithDecoderMinimalHeader to change these.
+// Skippable frames are only recognized with FormatZstd1.
+// Parallel frame decoding is only used with FormatZstd1.
+// With other formats, WithDecoderRecovery cannot find the next frame,
+// so all input after corrupt input is skipped.
+// Default is FormatZstd1.
+func WithDecoderFormat(f FrameFormat) DOption {
+	return func(o *decoderOptions) error {
+		if f > FormatMinimal {
+			return fmt.Errorf("unknown frame format %d", f)
+		}
+		o.format = f
+		return nil
+	}
+}
+
+// WithDecoderMinimalHeader will decode frames with FormatMinimal,
+// using the given window size and dictionary ID for all frames.
+// These must match the window size and dictionary of the encoder.
+// A dictionary ID of 0 means no dictionary is used.
+// The window size must be at least 1KB and cannot exceed the maximum window size.
+func WithDecoderMinimalHeader(windowSize int, dictID uint32) DOption {
+	return func(o *decoderOptions) error {
+		if windowSize < MinWindowSize {
+			return ErrWindowSizeTooSmall
+		}
+		o.format = FormatMinimal
+		o.minimalWindow = uint64(windowSize)
+		o.minimalDictID = dictID
+		return nil
+	}
+}
+
 // WithDecodeAllCapLimit will limit DecodeAll to decoding cap(dst)-len(dst) bytes,
 // or any size set in WithDecoderMaxMemory.
 // This can be used to limit decoding to a specific maximum output size.
@@ -167,3 +330,25 @@ func IgnoreChecksum(b bool) DOption {
 		return nil
 	}
 }
+
+// WithDecoderParallelFrames will decode the frames of a stream concurrently
+// when the stream contains more than one frame.
+// Frames are read ahead and decoded on up to the number of goroutines set
+// with WithDecoderConcurrency, and the output is returned in order.
+// maxMemory limits the input and output of frames that have been read ahead
+// and not yet returned.
+// Frames that may use more than half of maxMemory are decoded as a stream
+// while they are read.
+// The output size of a frame is known from its content size or its block headers.
+// A value of 0 disables parallel frame decoding, which is the default.
+// It is not used when the concurrency is 1, with WithDecoderRecovery
+// or WithDecodeAllCapLimit, or with formats other than FormatZstd1.
+func WithDecoderParallelFrames(maxMemory int) DOption {
+	return func(o *decoderOptions) error {
+		if maxMemory < 0 {
+			return errors.New("parallel frame memory must not be negative")
+		}
+		o.parallelMem = maxMemory
+		return nil
+	}
+}
@@ -0,0 +1,381 @@
+// Copyright 2025+ Klaus Post. All rights reserved.
+// License information can be found in the LICENSE file.
+
+package zstd
+
+import (
+	"bufio"
+	"context"
+	"io"
+	"sync"
+)
+
+// frameJob is a frame of a stream that is decoded concurrently with other frames.
+type frameJob struct {
+	in    []byte
+	out   []byte
+	stats FrameStats
+	err   error
+	done  chan struct{}
+
+	// size is the number of bytes reserved from the memory budget.
+	size int
+
+	// stream is set if the frame is too big for the memory budget.
+	// The frame is then written to the pipe and decoded as a stream.
+	stream *io.PipeReader
+}
+
+// memBudget limits the memory used by frames of a stream decoded concurrently.
+type memBudget struct {
+	mu   sync.Mutex
+	cond sync.Cond
+	used int
+	max  int
+}
+
+// acquire will wait until n bytes are available and reserve them.
+// If nothing is reserved, n is granted even if it exceeds the budget.
+func (b *memBudget) acquire(ctx context.Context, n int) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for b.used > 0 && b.used+n > b.max {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		b.cond.Wait()
+	}
+	b.used += n
+	return nil
+}
+
+// add will add n bytes to the used memory. n may be negative.
+func (b *memBudget) add(n int) {
+	b.mu.Lock()
+	b.used += n
+	b.mu.Unlock()
+	b.cond.Broadcast()
+}
+
+// startParallelDecoder will decode the frames of r concurrently
+// and send the output to output in order.
+// Frames are read and decoded by this goroutine and the ones it starts,
+// and another goroutine waits for each frame and sends the output.
+func (d *Decoder) startParallelDecoder(ctx context.Context, r io.Reader, output chan decodeOutput) {
+	defer d.streamWg.Done()
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	budget := &memBudget{max: d.o.parallelMem}
+	budget.cond.L = &budget.mu
+	stop := context.AfterFunc(ctx, func() {
+		budget.add(0)
+	})
+	defer stop()
+
+	jobs := make(chan *frameJob, 2*d.o.concurrent)
+	// sem limits the number of frames decoded at the same time.
+	sem := make(chan struct{}, d.o.concurrent)
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		d.writeFrames(ctx, cancel, jobs, output, budget)
+	}()
+
+	br := bufio.NewReaderSize(r, 64<<10)
+	for ctx.Err() == nil {
+		job, err := d.readFrame(ctx, br, jobs, budget, sem, &wg)
+		if job == nil && err == nil {
+			break
+		}
+		if err != nil {
+			job = &frameJob{err: err, done: make(chan struct{})}
+			close(job.done)
+		}
+		if job.stream == nil {
+			select {
+			case jobs <- job:
+			case <-ctx.Done():
+			}
+		}
+		if err != nil {
+			break
+		}
+	}
+	close(jobs)
+	wg.Wait()
+}
+
+// readFrame will read the next frame from br and start decoding it.
+// Skippable frames are skipped, after calling any callback registered for them.
+// The output size is known from the frame content size,
+// or is limited by the block headers.
+// Frames that may use more than half of the memory budget are sent to jobs
+// and written to a pipe while they are read, so they are decoded as a stream.
+// Frames are decoded when a slot in sem is available.
+// If there are no more frames, nil and no error is returned.
+func (d *Decoder) readFrame(ctx context.Context, br *bufio.Reader, jobs chan *frameJob, budget *memBudget, sem chan struct{}, wg *sync.WaitGroup) (*frameJob, error) {
+	var h Header
+	for {
+		hdr, err := br.Peek(HeaderMaxSize)
+		if len(hdr) == 0 && err == io.EOF {
+			return nil, nil
+		}
+		if err != nil && err != io.EOF {
+			return nil, err
+		}
+		if _, err := h.DecodeAndStrip(hdr); err != nil {
+			return nil, err
+		}
+		if !h.Skippable {
+			break
+		}
+		if _, err := br.Discard(h.HeaderSize); err != nil {
+			return nil, err
+		}
+		if err := d.o.skipFrame(&readerWrapper{r: br}, uint8(h.SkippableID), h.SkippableSize); err != nil {
+			return nil, err
+		}
+	}
+
+	job := &frameJob{done: make(chan struct{})}
+	limit := uint64(budget.max / 2)
+	var pw *io.PipeWriter
+	// read will read n bytes of the frame.
+	// If the frame is streamed, the bytes are written to the pipe.
+	read := func(n int) ([]byte, error) {
+		start := len(job.in)
+		job.in = append(job.in, make([]byte, n)...)
+		if _, err := io.ReadFull(br, job.in[start:]); err != nil {
+			if err == io.EOF {
+				err = io.ErrUnexpectedEOF
+			}
+			return nil, err
+		}
+		b := job.in[start:]
+		if pw != nil {
+			_, err := pw.Write(job.in)
+			job.in = job.in[:0]
+			return b, err
+		}
+		return b, nil
+	}
+	// size is the maximum output size.
+	size := uint64(0)
+	if h.HasFCS {
+		if h.FrameContentSize > d.o.maxDecodedSize {
+			return nil, ErrDecoderSizeExceeded
+		}
+		size = h.FrameContentSize
+	}
+	// check will start streaming the frame if it is too big.
+	check := func() error {
+		if pw != nil || (uint64(len(job.in)) <= limit && size <= limit) {
+			return nil
+		}
+		job.stream, pw = io.Pipe()
+		select {
+		case jobs <- job:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+		_, err := pw.Write(job.in)
+		job.in = nil
+		return err
+	}
+	err := func() error {
+		if _, err := read(h.HeaderSize); err != nil {
+			return err
+		}
+		for {
+			if err := check(); err != nil {
+				return err
+			}
+			b, err := read(3)
+			if err != nil {
+				return err
+			}
+			bh := uint32(b[0]) | (uint32(b[1]) << 8) | (uint32(b[2]) << 16)
+			n := int(bh >> 3)
+			switch blockType((bh >> 1) & 3) {
+			case blockTypeRaw:
+				if !h.HasFCS {
+					size += uint64(n)
+				}
+			case blockTypeRLE:
+				if !h.HasFCS {
+					size += uint64(n)
+				}
+				n = 1
+			case blockTypeCompressed:
+				if !h.HasFCS {
+					size += maxCompressedBlockSize
+				}
+			default:
+				return ErrReservedBlockType
+			}
+			if n > maxCompressedBlockSize {
+				return ErrCompressedSizeTooBig
+			}
+			if _, err := read(n); err != nil {
+				return err
+			}
+			if bh&1 != 0 {
+				break
+			}
+		}
+		if h.HasCheckSum {
+			if _, err := read(4); err != nil {
+				return err
+			}
+		}
+		return check()
+	}()
+	if pw != nil {
+		pw.CloseWithError(err)
+		return job, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	// Reserve memory for input and output.
+	// Frames bigger than limit are streamed, but the content size is not trusted,
+	// so the output allocation is also limited here.
+	size = min(size, limit, d.o.maxDecodedSize)
+	job.size = len(job.in) + int(size)
+	if err := budget.acquire(ctx, job.size); err != nil {
+		return nil, err
+	}
+	select {
+	case sem <- struct{}{}:
+	case <-ctx.Done():
+		budget.add(-job.size)
+		return nil, ctx.Err()
+	}
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		defer close(job.done)
+		defer func() { <-sem }()
+		var stats *FrameStats
+		if d.o.stats != nil {
+			stats = &job.stats
+		}
+		job.out, job.err = d.decodeAll(ctx, job.in, make([]byte, 0, size), nil, stats)
+		// The input is no longer needed.
+		budget.add(len(job.out) - job.size)
+		job.size = len(job.out)
+		job.in = nil
+	}()
+	return job, nil
+}
+
+// writeFrames will send the output of jobs to output in order.
+// The memory of a frame is released from the budget when the output is sent.
+// On return, cancel is called and remaining jobs are discarded.
+func (d *Decoder) writeFrames(ctx context.Context, cancel context.CancelFunc, jobs chan *frameJob, output chan decodeOutput, budget *memBudget) {
+	var child *Decoder
+	var stream *io.PipeReader
+	defer func() {
+		cancel()
+		if stream != nil {
+			stream.Close()
+		}
+		if child != nil {
+			child.Close()
+		}
+		for job := range jobs {
+			if job.stream != nil {
+				job.stream.Close()
+			}
+		}
+		close(output)
+	}()
+	send := func(o decodeOutput) bool {
+		select {
+		case output <- o:
+			return o.err == nil
+		case <-ctx.Done():
+			return false
+		}
+	}
+
+	// Output of streamed frames alternates between two buffers.
+	// Output is sent on an unbuffered channel,
+	// so when a send completes the previous output has been consumed.
+	var bufs [2][]byte
+	var nBuf int
+	// Statistics of a streamed frame, sent with the following output.
+	var streamStats *FrameStats
+	for job := range jobs {
+		if job.stream == nil {
+			select {
+			case <-job.done:
+			case <-ctx.Done():
+				return
+			}
+			if job.err != nil {
+				send(decodeOutput{err: job.err})
+				return
+			}
+			var stats *FrameStats
+			if d.o.stats != nil {
+				stats = &job.stats
+			}
+			ok := (len(job.out) == 0 && stats == nil) || send(decodeOutput{b: job.out, stats: stats})
+			budget.add(-job.size)
+			if !ok {
+				return
+			}
+			continue
+		}
+
+		stream = job.stream
+		if child == nil {
+			var err error
+			child, err = NewReader(nil, func(o *decoderOptions) error {
+				*o = d.o
+				o.parallelMem = 0
+				if o.stats != nil {
+					o.stats = func(s *FrameStats) {
+						streamStats = new(FrameStats)
+						*streamStats = *s
+					}
+				}
+				return nil
+			})
+			if err != nil {
+				send(decodeOutput{err: err})
+				return
+			}
+			child.dicts = d.dicts
+			child.dictResolver = d.dictResolver
+			bufs = [2][]byte{make([]byte, maxCompressedBlockSize), make([]byte, maxCompressedBlockSize)}
+		}
+		if err := child.ResetContext(ctx, stream); err != nil {
+			send(decodeOutput{err: err})
+			return
+		}
+		for {
+			buf := bufs[nBuf&1]
+			n, err := child.Read(buf)
+			if n > 0 || streamStats != nil {
+				if !send(decodeOutput{b: buf[:n], stats: streamStats}) {
+					return
+				}
+				streamStats = nil
+				nBuf++
+			}
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				send(decodeOutput{err: err})
+				return
+			}
+		}
+		stream = nil
+	}
+	send(decodeOutput{err: io.EOF})
+}
@@ -0,0 +1,170 @@
+package zstd
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"os"
+	"slices"
+	"testing"
+	"testing/iotest"
+)
+
+func TestDecoderParallelFrames(t *testing.T) {
+	in, err := os.ReadFile("../testdata/Mark.Twain-Tom.Sawyer.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	enc, err := NewWriter(nil, WithEncoderConcurrency(1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer enc.Close()
+
+	// Frames of different sizes, with and without content size,
+	// an empty frame and a skippable frame.
+	var comp, want []byte
+	for i := 0; i < len(in); i += 10000 * (i%7 + 1) {
+		b := in[i:min(len(in), i+10000*(i%7+1))]
+		comp = enc.EncodeAll(b, comp)
+		want = append(want, b...)
+	}
+	comp = enc.EncodeAll(nil, comp)
+	comp, err = skippableFrame(comp, 100, bytes.NewReader(make([]byte, 100)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	enc.Reset(&buf)
+	enc.Write(in)
+	if err := enc.Close(); err != nil {
+		t.Fatal(err)
+	}
+	comp = append(comp, buf.Bytes()...)
+	want = append(want, in...)
+	comp = enc.EncodeAll(in[:1000], comp)
+	want = append(want, in[:1000]...)
+
+	for _, mem := range []int{100 << 10, 1 << 20, 64 << 20} {
+		dec, err := NewReader(nil, WithDecoderConcurrency(4), WithDecoderParallelFrames(mem))
+		if err != nil {
+			t.Fatal(err)
+		}
+		// Hide Bytes, so the stream decoder is used.
+		err = dec.Reset(struct{ io.Reader }{bytes.NewReader(comp)})
+		if err != nil {
+			t.Fatal(err)
+		}
+		var out bytes.Buffer
+		if _, err := dec.WriteTo(&out); err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(out.Bytes(), want) {
+			t.Errorf("memory %d: output mismatch, got %d bytes, want %d", mem, out.Len(), len(want))
+		}
+		// Read a few bytes at a time.
+		err = dec.Reset(iotest.HalfReader(bytes.NewReader(comp)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, err := io.ReadAll(iotest.OneByteReader(io.LimitReader(dec, 100000)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(got, want[:100000]) {
+			t.Errorf("memory %d: partial read mismatch", mem)
+		}
+		// Reset before reading everything.
+		err = dec.Reset(bytes.NewReader(comp[:len(comp)-10]))
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, err = io.ReadAll(dec)
+		if err == nil || !bytes.Equal(got, want[:len(got)]) {
+			t.Errorf("memory %d: truncated input: got %v after %d bytes", mem, err, len(got))
+		}
+		dec.Close()
+	}
+
+	// Errors are returned after the preceding output.
+	dec, err := NewReader(nil, WithDecoderConcurrency(4), WithDecoderParallelFrames(1<<20))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer dec.Close()
+	bad := append(enc.EncodeAll(in[:5000], nil), "garbage"...)
+	bad = enc.EncodeAll(in[5000:6000], bad)
+	dec.Reset(bytes.NewReader(bad))
+	got, err := io.ReadAll(dec)
+	if !errors.Is(err, ErrMagicMismatch) || !bytes.Equal(got, in[:5000]) {
+		t.Errorf("got %v after %d bytes, want ErrMagicMismatch after 5000", err, len(got))
+	}
+	errRead := errors.New("read error")
+	dec.Reset(io.MultiReader(bytes.NewReader(comp[:len(comp)/2]), iotest.ErrReader(errRead)))
+	if _, err = io.ReadAll(dec); err != errRead {
+		t.Errorf("got %v, want read error", err)
+	}
+
+	// A frame content size above the maximum decoded size is rejected.
+	dec, err = NewReader(nil, WithDecoderConcurrency(4), WithDecoderParallelFrames(1<<20), WithDecoderMaxMemory(1<<20))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer dec.Close()
+	// Single segment frame with an 8 byte content size and an empty raw block.
+	huge := append([]byte(frameMagic), 0xe0, 0, 0, 0, 0, 0, 1, 0, 0, 1, 0, 0)
+	dec.Reset(bytes.NewReader(append(enc.EncodeAll(in[:5000], nil), huge...)))
+	got, err = io.ReadAll(dec)
+	if !errors.Is(err, ErrDecoderSizeExceeded) || !bytes.Equal(got, in[:5000]) {
+		t.Errorf("got %v after %d bytes, want ErrDecoderSizeExceeded after 5000", err, len(got))
+	}
+}
+
+func TestDecoderParallelFramesStats(t *testing.T) {
+	in, err := os.ReadFile("../testdata/Mark.Twain-Tom.Sawyer.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	enc, err := NewWriter(nil, WithEncoderConcurrency(1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer enc.Close()
+	var comp []byte
+	for i := 0; i < len(in); i += 50000 {
+		comp = enc.EncodeAll(in[i:min(len(in), i+50000)], comp)
+	}
+	comp = enc.EncodeAll(nil, comp)
+	comp = enc.EncodeAll(in, comp)
+
+	decode := func(opts ...DOption) []FrameStats {
+		var got []FrameStats
+		opts = append(opts, WithDecoderStats(func(s *FrameStats) {
+			got = append(got, *s)
+		}))
+		dec, err := NewReader(nil, opts...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer dec.Close()
+		// Hide Bytes, so the stream decoder is used.
+		if err := dec.Reset(struct{ io.Reader }{bytes.NewReader(comp)}); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := io.Copy(io.Discard, dec); err != nil {
+			t.Fatal(err)
+		}
+		return got
+	}
+	want := decode(WithDecoderConcurrency(1))
+	if len(want) == 0 {
+		t.Fatal("no statistics")
+	}
+	// With 100KB the last frame is streamed.
+	for _, mem := range []int{100 << 10, 64 << 20} {
+		got := decode(WithDecoderConcurrency(4), WithDecoderParallelFrames(mem))
+		if !slices.Equal(got, want) {
+			t.Errorf("memory %d: got %+v, want %+v", mem, got, want)
+		}
+	}
+}
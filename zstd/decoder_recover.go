@@ -0,0 +1,384 @@
+// Copyright 2025+ Klaus Post. All rights reserved.
+// License information can be found in the LICENSE file.
+
+package zstd
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+)
+
+// CorruptInputError describes corrupt input that was skipped
+// when recovery is enabled with WithDecoderRecovery.
+type CorruptInputError struct {
+	// FrameOffset is the input offset of the frame containing the corrupt data.
+	FrameOffset int64
+
+	// Offset is the input offset of the frame header, block header
+	// or checksum that could not be decoded.
+	Offset int64
+
+	// Skipped is the number of input bytes from Offset to the next frame.
+	Skipped int64
+
+	// ZeroFilled is the number of zero bytes added to the output
+	// in place of the missing content of the frame.
+	ZeroFilled int64
+
+	// Err is the decoding error.
+	Err error
+}
+
+func (e *CorruptInputError) Error() string {
+	return fmt.Sprintf("corrupt input at offset %d (frame at %d), skipped %d bytes: %v", e.Offset, e.FrameOffset, e.Skipped, e.Err)
+}
+
+// Unwrap returns the decoding error.
+func (e *CorruptInputError) Unwrap() error {
+	return e.Err
+}
+
+// recoverDecoder decodes input block by block,
+// and skips to the next frame when corrupt input is found.
+type recoverDecoder struct {
+	d      *Decoder
+	frame  *frameDec
+	block  *blockDec
+	br     recoverReader
+	prefix *dict
+
+	inFrame     bool
+	decoded     uint64 // Output of the current frame.
+	frameOffset int64
+	zeros       int64 // Zeros left to output.
+	zeroBuf     []byte
+}
+
+// reset will start decoding br using frame and block.
+func (r *recoverDecoder) reset(d *Decoder, frame *frameDec, block *blockDec, br recoverReader, prefix *dict) {
+	*r = recoverDecoder{d: d, frame: frame, block: block, br: br, prefix: prefix, zeroBuf: r.zeroBuf}
+}
+
+// next returns the next decoded output.
+// The output is valid until the next call.
+// io.EOF is returned when all input has been decoded.
+func (r *recoverDecoder) next() ([]byte, error) {
+	frame := r.frame
+	for {
+		if r.zeros > 0 {
+			if r.zeroBuf == nil {
+				r.zeroBuf = make([]byte, maxCompressedBlockSize)
+			}
+			n := min(r.zeros, int64(len(r.zeroBuf)))
+			r.zeros -= n
+			return r.zeroBuf[:n], nil
+		}
+		if !r.inFrame {
+			r.br.mark()
+			r.frameOffset = r.br.offset()
+			err := r.skipFrames()
+			if err != nil && err != io.ErrUnexpectedEOF {
+				// Read errors and errors from skippable frame callbacks.
+				return nil, err
+			}
+			if err == nil {
+				r.frameOffset = r.br.offset()
+				frame.history.reset()
+				err = frame.reset(&r.br)
+			}
+			if err == io.EOF {
+				return nil, io.EOF
+			}
+			if err == nil {
+				err = r.setDict()
+			}
+			if err == nil && (frame.WindowSize > r.d.o.maxDecodedSize || frame.WindowSize > r.d.o.maxWindowSize) {
+				err = ErrWindowSizeExceeded
+			}
+			if err != nil {
+				if err := r.recover(r.frameOffset, err); err != nil {
+					return nil, err
+				}
+				continue
+			}
+			r.inFrame = true
+			r.decoded = 0
+		}
+
+		r.br.mark()
+		offset := r.br.offset()
+		err := frame.next(r.block)
+		var out []byte
+		if err == nil {
+			frame.history.ensureBlock()
+			before := len(frame.history.b)
+			err = r.block.decodeBuf(&frame.history)
+			out = frame.history.b[before:]
+		}
+		if err == nil {
+			n := r.decoded + uint64(len(out))
+			if n > frame.FrameContentSize {
+				err = ErrFrameSizeExceeded
+			} else if r.block.Last && frame.FrameContentSize != fcsUnknown && n != frame.FrameContentSize {
+				err = ErrFrameSizeMismatch
+			} else {
+				r.decoded = n
+				frame.addStats(r.block)
+			}
+		}
+		if err != nil {
+			if err := r.recover(offset, err); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		if frame.HasCheckSum && !r.d.o.ignoreChecksum {
+			frame.crc.Write(out)
+		}
+		if r.block.Last {
+			r.inFrame = false
+			if frame.HasCheckSum {
+				offset := r.br.offset()
+				if r.d.o.ignoreChecksum {
+					err = frame.consumeCRC()
+				} else {
+					err = frame.checkCRC()
+				}
+				if err != nil {
+					// The content is complete, so it is returned.
+					if err := r.recover(offset, err); err != nil {
+						return nil, err
+					}
+				}
+			}
+			if err == nil {
+				frame.sendStats(r.decoded)
+			}
+		}
+		if len(out) > 0 {
+			return out, nil
+		}
+	}
+}
+
+// skipFrames will skip skippable frames,
+// so the offset of the next frame is known before it is read.
+func (r *recoverDecoder) skipFrames() error {
+	if r.d.o.format != FormatZstd1 {
+		return nil
+	}
+	br := &r.br
+	for br.fill(8) {
+		b := br.buf[br.pos:]
+		if string(b[1:4]) != skippableFrameMagic || b[0]&0xf0 != 0x50 {
+			return nil
+		}
+		br.pos += 8
+		if err := r.d.o.skipFrame(br, b[0]&0xf, binary.LittleEndian.Uint32(b[4:8])); err != nil {
+			return err
+		}
+		br.mark()
+		r.frameOffset = br.offset()
+	}
+	return nil
+}
+
+// setDict will set the dictionary of the current frame.
+func (r *recoverDecoder) setDict() error {
+	if r.prefix == nil {
+		return r.d.setDict(r.frame)
+	}
+	if r.frame.DictionaryID != 0 && r.frame.DictionaryID != r.prefix.id {
+		return ErrUnknownDictionary
+	}
+	r.frame.history.setDict(r.prefix)
+	return nil
+}
+
+// recover will skip to the next frame after the corrupt input at offset
+// and report it to the function set with WithDecoderRecovery.
+// Read errors from the input are returned.
+func (r *recoverDecoder) recover(offset int64, err error) error {
+	if r.br.err != nil && r.br.err != io.EOF {
+		return r.br.err
+	}
+	e := CorruptInputError{FrameOffset: r.frameOffset, Offset: offset, Err: err}
+	if r.inFrame && r.d.o.recoveryZeroFill && r.frame.FrameContentSize != fcsUnknown && r.decoded < r.frame.FrameContentSize {
+		e.ZeroFilled = int64(r.frame.FrameContentSize - r.decoded)
+		r.zeros = e.ZeroFilled
+	}
+	r.inFrame = false
+	if r.d.o.format == FormatZstd1 {
+		e.Skipped = r.br.resync(offset+1) - offset
+	} else {
+		// Frames cannot be found without magic, so the remaining input is skipped.
+		_ = r.br.skipN(math.MaxInt64)
+		e.Skipped = r.br.offset() - offset
+	}
+	if r.br.err != nil && r.br.err != io.EOF {
+		return r.br.err
+	}
+	if fn := r.d.o.recoveryFn; fn != nil {
+		return fn(&e)
+	}
+	return nil
+}
+
+// decodeAllRecover will decode input and append it to dst,
+// skipping corrupt input.
+func (d *Decoder) decodeAllRecover(ctx context.Context, block *blockDec, input, dst []byte, prefix *dict) ([]byte, error) {
+	var r recoverDecoder
+	r.reset(d, block.localFrame, block, recoverReader{buf: input}, prefix)
+	initialSize := len(dst)
+	for {
+		if err := ctx.Err(); err != nil {
+			return dst, err
+		}
+		out, err := r.next()
+		if err != nil {
+			if err == io.EOF {
+				return dst, nil
+			}
+			return dst, err
+		}
+		if uint64(len(dst)-initialSize+len(out)) > d.o.maxDecodedSize {
+			return dst, ErrDecoderSizeExceeded
+		}
+		dst = append(dst, out...)
+	}
+}
+
+// recoverReader is a byteBuffer that tracks the input offset
+// and keeps the input since the last mark, so it can be searched for the next frame.
+type recoverReader struct {
+	r   io.Reader // nil if all input is in buf.
+	buf []byte    // Input since the last mark. buf[pos:] is unread.
+	pos int
+	off int64 // Input offset of buf[0].
+	err error // Error returned by r.
+}
+
+// fill will try to read until at least n bytes are unread.
+func (r *recoverReader) fill(n int) bool {
+	for len(r.buf)-r.pos < n && r.r != nil && r.err == nil {
+		if cap(r.buf)-len(r.buf) < max(n, 4<<10) {
+			buf := make([]byte, len(r.buf), max(2*cap(r.buf), len(r.buf)+n, 64<<10))
+			copy(buf, r.buf)
+			r.buf = buf
+		}
+		m, err := r.r.Read(r.buf[len(r.buf):cap(r.buf)])
+		r.buf = r.buf[:len(r.buf)+m]
+		r.err = err
+	}
+	return len(r.buf)-r.pos >= n
+}
+
+// readErr returns the error for a read that could not be satisfied.
+func (r *recoverReader) readErr() error {
+	if r.err != nil && r.err != io.EOF {
+		return r.err
+	}
+	return io.ErrUnexpectedEOF
+}
+
+func (r *recoverReader) readSmall(n int) ([]byte, error) {
+	if !r.fill(n) {
+		return nil, r.readErr()
+	}
+	b := r.buf[r.pos : r.pos+n]
+	r.pos += n
+	return b, nil
+}
+
+func (r *recoverReader) readBig(n int, dst []byte) ([]byte, error) {
+	if !r.fill(n) {
+		return nil, r.readErr()
+	}
+	b := r.buf[r.pos : r.pos+n]
+	r.pos += n
+	if r.r == nil {
+		return b, nil
+	}
+	// The buffer may be overwritten by the next read.
+	return append(dst[:0], b...), nil
+}
+
+func (r *recoverReader) readByte() (byte, error) {
+	if !r.fill(1) {
+		return 0, r.readErr()
+	}
+	r.pos++
+	return r.buf[r.pos-1], nil
+}
+
+func (r *recoverReader) skipN(n int64) error {
+	if n < 0 {
+		return fmt.Errorf("negative skip (%d) requested", n)
+	}
+	if avail := int64(len(r.buf) - r.pos); n <= avail || r.r == nil {
+		if n > avail {
+			r.pos = len(r.buf)
+			return io.ErrUnexpectedEOF
+		}
+		r.pos += int(n)
+		return nil
+	}
+	// Discard buffered input and skip the rest in the reader.
+	n -= int64(len(r.buf) - r.pos)
+	r.off += int64(len(r.buf))
+	r.buf, r.pos = r.buf[:0], 0
+	n2, err := io.CopyN(io.Discard, r.r, n)
+	r.off += n2
+	if n2 != n {
+		if err != nil && err != io.EOF {
+			r.err = err
+			return err
+		}
+		r.err = io.EOF
+		return io.ErrUnexpectedEOF
+	}
+	return nil
+}
+
+// offset returns the input offset of the next byte.
+func (r *recoverReader) offset() int64 {
+	return r.off + int64(r.pos)
+}
+
+// mark will discard input before the next byte.
+func (r *recoverReader) mark() {
+	r.off += int64(r.pos)
+	if r.r == nil {
+		r.buf = r.buf[r.pos:]
+	} else {
+		r.buf = r.buf[:copy(r.buf, r.buf[r.pos:])]
+	}
+	r.pos = 0
+}
+
+// resync will move to the first frame or skippable frame magic at or after offset from,
+// or the end of input if none is found.
+// If from is before the kept input, the search starts at the first kept byte.
+// The new offset is returned.
+func (r *recoverReader) resync(from int64) int64 {
+	r.pos = int(min(max(from-r.off, 0), int64(len(r.buf))))
+	for {
+		r.mark()
+		for i := 0; i+4 <= len(r.buf); i++ {
+			b := r.buf[i : i+4]
+			if string(b) == frameMagic || (string(b[1:]) == skippableFrameMagic && b[0]&0xf0 == 0x50) {
+				r.pos = i
+				return r.offset()
+			}
+		}
+		// Keep the last 3 bytes, since they may be the start of a magic.
+		r.pos = max(len(r.buf)-3, 0)
+		if !r.fill(4) {
+			r.pos = len(r.buf)
+			return r.offset()
+		}
+	}
+}
@@ -0,0 +1,136 @@
+package zstd
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"os"
+	"testing"
+	"testing/iotest"
+)
+
+func TestDecoderRecovery(t *testing.T) {
+	in, err := os.ReadFile("../testdata/Mark.Twain-Tom.Sawyer.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	enc, err := NewWriter(nil, WithEncoderConcurrency(1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer enc.Close()
+
+	// Four frames with a skippable frame between the first two.
+	var comp []byte
+	chunks := [][]byte{in[:50000], in[50000:150000], in[150000:250000], in[250000:]}
+	for i, c := range chunks {
+		comp = enc.EncodeAll(c, comp)
+		if i == 0 {
+			comp, err = skippableFrame(comp, 100, bytes.NewReader(make([]byte, 100)))
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	var frames []FrameInfo
+	err = WalkFrames(bytes.NewReader(comp), func(f *FrameInfo) error {
+		if !f.Header.Skippable {
+			frames = append(frames, *f)
+		}
+		return nil
+	})
+	if err != nil || len(frames) != 4 {
+		t.Fatal(err, len(frames))
+	}
+	// Set a reserved block type on the first block of frame 1.
+	bad := frames[1].Offset + int64(frames[1].Header.HeaderSize)
+	comp[bad] |= 3 << 1
+	// Add garbage at the end.
+	comp = append(comp, "garbage"...)
+
+	dec, err := NewReader(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := dec.DecodeAll(comp, nil); err == nil {
+		t.Fatal("no error without recovery")
+	}
+	dec.Close()
+
+	for _, zeroFill := range []bool{false, true} {
+		var want []byte
+		for i, c := range chunks {
+			switch {
+			case i != 1:
+				want = append(want, c...)
+			case zeroFill:
+				want = append(want, make([]byte, len(c))...)
+			}
+		}
+		var got []CorruptInputError
+		fn := func(e *CorruptInputError) error {
+			got = append(got, *e)
+			return nil
+		}
+		check := func(t *testing.T, out []byte, err error) {
+			t.Helper()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(out, want) {
+				t.Errorf("output mismatch, got %d bytes, want %d", len(out), len(want))
+			}
+			if len(got) != 2 {
+				t.Fatalf("want 2 errors, got %+v", got)
+			}
+			e := got[0]
+			if e.FrameOffset != frames[1].Offset || e.Offset != bad || e.Offset+e.Skipped != frames[2].Offset || !errors.Is(&e, ErrReservedBlockType) {
+				t.Errorf("unexpected error %+v (%v), want frame %d, offset %d", e, &e, frames[1].Offset, bad)
+			}
+			if zeroFill && e.ZeroFilled != int64(len(chunks[1])) || !zeroFill && e.ZeroFilled != 0 {
+				t.Errorf("zero filled %d", e.ZeroFilled)
+			}
+			e = got[1]
+			if e.FrameOffset != int64(len(comp)-7) || e.Offset != e.FrameOffset || e.Skipped != 7 || !errors.Is(&e, ErrMagicMismatch) {
+				t.Errorf("unexpected error %+v (%v)", e, &e)
+			}
+			got = got[:0]
+		}
+		for _, conc := range []int{1, 4} {
+			dec, err := NewReader(nil, WithDecoderConcurrency(conc), WithDecoderRecovery(fn), WithDecoderRecoveryZeroFill(zeroFill))
+			if err != nil {
+				t.Fatal(err)
+			}
+			out, err := dec.DecodeAll(comp, nil)
+			check(t, out, err)
+			// Read a few bytes at a time.
+			err = dec.Reset(iotest.HalfReader(bytes.NewReader(comp)))
+			if err != nil {
+				t.Fatal(err)
+			}
+			out, err = io.ReadAll(dec)
+			check(t, out, err)
+			dec.Close()
+		}
+	}
+
+	// Stop when the callback returns an error.
+	errStop := errors.New("stop")
+	dec, err = NewReader(bytes.NewReader(comp), WithDecoderRecovery(func(e *CorruptInputError) error {
+		return errStop
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer dec.Close()
+	out, err := io.ReadAll(dec)
+	if err != errStop || !bytes.Equal(out, chunks[0]) {
+		t.Errorf("got %v after %d bytes, want errStop after %d", err, len(out), len(chunks[0]))
+	}
+	// Read errors are not recovered.
+	errRead := errors.New("read error")
+	dec.Reset(io.MultiReader(bytes.NewReader(comp[:bad]), iotest.ErrReader(errRead)))
+	if _, err = io.ReadAll(dec); err != errRead {
+		t.Errorf("got %v, want read error", err)
+	}
+}
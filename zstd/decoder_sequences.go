@@ -0,0 +1,282 @@
+// Copyright 2025+ Klaus Post. All rights reserved.
+// License information can be found in the LICENSE file.
+
+package zstd
+
+import (
+	"errors"
+	"io"
+)
+
+// BlockType is the type of a block.
+type BlockType uint8
+
+const (
+	// BlockTypeRaw is an uncompressed block.
+	BlockTypeRaw BlockType = BlockType(blockTypeRaw)
+
+	// BlockTypeRLE is a block with a single byte repeated.
+	BlockTypeRLE BlockType = BlockType(blockTypeRLE)
+
+	// BlockTypeCompressed is a compressed block with literals and sequences.
+	BlockTypeCompressed BlockType = BlockType(blockTypeCompressed)
+)
+
+// String returns the name of the block type.
+func (b BlockType) String() string {
+	switch b {
+	case BlockTypeRaw:
+		return "raw"
+	case BlockTypeRLE:
+		return "rle"
+	case BlockTypeCompressed:
+		return "compressed"
+	}
+	return "invalid"
+}
+
+// MarshalText returns the name of the block type.
+func (b BlockType) MarshalText() ([]byte, error) {
+	return []byte(b.String()), nil
+}
+
+// LiteralsMode is the encoding of the literals in a compressed block.
+type LiteralsMode uint8
+
+const (
+	// LiteralsModeRaw are uncompressed literals.
+	LiteralsModeRaw LiteralsMode = LiteralsMode(literalsBlockRaw)
+
+	// LiteralsModeRLE is a single byte repeated.
+	LiteralsModeRLE LiteralsMode = LiteralsMode(literalsBlockRLE)
+
+	// LiteralsModeCompressed are Huffman compressed literals with a new table.
+	LiteralsModeCompressed LiteralsMode = LiteralsMode(literalsBlockCompressed)
+
+	// LiteralsModeTreeless are Huffman compressed literals using the table of a previous block.
+	LiteralsModeTreeless LiteralsMode = LiteralsMode(literalsBlockTreeless)
+)
+
+// String returns the name of the literals mode.
+func (l LiteralsMode) String() string {
+	switch l {
+	case LiteralsModeRaw:
+		return "raw"
+	case LiteralsModeRLE:
+		return "rle"
+	case LiteralsModeCompressed:
+		return "compressed"
+	case LiteralsModeTreeless:
+		return "treeless"
+	}
+	return "invalid"
+}
+
+// TableMode is the encoding of a sequence table in a compressed block.
+type TableMode uint8
+
+const (
+	// TableModePredefined uses the predefined table.
+	TableModePredefined TableMode = TableMode(compModePredefined)
+
+	// TableModeRLE uses a single symbol.
+	TableModeRLE TableMode = TableMode(compModeRLE)
+
+	// TableModeFSE uses a new FSE table.
+	TableModeFSE TableMode = TableMode(compModeFSE)
+
+	// TableModeRepeat uses the table of a previous block.
+	TableModeRepeat TableMode = TableMode(compModeRepeat)
+)
+
+// String returns the name of the table mode.
+func (t TableMode) String() string {
+	switch t {
+	case TableModePredefined:
+		return "predefined"
+	case TableModeRLE:
+		return "rle"
+	case TableModeFSE:
+		return "fse"
+	case TableModeRepeat:
+		return "repeat"
+	}
+	return "invalid"
+}
+
+// SequenceBlock contains a block decoded to literals and sequences.
+type SequenceBlock struct {
+	// Frame is the index of the frame in the input, starting at 0.
+	// Skippable frames are not counted.
+	Frame int
+
+	// Type of the block.
+	Type BlockType
+
+	// Last is set if this is the last block of the frame.
+	Last bool
+
+	// CompressedSize is the size of the block content in the stream.
+	// Does not include the block header.
+	CompressedSize int
+
+	// DecompressedSize is the size of the block when decoded.
+	DecompressedSize int
+
+	// Literals contains the literals of the block.
+	// For raw and RLE blocks this is the content of the block.
+	Literals []byte
+
+	// Sequences of the block.
+	// Offsets are actual offsets, with repeat offsets resolved.
+	// Literals following the last sequence are not included.
+	// Offsets may reach into a dictionary or previous frames if a prefix was used.
+	Sequences []Sequence
+
+	// LiteralsMode is the encoding of the literals.
+	// Only set for compressed blocks.
+	LiteralsMode LiteralsMode
+
+	// Table modes of the literal length, offset and match length tables.
+	// Only set for compressed blocks with sequences.
+	LitLenMode, OffsetMode, MatchLenMode TableMode
+}
+
+// DecodeSequences will decode all frames in input to literals and sequences
+// and call fn for each block in order, similar to ZSTD_generateSequences.
+// Sequences are not executed, so no output is produced
+// and checksums are not verified.
+// The block and its content is only valid until fn returns.
+// If fn returns an error, decoding is stopped and the error is returned.
+// Dictionaries registered with the decoder are used.
+func (d *Decoder) DecodeSequences(input []byte, fn func(b *SequenceBlock) error) error {
+	if d.decoders == nil {
+		return ErrDecoderClosed
+	}
+	block := <-d.decoders
+	frame := block.localFrame
+	defer func() {
+		frame.rawInput = nil
+		frame.bBuf = nil
+		hist := &frame.history
+		hist.decoders.out, hist.decoders.literals = nil, nil
+		if hist.decoders.br != nil {
+			hist.decoders.br.in = nil
+			hist.decoders.br.cursor = 0
+		}
+		d.decoders <- block
+	}()
+	frame.bBuf = input
+
+	var sb SequenceBlock
+	var rle []byte
+	for nFrame := 0; ; nFrame++ {
+		frame.history.reset()
+		err := frame.reset(&frame.bBuf)
+		if err != nil {
+			if err == io.EOF {
+				return nil
+			}
+			return err
+		}
+		if err = d.setDict(frame); err != nil {
+			return err
+		}
+		hist := &frame.history
+		for {
+			err = block.reset(frame.rawInput, frame.WindowSize)
+			if err != nil {
+				return err
+			}
+			sb = SequenceBlock{
+				Frame:          nFrame,
+				Type:           BlockType(block.Type),
+				Last:           block.Last,
+				CompressedSize: len(block.data),
+				Sequences:      sb.Sequences[:0],
+			}
+			switch block.Type {
+			case blockTypeRaw:
+				sb.Literals = block.data
+			case blockTypeRLE:
+				rle = rle[:0]
+				for range block.RLESize {
+					rle = append(rle, block.data[0])
+				}
+				sb.Literals = rle
+			case blockTypeCompressed:
+				if err := block.decodeSequenceBlock(hist, &sb); err != nil {
+					return err
+				}
+			default:
+				return ErrReservedBlockType
+			}
+			sb.DecompressedSize = len(sb.Literals)
+			for _, s := range sb.Sequences {
+				sb.DecompressedSize += int(s.MatchLen)
+			}
+			if err := fn(&sb); err != nil {
+				return err
+			}
+			if block.Last {
+				break
+			}
+		}
+		if frame.HasCheckSum {
+			if err := frame.consumeCRC(); err != nil {
+				return err
+			}
+		}
+		if len(frame.bBuf) == 0 {
+			return nil
+		}
+	}
+}
+
+// decodeSequenceBlock will decode the literals and sequences of a compressed block to sb.
+func (b *blockDec) decodeSequenceBlock(hist *history, sb *SequenceBlock) error {
+	in := b.data
+	if len(in) == 0 {
+		return ErrBlockTooSmall
+	}
+	sb.LiteralsMode = LiteralsMode(in[0] & 3)
+	in, err := b.decodeLiterals(in, hist)
+	if err != nil {
+		return err
+	}
+	// Read table modes. Values are checked by prepareSequences.
+	var modes []byte
+	switch {
+	case len(in) >= 2 && in[0] > 0 && in[0] < 128:
+		modes = in[1:]
+	case len(in) >= 3 && in[0] >= 128 && in[0] < 255:
+		modes = in[2:]
+	case len(in) >= 4 && in[0] == 255:
+		modes = in[3:]
+	}
+	if len(modes) > 0 {
+		sb.LitLenMode = TableMode((modes[0] >> 6) & 3)
+		sb.OffsetMode = TableMode((modes[0] >> 4) & 3)
+		sb.MatchLenMode = TableMode((modes[0] >> 2) & 3)
+	}
+	b.dst = b.dst[:0]
+	if err := b.prepareSequences(in, hist); err != nil {
+		return err
+	}
+	if err := b.decodeSequences(hist); err != nil {
+		return err
+	}
+	sb.Literals = hist.decoders.literals
+	litLen := 0
+	for _, s := range b.sequence {
+		if s.mo <= 0 || s.ml <= 0 {
+			return errors.New("invalid sequence")
+		}
+		litLen += s.ll
+		sb.Sequences = append(sb.Sequences, Sequence{LitLen: uint32(s.ll), MatchLen: uint32(s.ml), Offset: uint32(s.mo)})
+	}
+	if litLen > len(sb.Literals) {
+		return ErrUnexpectedBlockSize
+	}
+	return nil
+}
@@ -0,0 +1,106 @@
+package zstd
+
+import (
+	"bytes"
+	"os"
+	"testing"
+)
+
+// executeSequenceBlocks will reconstruct the output of blocks returned by DecodeSequences.
+func executeSequenceBlocks(t *testing.T, d *Decoder, input []byte) (out []byte, blocks []SequenceBlock) {
+	t.Helper()
+	frameStart, frame := 0, -1
+	err := d.DecodeSequences(input, func(b *SequenceBlock) error {
+		if b.Frame != frame {
+			frame = b.Frame
+			frameStart = len(out)
+		}
+		start := len(out)
+		lits := b.Literals
+		for _, s := range b.Sequences {
+			out = append(out, lits[:s.LitLen]...)
+			lits = lits[s.LitLen:]
+			if int(s.Offset) > len(out)-frameStart {
+				t.Fatalf("offset %d out of range", s.Offset)
+			}
+			for range s.MatchLen {
+				out = append(out, out[len(out)-int(s.Offset)])
+			}
+		}
+		out = append(out, lits...)
+		if len(out)-start != b.DecompressedSize {
+			t.Errorf("block size mismatch: got %d, want %d", len(out)-start, b.DecompressedSize)
+		}
+		blocks = append(blocks, *b)
+		blocks[len(blocks)-1].Literals = nil
+		blocks[len(blocks)-1].Sequences = nil
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return out, blocks
+}
+
+func TestDecoder_DecodeSequences(t *testing.T) {
+	in, err := os.ReadFile("../testdata/Mark.Twain-Tom.Sawyer.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	dec, err := NewReader(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer dec.Close()
+	for level := speedNotSet + 1; level < speedLast; level++ {
+		t.Run(level.String(), func(t *testing.T) {
+			if isRaceTest && level >= SpeedBestCompression {
+				t.SkipNow()
+			}
+			e, err := NewWriter(nil, WithEncoderLevel(level), WithEncoderConcurrency(1))
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer e.Close()
+			// Two frames, the second with small blocks.
+			comp := e.EncodeAll(in, nil)
+			var buf bytes.Buffer
+			e.Reset(&buf)
+			for rem := in; len(rem) > 0; {
+				n := min(len(rem), 5000)
+				e.Write(rem[:n])
+				e.Flush()
+				rem = rem[n:]
+			}
+			if err := e.Close(); err != nil {
+				t.Fatal(err)
+			}
+			comp = append(comp, buf.Bytes()...)
+			// Add an RLE block.
+			comp = e.EncodeAll(bytes.Repeat([]byte{'z'}, 1000), comp)
+
+			want := append(append(append([]byte{}, in...), in...), bytes.Repeat([]byte{'z'}, 1000)...)
+			got, blocks := executeSequenceBlocks(t, dec, comp)
+			if !bytes.Equal(got, want) {
+				t.Fatal("output mismatch")
+			}
+			modes := make(map[string]int)
+			for _, b := range blocks {
+				modes[b.Type.String()]++
+				if b.Type == BlockTypeCompressed {
+					modes["lits-"+b.LiteralsMode.String()]++
+					modes["ll-"+b.LitLenMode.String()]++
+					modes["of-"+b.OffsetMode.String()]++
+					modes["ml-"+b.MatchLenMode.String()]++
+				}
+			}
+			t.Logf("%d blocks, modes: %v", len(blocks), modes)
+			if blocks[len(blocks)-1].Frame != 2 {
+				t.Errorf("want 3 frames, got %d", blocks[len(blocks)-1].Frame+1)
+			}
+			if modes[BlockTypeCompressed.String()] == 0 {
+				t.Error("no compressed blocks")
+			}
+		})
+	}
+}
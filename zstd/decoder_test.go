@@ -7,6 +7,7 @@ package zstd
 import (
 	"bufio"
 	"bytes"
+	"context"
 	"encoding/binary"
 	"encoding/hex"
 	"errors"
@@ -908,6 +909,86 @@ func TestDecoder_Reset(t *testing.T) {
 	}
 }
 
+func TestDecoderContext(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	in := make([]byte, 8<<20)
+	for i := range in {
+		in[i] = 'a' + byte(rng.Intn(16))
+	}
+	enc, err := NewWriter(nil, WithEncoderLevel(SpeedFastest))
+	if err != nil {
+		t.Fatal(err)
+	}
+	comp := enc.EncodeAll(in, nil)
+	enc.Close()
+
+	for _, n := range []int{1, 4} {
+		t.Run(fmt.Sprint("concurrency-", n), func(t *testing.T) {
+			dec, err := NewReader(nil, WithDecoderConcurrency(n))
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer dec.Close()
+
+			// Canceled after the first read.
+			ctx, cancel := context.WithCancel(context.Background())
+			if err := dec.ResetContext(ctx, bytes.NewReader(comp)); err != nil {
+				t.Fatal(err)
+			}
+			var tmp [1 << 10]byte
+			if _, err := io.ReadFull(dec, tmp[:]); err != nil {
+				t.Fatal(err)
+			}
+			cancel()
+			got, err := io.ReadAll(dec)
+			if !errors.Is(err, context.Canceled) {
+				t.Fatalf("want context.Canceled, got %v", err)
+			}
+			if len(got) >= len(in)-len(tmp) {
+				t.Errorf("read all %d bytes after cancel", len(got))
+			}
+
+			// Canceled while writing.
+			ctx, cancel = context.WithCancel(context.Background())
+			if err := dec.ResetContext(ctx, bytes.NewReader(comp)); err != nil {
+				t.Fatal(err)
+			}
+			w := &cancelWriter{n: 1 << 20, cancel: cancel}
+			_, err = dec.WriteTo(w)
+			if !errors.Is(err, context.Canceled) {
+				t.Fatalf("want context.Canceled, got %v", err)
+			}
+			if w.written >= len(in) {
+				t.Errorf("wrote all %d bytes after cancel", w.written)
+			}
+
+			// Reset clears the error.
+			if err := dec.Reset(bytes.NewReader(comp)); err != nil {
+				t.Fatal(err)
+			}
+			got, err = io.ReadAll(dec)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(got, in) {
+				t.Fatal("output mismatch")
+			}
+
+			// DecodeAllContext
+			if _, err := dec.DecodeAllContext(ctx, comp, nil); !errors.Is(err, context.Canceled) {
+				t.Errorf("want context.Canceled, got %v", err)
+			}
+			got, err = dec.DecodeAllContext(context.Background(), comp, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(got, in) {
+				t.Fatal("output mismatch")
+			}
+		})
+	}
+}
+
 func TestDecoderMultiFrame(t *testing.T) {
 	zr := testCreateZipReader("testdata/benchdecoder.zip", t)
 	dec, err := NewReader(nil)
@@ -2213,3 +2294,135 @@ func TestWithDecodeAllCapLimit(t *testing.T) {
 		})
 	}
 }
+
+func TestDecoderResetWithOptions(t *testing.T) {
+	in, err := os.ReadFile("../testdata/Mark.Twain-Tom.Sawyer.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	samples := coverSamples(200)
+	var dicts [2][]byte
+	for i := range dicts {
+		dicts[i], err = BuildDict(BuildDictOptions{ID: uint32(i + 1), Contents: samples, History: bytes.Join(samples[i*50:i*50+50], nil), Offsets: [3]int{1, 4, 8}, Level: SpeedDefault})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	var withDict [2][]byte
+	for i, d := range dicts {
+		enc, err := NewWriter(nil, WithEncoderDict(d))
+		if err != nil {
+			t.Fatal(err)
+		}
+		withDict[i] = enc.EncodeAll(samples[150], nil)
+		enc.Close()
+	}
+	enc, err := NewWriter(nil, WithWindowSize(1<<20))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var stream bytes.Buffer
+	enc.Reset(&stream)
+	enc.Write(in)
+	enc.Close()
+
+	pooled := func(d *Decoder) map[*blockDec]bool {
+		m := make(map[*blockDec]bool)
+		for range len(d.decoders) {
+			dec := <-d.decoders
+			m[dec] = true
+			d.decoders <- dec
+		}
+		return m
+	}
+	dec, err := NewReader(nil, WithDecoderConcurrency(2), WithDecoderDicts(dicts[0]))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer dec.Close()
+	before := pooled(dec)
+
+	decode := func(opts ...DOption) error {
+		t.Helper()
+		if err := dec.ResetWithOptions(bytes.NewReader(stream.Bytes()), opts...); err != nil {
+			t.Fatal(err)
+		}
+		got, err := io.ReadAll(dec)
+		if err == nil && !bytes.Equal(got, in) {
+			t.Fatal("output mismatch")
+		}
+		return err
+	}
+	if err := decode(WithDecoderMaxWindow(1 << 19)); !errors.Is(err, ErrWindowSizeExceeded) {
+		t.Errorf("want ErrWindowSizeExceeded, got %v", err)
+	}
+	if err := decode(WithDecoderMaxWindow(1<<20), WithDecoderConcurrency(4)); err != nil {
+		t.Fatal(err)
+	}
+	after := pooled(dec)
+	if len(after) != 4 {
+		t.Errorf("want 4 decoders, got %d", len(after))
+	}
+	for d := range before {
+		if !after[d] {
+			t.Error("decoder was not reused")
+		}
+		if d.localFrame.o.maxWindowSize != 1<<20 {
+			t.Error("options not updated")
+		}
+	}
+	if err := decode(WithDecoderConcurrency(1), WithDecoderMaxMemory(1<<10)); err == nil {
+		t.Error("no error with max memory exceeded")
+	}
+	if err := decode(WithDecoderMaxMemory(64 << 20)); err != nil {
+		t.Fatal(err)
+	}
+	if n := len(dec.decoders); n != 1 {
+		t.Errorf("want 1 decoder, got %d", n)
+	}
+
+	// Dictionaries are added by ResetWithOptions and replaced by ResetWithDict.
+	decodeDict := func(i int) error {
+		got, err := dec.DecodeAll(withDict[i], nil)
+		if err == nil && !bytes.Equal(got, samples[150]) {
+			t.Fatal("output mismatch")
+		}
+		return err
+	}
+	if err := decodeDict(1); !errors.Is(err, ErrUnknownDictionary) {
+		t.Errorf("want ErrUnknownDictionary, got %v", err)
+	}
+	if err := dec.ResetWithOptions(nil, WithDecoderDicts(dicts[1])); err != nil {
+		t.Fatal(err)
+	}
+	if decodeDict(0) != nil || decodeDict(1) != nil {
+		t.Error("dictionary not added")
+	}
+	if err := dec.ResetWithDict(nil, dicts[1]); err != nil {
+		t.Fatal(err)
+	}
+	if decodeDict(0) == nil || decodeDict(1) != nil {
+		t.Error("dictionaries not replaced")
+	}
+	if err := dec.ResetWithDict(nil); err != nil {
+		t.Fatal(err)
+	}
+	if decodeDict(1) == nil {
+		t.Error("dictionaries not removed")
+	}
+
+	// A failed reset leaves the decoder unchanged.
+	if err := dec.ResetWithDict(nil, []byte("not a dictionary")); err == nil {
+		t.Error("no error for invalid dictionary")
+	}
+	if err := dec.ResetWithOptions(nil, WithDecoderDicts(dicts[0]), WithDecoderConcurrency(-1)); err == nil {
+		t.Error("no error for invalid concurrency")
+	}
+	if decodeDict(0) == nil {
+		t.Error("dictionary added by failed reset")
+	}
+	dec.Close()
+	if err := dec.ResetWithOptions(nil); err != ErrDecoderClosed {
+		t.Errorf("want ErrDecoderClosed, got %v", err)
+	}
+}
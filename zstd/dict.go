@@ -19,6 +19,11 @@ type dict struct {
 	llDec, ofDec, mlDec sequenceDec
 	offsets             [3]int
 	content             []byte
+
+	// tables contains encoder hash tables built from content,
+	// if the dictionary is shared between encoders.
+	tables dictTables
+	shared bool
 }
 
 const dictMagic = "\x37\xa4\x30\xec"
@@ -170,8 +175,13 @@ type BuildDictOptions struct {
 	Contents [][]byte
 
 	// History to use for all blocks.
+	// If empty, it is selected from Contents with TrainCover using Cover.
 	History []byte
 
+	// Cover contains the parameters used to select History if it is empty.
+	// If Cover.Level is 0, Level is used.
+	Cover CoverParams
+
 	// Offsets to use.
 	Offsets [3]int
 
@@ -191,6 +201,23 @@ type BuildDictOptions struct {
 
 func BuildDict(o BuildDictOptions) ([]byte, error) {
 	initPredefined()
+	if len(o.History) == 0 && len(o.Contents) > 0 {
+		p := o.Cover
+		if p.Level == 0 {
+			p.Level = o.Level
+		}
+		if p.DebugOut == nil {
+			p.DebugOut = o.DebugOut
+		}
+		hist, _, err := TrainCover(o.Contents, p)
+		if err != nil {
+			return nil, err
+		}
+		o.History = hist
+		if o.Offsets == [3]int{} {
+			o.Offsets = [3]int{1, 4, 8}
+		}
+	}
 	hist := o.History
 	contents := o.Contents
 	debug := o.DebugOut != nil
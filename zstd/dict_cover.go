@@ -0,0 +1,368 @@
+// Copyright 2025+ Klaus Post. All rights reserved.
+// License information can be found in the LICENSE file.
+
+package zstd
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"runtime"
+	"sync"
+)
+
+// CoverParams controls how dictionary content is selected from samples
+// with the COVER and fastCover algorithms of the reference trainer.
+// Zero values select the defaults.
+type CoverParams struct {
+	// Size is the size of the content to select.
+	// Default is 110KB, similar to the reference implementation.
+	Size int
+
+	// K is the size of the segments that are added to the content.
+	// If 0, segment sizes from 50 to 2000 are tried and the best is used.
+	K int
+
+	// D is the size of the substrings (dmers) that are counted, from 4 to 8.
+	// If 0, 6 and 8 are tried and the best is used.
+	D int
+
+	// Steps is the number of segment sizes to try when K is 0.
+	// Default is 40.
+	Steps int
+
+	// Split is the fraction of the samples used for training when parameters are searched.
+	// The remaining samples are used to test the dictionaries.
+	// If 1, all samples are used for both. Default is 0.75.
+	Split float64
+
+	// Fast will select fastCover, which counts dmers in a hash table
+	// instead of counting exact dmers, similar to "zstd --train-fastcover".
+	// This is faster and uses less memory.
+	Fast bool
+
+	// F is the log2 of the hash table size used by fastCover, from 8 to 24.
+	// Default is 20.
+	F int
+
+	// Level is the compression level used to test dictionaries.
+	// Default is SpeedDefault.
+	Level EncoderLevel
+
+	// DebugOut will write progress and the tested parameters here if set.
+	DebugOut io.Writer
+}
+
+// setDefault will set defaults for zero values and check the parameters.
+func (p *CoverParams) setDefault() error {
+	if p.Size == 0 {
+		p.Size = 110 << 10
+	}
+	if p.Steps == 0 {
+		p.Steps = 40
+	}
+	if p.Split == 0 {
+		p.Split = 0.75
+	}
+	if p.F == 0 {
+		p.F = 20
+	}
+	if p.Level == 0 {
+		p.Level = SpeedDefault
+	}
+	switch {
+	case p.Size < 256 || int64(p.Size) > dictMaxLength:
+		return fmt.Errorf("content size %d out of range", p.Size)
+	case p.D != 0 && (p.D < 4 || p.D > 8):
+		return fmt.Errorf("dmer size %d must be from 4 to 8", p.D)
+	case p.K != 0 && (p.K < 8 || p.K < p.D || p.K > p.Size):
+		return fmt.Errorf("segment size %d out of range", p.K)
+	case p.Steps < 0:
+		return errors.New("steps must not be negative")
+	case p.Split < 0 || p.Split > 1:
+		return fmt.Errorf("split %v must be from 0 to 1", p.Split)
+	case p.F < 8 || p.F > 24:
+		return fmt.Errorf("hash table log %d must be from 8 to 24", p.F)
+	case p.Level < SpeedFastest || p.Level > SpeedUltraCompression:
+		return fmt.Errorf("unknown level %v", p.Level)
+	}
+	return nil
+}
+
+// TrainCover selects dictionary content from samples with COVER or fastCover.
+// The content can be used as History in BuildDictOptions.
+// If K or D are 0, dictionaries are built with a range of parameters,
+// and the content that compresses the test samples best is returned.
+// The parameters used for the returned content are also returned.
+func TrainCover(samples [][]byte, p CoverParams) ([]byte, CoverParams, error) {
+	if err := p.setDefault(); err != nil {
+		return nil, p, err
+	}
+	printf := func(s string, args ...any) {
+		if p.DebugOut != nil {
+			fmt.Fprintf(p.DebugOut, s, args...)
+		}
+	}
+	if len(samples) == 0 {
+		return nil, p, errors.New("no samples provided")
+	}
+	ds := []int{p.D}
+	if p.D == 0 {
+		ds = []int{6, 8}
+	}
+	ks := []int{p.K}
+	if p.K == 0 {
+		const kMin, kMax = 50, 2000
+		ks = ks[:0]
+		step := max((kMax-kMin)/max(p.Steps, 1), 1)
+		for k := kMin; k <= kMax; k += step {
+			ks = append(ks, k)
+		}
+	}
+	train, test := samples, samples
+	search := len(ds) > 1 || len(ks) > 1
+	if n := int(float64(len(samples)) * p.Split); search && n > 0 && n < len(samples) {
+		train, test = samples[:n], samples[n:]
+	}
+
+	type result struct {
+		content []byte
+		k, d    int
+		size    int
+		err     error
+	}
+	var best result
+	for _, d := range ds {
+		c, err := newCover(train, d, p)
+		if err != nil {
+			return nil, p, err
+		}
+		if !search {
+			p.K = ks[0]
+			return c.build(p.K, p.Size), p, nil
+		}
+		// Test segment sizes concurrently.
+		results := make([]result, 0, len(ks))
+		for _, k := range ks {
+			if k >= d && k <= p.Size {
+				results = append(results, result{k: k, d: d})
+			}
+		}
+		var wg sync.WaitGroup
+		next := make(chan *result)
+		for range min(runtime.GOMAXPROCS(0), len(results)) {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for r := range next {
+					r.content = c.build(r.k, p.Size)
+					r.size, r.err = testDict(r.content, test, p.Level)
+				}
+			}()
+		}
+		for i := range results {
+			next <- &results[i]
+		}
+		close(next)
+		wg.Wait()
+		for _, r := range results {
+			if r.err != nil {
+				return nil, p, r.err
+			}
+			printf("k=%d d=%d: content %d bytes, test samples compressed to %d bytes\n", r.k, r.d, len(r.content), r.size)
+			if len(r.content) > 0 && (best.content == nil || r.size < best.size) {
+				best = r
+			}
+		}
+	}
+	if best.content == nil {
+		return nil, p, errors.New("no content could be selected")
+	}
+	printf("selected k=%d d=%d\n", best.k, best.d)
+	p.K, p.D = best.k, best.d
+	return best.content, p, nil
+}
+
+// testDict returns the compressed size of samples using content as a raw dictionary.
+func testDict(content []byte, samples [][]byte, level EncoderLevel) (int, error) {
+	enc, err := NewWriter(nil, WithEncoderLevel(level), WithEncoderConcurrency(1), WithEncoderDictRaw(1, content))
+	if err != nil {
+		return 0, err
+	}
+	defer enc.Close()
+	var buf []byte
+	n := 0
+	for _, s := range samples {
+		buf = enc.EncodeAll(s, buf[:0])
+		n += len(buf)
+	}
+	return n, nil
+}
+
+// cover contains the dmers of the training samples.
+type cover struct {
+	data []byte
+	d    int
+
+	// dmers contains the index of the dmer starting at each position of data.
+	// Index 0 is used where no dmer starts.
+	dmers []uint32
+
+	// freqs contains the frequency of each dmer index.
+	freqs []uint32
+}
+
+// newCover will count the dmers of samples.
+// COVER counts the number of samples containing each exact dmer.
+// fastCover counts all occurrences of each dmer hash.
+func newCover(samples [][]byte, d int, p CoverParams) (*cover, error) {
+	total := 0
+	for _, s := range samples {
+		total += len(s)
+	}
+	if total < 2*d || total > 1<<31 {
+		return nil, fmt.Errorf("total sample size %d out of range", total)
+	}
+	c := cover{data: make([]byte, 0, total+8), d: d, dmers: make([]uint32, total)}
+	for _, s := range samples {
+		c.data = append(c.data, s...)
+	}
+	// Padding, so dmers can be loaded as 8 bytes.
+	c.data = append(c.data, make([]byte, 8)...)[:total]
+	mask := uint64(1)<<(8*d) - 1
+	load := func(i int) uint64 {
+		return binary.LittleEndian.Uint64(c.data[i:i+8:i+8]) & mask
+	}
+
+	if p.Fast {
+		c.freqs = make([]uint32, 1<<p.F+1)
+		pos := 0
+		for _, s := range samples {
+			for i := pos; i+d <= pos+len(s); i++ {
+				h := uint32(((load(i) << (64 - 8*d)) * prime8bytes) >> (64 - p.F))
+				c.dmers[i] = h + 1
+				c.freqs[h+1]++
+			}
+			pos += len(s)
+		}
+		return &c, nil
+	}
+
+	ids := make(map[uint64]uint32)
+	c.freqs = []uint32{0}
+	var last []int32 // Last sample each dmer was counted in.
+	pos := 0
+	for n, s := range samples {
+		for i := pos; i+d <= pos+len(s); i++ {
+			v := load(i)
+			id, ok := ids[v]
+			if !ok {
+				id = uint32(len(c.freqs))
+				ids[v] = id
+				c.freqs = append(c.freqs, 0)
+				last = append(last, -1)
+			}
+			c.dmers[i] = id
+			if last[id-1] != int32(n) {
+				last[id-1] = int32(n)
+				c.freqs[id]++
+			}
+		}
+		pos += len(s)
+	}
+	return &c, nil
+}
+
+// build will select up to size bytes of content using segments of k bytes.
+// The data is divided into epochs and the best segment of each epoch is added,
+// until the content is full or no more useful segments are found.
+// Segments are added from the end, so the best segments are closest to the data.
+func (c *cover) build(k, size int) []byte {
+	freqs := append([]uint32(nil), c.freqs...)
+	active := make([]uint32, len(freqs))
+	nDmers := len(c.data) - c.d + 1
+
+	// Compute epochs, similar to the reference implementation.
+	const passes = 4
+	epochs := max(1, size/k/passes)
+	epochSize := nDmers / epochs
+	if minSize := k * 10; epochSize < minSize {
+		epochSize = min(minSize, nDmers)
+		epochs = nDmers / epochSize
+	}
+	maxZeroRun := max(10, min(100, epochs>>3))
+
+	dst := make([]byte, size)
+	tail := size
+	zeroRun := 0
+	for epoch := 0; tail > 0; epoch = (epoch + 1) % epochs {
+		begin := epoch * epochSize
+		segBegin, segEnd, score := c.selectSegment(freqs, active, begin, begin+epochSize, k)
+		if score == 0 {
+			zeroRun++
+			if zeroRun >= maxZeroRun {
+				break
+			}
+			continue
+		}
+		zeroRun = 0
+		n := min(segEnd-segBegin+c.d-1, tail)
+		if n < c.d {
+			break
+		}
+		tail -= n
+		copy(dst[tail:], c.data[segBegin:segBegin+n])
+	}
+	return dst[tail:]
+}
+
+// selectSegment returns the segment of k bytes in the dmers from begin to end
+// with the highest sum of frequencies of the distinct dmers it contains.
+// The frequencies of the dmers in the returned segment are set to 0.
+// active is used to count dmers in the segment and must be all zero.
+func (c *cover) selectSegment(freqs, active []uint32, begin, end, k int) (segBegin, segEnd int, score uint64) {
+	dmersInK := k - c.d + 1
+	var cur uint64
+	curBegin := begin
+	for curEnd := begin; curEnd < end; {
+		id := c.dmers[curEnd]
+		if active[id] == 0 {
+			cur += uint64(freqs[id])
+		}
+		active[id]++
+		curEnd++
+		if curEnd-curBegin == dmersInK+1 {
+			id := c.dmers[curBegin]
+			active[id]--
+			if active[id] == 0 {
+				cur -= uint64(freqs[id])
+			}
+			curBegin++
+		}
+		if cur > score {
+			segBegin, segEnd, score = curBegin, curEnd, cur
+		}
+	}
+	// Clear the dmers of the last segment.
+	for _, id := range c.dmers[curBegin:max(curBegin, end)] {
+		active[id] = 0
+	}
+	if score == 0 {
+		return 0, 0, 0
+	}
+
+	// Trim dmers without frequency from the ends.
+	newBegin, newEnd := segEnd, segBegin
+	for i := segBegin; i < segEnd; i++ {
+		if freqs[c.dmers[i]] != 0 {
+			newBegin = min(newBegin, i)
+			newEnd = i + 1
+		}
+	}
+	segBegin, segEnd = newBegin, newEnd
+	for _, id := range c.dmers[segBegin:segEnd] {
+		freqs[id] = 0
+	}
+	return segBegin, segEnd, score
+}
@@ -0,0 +1,110 @@
+package zstd
+
+import (
+	"bytes"
+	"fmt"
+	"math/rand"
+	"testing"
+)
+
+// coverSamples returns n similar JSON records.
+func coverSamples(n int) [][]byte {
+	rng := rand.New(rand.NewSource(1))
+	names := []string{"alice", "bob", "carol", "dave", "eve", "mallory", "trent"}
+	cities := []string{"Copenhagen", "Berlin", "Paris", "London", "Tokyo", "Sydney"}
+	status := []string{"pending", "shipped", "delivered", "cancelled"}
+	samples := make([][]byte, n)
+	for i := range samples {
+		var b bytes.Buffer
+		name := names[rng.Intn(len(names))]
+		fmt.Fprintf(&b, `{"id":%d,"user":{"name":%q,"email":"%s@example.com","age":%d,"address":{"city":%q,"zip":"%05d"}},"items":[`,
+			i, name, name, 18+rng.Intn(70), cities[rng.Intn(len(cities))], rng.Intn(100000))
+		for j := range 1 + rng.Intn(4) {
+			if j > 0 {
+				b.WriteByte(',')
+			}
+			fmt.Fprintf(&b, `{"sku":"SKU-%06d","qty":%d,"price":%.2f}`, rng.Intn(1000000), 1+rng.Intn(9), rng.Float64()*100)
+		}
+		fmt.Fprintf(&b, `],"status":%q}`, status[rng.Intn(len(status))])
+		samples[i] = b.Bytes()
+	}
+	return samples
+}
+
+func TestTrainCover(t *testing.T) {
+	samples := coverSamples(1000)
+	train, test := samples[:800], samples[800:]
+	compressed := func(t *testing.T, dict []byte) int {
+		t.Helper()
+		var eOpts []EOption
+		var dOpts []DOption
+		if dict != nil {
+			eOpts = append(eOpts, WithEncoderDict(dict))
+			dOpts = append(dOpts, WithDecoderDicts(dict))
+		}
+		enc, err := NewWriter(nil, eOpts...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer enc.Close()
+		dec, err := NewReader(nil, dOpts...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer dec.Close()
+		n := 0
+		for _, s := range test {
+			comp := enc.EncodeAll(s, nil)
+			got, err := dec.DecodeAll(comp, nil)
+			if err != nil || !bytes.Equal(got, s) {
+				t.Fatal("roundtrip failed", err)
+			}
+			n += len(comp)
+		}
+		return n
+	}
+	noDict := compressed(t, nil)
+
+	for _, fast := range []bool{false, true} {
+		t.Run(fmt.Sprintf("fast-%v", fast), func(t *testing.T) {
+			p := CoverParams{Size: 4096, Steps: 10, Fast: fast}
+			content, got, err := TrainCover(train, p)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(content) == 0 || len(content) > p.Size {
+				t.Fatalf("content size %d", len(content))
+			}
+			if got.K < 50 || got.K > 2000 || (got.D != 6 && got.D != 8) {
+				t.Errorf("unexpected parameters %+v", got)
+			}
+			// The same parameters must give the same content from the training part.
+			again, _, err := TrainCover(train[:int(float64(len(train))*got.Split)], CoverParams{Size: p.Size, K: got.K, D: got.D, Fast: fast})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(again, content) {
+				t.Error("content differs with the selected parameters")
+			}
+
+			dict, err := BuildDict(BuildDictOptions{ID: 1234, Contents: train, Cover: p, Level: SpeedDefault})
+			if err != nil {
+				t.Fatal(err)
+			}
+			withDict := compressed(t, dict)
+			t.Logf("%d bytes without dictionary, %d with", noDict, withDict)
+			if withDict > noDict/2 {
+				t.Errorf("dictionary compressed %d bytes to %d, want at most half", noDict, withDict)
+			}
+		})
+	}
+
+	for _, p := range []CoverParams{{D: 3}, {D: 9}, {K: 5000, Size: 4096}, {Split: 2}, {Fast: true, F: 30}} {
+		if _, _, err := TrainCover(train, p); err == nil {
+			t.Errorf("no error for %+v", p)
+		}
+	}
+	if _, _, err := TrainCover(nil, CoverParams{}); err == nil {
+		t.Error("no error without samples")
+	}
+}
@@ -0,0 +1,103 @@
+// Copyright 2025+ Klaus Post. All rights reserved.
+// License information can be found in the LICENSE file.
+
+package zstd
+
+import (
+	"container/list"
+	"fmt"
+	"sync"
+)
+
+// DictResolveError is returned by the decoder when a dictionary
+// could not be resolved by the function provided with WithDecoderDictResolver.
+// It matches ErrUnknownDictionary with errors.Is.
+type DictResolveError struct {
+	// ID is the dictionary ID of the frame.
+	ID uint32
+
+	// Err is the error returned by the resolver or the error parsing the dictionary.
+	Err error
+}
+
+func (e *DictResolveError) Error() string {
+	return fmt.Sprintf("resolving dictionary %d: %v", e.ID, e.Err)
+}
+
+// Unwrap returns ErrUnknownDictionary and the underlying error.
+func (e *DictResolveError) Unwrap() []error {
+	return []error{ErrUnknownDictionary, e.Err}
+}
+
+// dictResolver resolves dictionaries not registered with the decoder
+// and keeps the most recently used ones.
+type dictResolver struct {
+	fn  func(id uint32) ([]byte, error)
+	max int
+
+	mu      sync.Mutex
+	lru     list.List // Elements are *dict, most recently used first.
+	entries map[uint32]*list.Element
+}
+
+func newDictResolver(fn func(id uint32) ([]byte, error), maxDicts int) *dictResolver {
+	return &dictResolver{fn: fn, max: maxDicts, entries: make(map[uint32]*list.Element, maxDicts)}
+}
+
+// get returns the dictionary with the given ID.
+// The resolver is called without holding the lock,
+// so concurrent lookups of the same ID may resolve it more than once.
+func (r *dictResolver) get(id uint32) (*dict, error) {
+	r.mu.Lock()
+	if e, ok := r.entries[id]; ok {
+		r.lru.MoveToFront(e)
+		r.mu.Unlock()
+		return e.Value.(*dict), nil
+	}
+	r.mu.Unlock()
+
+	d, err := r.resolve(id)
+	if err != nil {
+		return nil, &DictResolveError{ID: id, Err: err}
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if e, ok := r.entries[id]; ok {
+		r.lru.MoveToFront(e)
+		return e.Value.(*dict), nil
+	}
+	r.entries[id] = r.lru.PushFront(d)
+	for r.lru.Len() > r.max {
+		e := r.lru.Back()
+		r.lru.Remove(e)
+		delete(r.entries, e.Value.(*dict).id)
+	}
+	return d, nil
+}
+
+// resolve calls the resolver and parses the returned dictionary.
+func (r *dictResolver) resolve(id uint32) (*dict, error) {
+	b, err := r.fn(id)
+	if err != nil {
+		return nil, err
+	}
+	if b == nil {
+		return nil, ErrUnknownDictionary
+	}
+	if len(b) < 8 || string(b[:4]) != dictMagic {
+		dr, err := NewDictRaw(id, b)
+		if err != nil {
+			return nil, err
+		}
+		return dr.d, nil
+	}
+	d, err := loadDict(b)
+	if err != nil {
+		return nil, err
+	}
+	if d.id != id {
+		return nil, fmt.Errorf("resolved dictionary has ID %d", d.id)
+	}
+	return d, nil
+}
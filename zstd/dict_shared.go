@@ -0,0 +1,144 @@
+// Copyright 2025+ Klaus Post. All rights reserved.
+// License information can be found in the LICENSE file.
+
+package zstd
+
+import (
+	"fmt"
+	"math/bits"
+	"sync"
+)
+
+// Dict is a parsed dictionary that can be used by any number of encoders and decoders,
+// similar to ZSTD_CDict and ZSTD_DDict in the reference implementation.
+//
+// The dictionary is parsed once when created.
+// Encoder hash tables are built the first time they are needed by an encoder
+// and shared by all encoders using the same level and window size.
+// Encoders and decoders only keep a reference to the Dict,
+// so using the same dictionary for many encoders and decoders uses a lot less memory and CPU
+// than using WithEncoderDict and WithDecoderDicts for each of them.
+//
+// A Dict is immutable and safe for concurrent use.
+// Use WithEncoderSharedDict and WithDecoderSharedDicts to use it.
+type Dict struct {
+	d *dict
+}
+
+// NewDict parses a dictionary in the [dictionary format] produced by
+// "zstd --train" from the Zstandard reference implementation.
+//
+// [dictionary format]: https://github.com/facebook/zstd/blob/dev/doc/zstd_compression_format.md#dictionary-format
+func NewDict(b []byte) (*Dict, error) {
+	initPredefined()
+	d, err := loadDict(b)
+	if err != nil {
+		return nil, err
+	}
+	d.shared = true
+	return &Dict{d: d}, nil
+}
+
+// NewDictRaw creates a dictionary from raw content with the given ID.
+// The content may contain arbitrary data. It will be used as an initial history.
+// The content is copied.
+func NewDictRaw(id uint32, content []byte) (*Dict, error) {
+	if bits.UintSize > 32 && uint(len(content)) > dictMaxLength {
+		return nil, fmt.Errorf("dictionary of size %d > 2GiB too large", len(content))
+	}
+	return &Dict{d: &dict{id: id, content: append([]byte{}, content...), offsets: [3]int{1, 4, 8}, shared: true}}, nil
+}
+
+// ID returns the dictionary ID or 0 if d is nil.
+func (d *Dict) ID() uint32 {
+	if d == nil {
+		return 0
+	}
+	return d.d.ID()
+}
+
+// ContentSize returns the size of the dictionary content or 0 if d is nil.
+func (d *Dict) ContentSize() int {
+	if d == nil {
+		return 0
+	}
+	return d.d.ContentSize()
+}
+
+// dictTables contains encoder hash tables built from the content of a shared dictionary.
+// Tables are built on first use and shared by all encoders using the dictionary.
+// They are kept until the Dict is no longer referenced.
+type dictTables struct {
+	mu     sync.Mutex
+	tables map[dictTableKey]*dictTable
+}
+
+// dictTableKind is the type of an encoder hash table.
+type dictTableKind uint8
+
+const (
+	dictTableFast dictTableKind = iota
+	dictTableDFastLong
+	dictTableBetter
+	dictTableBetterLong
+	dictTableBest
+	dictTableBestLong
+
+	dictTableKinds
+)
+
+// dictTableKey identifies a table.
+// Offsets in tables depend on the maximum match offset of the encoder.
+type dictTableKey struct {
+	kind        dictTableKind
+	maxMatchOff int32
+}
+
+type dictTable struct {
+	once  sync.Once
+	table any
+}
+
+// dictTableSrc is the dictionary and maximum match offset a table of an encoder was built for.
+type dictTableSrc struct {
+	d           *dict
+	maxMatchOff int32
+}
+
+// dictEncTable returns the table of the given kind for d, calling build to create it.
+// Tables of shared dictionaries are built once and shared by all encoders using d.
+// Other dictionaries are only used by a single encoder,
+// so cur is returned if it was built by e for d, and e keeps the table otherwise built.
+// The returned table must not be modified.
+func dictEncTable[T any](e *fastBase, d *dict, kind dictTableKind, cur []T, build func() []T) []T {
+	if !d.shared {
+		src := dictTableSrc{d: d, maxMatchOff: e.maxMatchOff}
+		if e.dictTableSrc[kind] != src || len(cur) == 0 {
+			cur = build()
+			e.dictTableSrc[kind] = src
+		}
+		return cur
+	}
+	e.dictTableSrc[kind] = dictTableSrc{}
+	key := dictTableKey{kind: kind, maxMatchOff: e.maxMatchOff}
+	d.tables.mu.Lock()
+	t := d.tables.tables[key]
+	if t == nil {
+		if d.tables.tables == nil {
+			d.tables.tables = make(map[dictTableKey]*dictTable)
+		}
+		t = &dictTable{}
+		d.tables.tables[key] = t
+	}
+	d.tables.mu.Unlock()
+	// Build outside the lock, so other tables can be built concurrently.
+	t.once.Do(func() {
+		t.table = build()
+	})
+	return t.table.([]T)
+}
+
+// sameTable returns whether a and b refer to the same table.
+func sameTable[T any](a, b []T) bool {
+	return len(a) == len(b) && len(a) > 0 && &a[0] == &b[0]
+}
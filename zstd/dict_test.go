@@ -2,10 +2,13 @@ package zstd
 
 import (
 	"bytes"
+	"encoding/binary"
+	"errors"
 	"fmt"
 	"io"
 	"os"
 	"strings"
+	"sync"
 	"testing"
 
 	"github.com/snissn/compress/zip"
@@ -638,3 +641,439 @@ func TestDecoderRawDict(t *testing.T) {
 		t.Errorf("mismatch: got %q, wanted %q", out, ref)
 	}
 }
+
+func TestSharedDict(t *testing.T) {
+	zr := testCreateZipReader("testdata/dict-tests-small.zip", t)
+	var dictBytes []byte
+	var payloads [][]byte
+	for _, tt := range zr.File {
+		if !strings.HasSuffix(tt.Name, ".dict") || dictBytes != nil {
+			continue
+		}
+		r, err := tt.Open()
+		if err != nil {
+			t.Fatal(err)
+		}
+		dictBytes, err = io.ReadAll(r)
+		r.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	refDec, err := NewReader(nil, WithDecoderConcurrency(1), WithDecoderDicts(dictBytes))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer refDec.Close()
+	for _, tt := range zr.File {
+		if !strings.HasSuffix(tt.Name, ".zst") || len(payloads) >= 20 {
+			continue
+		}
+		r, err := tt.Open()
+		if err != nil {
+			t.Fatal(err)
+		}
+		in, err := io.ReadAll(r)
+		r.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		decoded, err := refDec.DecodeAll(in, nil)
+		if err == nil {
+			payloads = append(payloads, decoded)
+		}
+	}
+	if len(payloads) == 0 {
+		t.Fatal("no payloads")
+	}
+
+	d, err := NewDict(dictBytes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dec, err := NewReader(nil, WithDecoderSharedDicts(d))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer dec.Close()
+	for level := SpeedFastest; level < speedLast; level++ {
+		if isRaceTest && level == SpeedUltraCompression {
+			break
+		}
+		t.Run(level.String(), func(t *testing.T) {
+			ref, err := NewWriter(nil, WithEncoderConcurrency(1), WithEncoderLevel(level), WithWindowSize(1<<20), WithEncoderDict(dictBytes))
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer ref.Close()
+			// Several encoders using the same tables concurrently.
+			var encs []*Encoder
+			for range 4 {
+				enc, err := NewWriter(nil, WithEncoderConcurrency(2), WithEncoderLevel(level), WithWindowSize(1<<20), WithEncoderSharedDict(d))
+				if err != nil {
+					t.Fatal(err)
+				}
+				defer enc.Close()
+				encs = append(encs, enc)
+			}
+			want := make([][]byte, len(payloads))
+			for i, p := range payloads {
+				want[i] = ref.EncodeAll(p, nil)
+			}
+			var wg sync.WaitGroup
+			errs := make(chan error, len(encs))
+			for _, enc := range encs {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					for i, p := range payloads {
+						got := enc.EncodeAll(p, nil)
+						if !bytes.Equal(got, want[i]) {
+							errs <- fmt.Errorf("payload %d: output differs from WithEncoderDict", i)
+							return
+						}
+						dst, err := dec.DecodeAll(got, nil)
+						if err != nil {
+							errs <- err
+							return
+						}
+						if !bytes.Equal(dst, p) {
+							errs <- fmt.Errorf("payload %d: decoded mismatch", i)
+							return
+						}
+					}
+				}()
+			}
+			wg.Wait()
+			close(errs)
+			for err := range errs {
+				t.Error(err)
+			}
+
+			// Tables of dictionaries that are not shared are owned by the encoder,
+			// and rebuilt when the window changes.
+			if ref.o.dict.tables.tables != nil {
+				t.Error("tables of unshared dictionary are kept by the dictionary")
+			}
+			if err := ref.ResetWithOptions(nil, WithWindowSize(1<<19)); err != nil {
+				t.Fatal(err)
+			}
+			small, err := NewWriter(nil, WithEncoderConcurrency(1), WithEncoderLevel(level), WithWindowSize(1<<19), WithEncoderDict(dictBytes))
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer small.Close()
+			for i, p := range payloads {
+				if !bytes.Equal(ref.EncodeAll(p, nil), small.EncodeAll(p, nil)) {
+					t.Fatalf("payload %d: output differs after window change", i)
+				}
+			}
+		})
+	}
+	// One table per kind is expected, since all encoders use the same window size.
+	d.d.tables.mu.Lock()
+	n := len(d.d.tables.tables)
+	d.d.tables.mu.Unlock()
+	if n != int(dictTableBestLong)+1 {
+		t.Errorf("want %d shared tables, got %d", dictTableBestLong+1, n)
+	}
+
+	// Raw dictionaries.
+	raw, err := NewDictRaw(1234, payloads[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	enc, err := NewWriter(nil, WithEncoderSharedDict(raw))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer enc.Close()
+	rawDec, err := NewReader(nil, WithDecoderSharedDicts(raw))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rawDec.Close()
+	comp := enc.EncodeAll(payloads[0], nil)
+	got, err := rawDec.DecodeAll(comp, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, payloads[0]) {
+		t.Fatal("raw dictionary: decoded mismatch")
+	}
+	if raw.ID() != 1234 || raw.ContentSize() != len(payloads[0]) {
+		t.Errorf("unexpected ID %d or size %d", raw.ID(), raw.ContentSize())
+	}
+}
+
+func TestEncodeAllDict(t *testing.T) {
+	zr := testCreateZipReader("testdata/dict-tests-small.zip", t)
+	var dictBytes [][]byte
+	var payloads [][]byte
+	for _, tt := range zr.File {
+		if !strings.HasSuffix(tt.Name, ".dict") {
+			continue
+		}
+		r, err := tt.Open()
+		if err != nil {
+			t.Fatal(err)
+		}
+		in, err := io.ReadAll(r)
+		r.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		dictBytes = append(dictBytes, in)
+	}
+	refDec, err := NewReader(nil, WithDecoderConcurrency(1), WithDecoderDicts(dictBytes...))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer refDec.Close()
+	for _, tt := range zr.File {
+		if !strings.HasSuffix(tt.Name, ".zst") || len(payloads) >= 10 {
+			continue
+		}
+		r, err := tt.Open()
+		if err != nil {
+			t.Fatal(err)
+		}
+		in, err := io.ReadAll(r)
+		r.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		decoded, err := refDec.DecodeAll(in, nil)
+		if err == nil {
+			payloads = append(payloads, decoded)
+		}
+	}
+	var dicts []*Dict
+	for _, b := range dictBytes {
+		d, err := NewDict(b)
+		if err != nil {
+			t.Fatal(err)
+		}
+		dicts = append(dicts, d)
+	}
+	// Raw dictionaries with the same ID, but different content.
+	for _, p := range payloads[:2] {
+		d, err := NewDictRaw(0, p)
+		if err != nil {
+			t.Fatal(err)
+		}
+		dicts = append(dicts, d)
+	}
+	dicts = append(dicts, nil)
+
+	dec, err := NewReader(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer dec.Close()
+	for level := SpeedFastest; level < speedLast; level++ {
+		if testing.Short() && level == SpeedUltraCompression {
+			break
+		}
+		t.Run(level.String(), func(t *testing.T) {
+			// Reference output for each dictionary.
+			want := make([][][]byte, len(dicts))
+			for i, d := range dicts {
+				opts := []EOption{WithEncoderConcurrency(1), WithEncoderLevel(level)}
+				if d != nil {
+					opts = append(opts, WithEncoderSharedDict(d))
+				}
+				ref, err := NewWriter(nil, opts...)
+				if err != nil {
+					t.Fatal(err)
+				}
+				for _, p := range payloads {
+					want[i] = append(want[i], ref.EncodeAll(p, nil))
+				}
+				ref.Close()
+			}
+			// Encoders with and without a dictionary, switching dictionary for each call.
+			for _, withDict := range []bool{false, true} {
+				opts := []EOption{WithEncoderConcurrency(1), WithEncoderLevel(level)}
+				if withDict {
+					opts = append(opts, WithEncoderDict(dictBytes[0]))
+				}
+				enc, err := NewWriter(nil, opts...)
+				if err != nil {
+					t.Fatal(err)
+				}
+				defer enc.Close()
+				for j, p := range payloads {
+					for i, d := range dicts {
+						got := enc.EncodeAllDict(d, p, nil)
+						// Encoders created with a dictionary may compress differently without one.
+						if (d != nil || !withDict) && !bytes.Equal(got, want[i][j]) {
+							t.Fatalf("dict %d, payload %d (encoder dict: %v): output differs from reference", i, j, withDict)
+						}
+						decoded, err := dec.DecodeAllDict(d, got, nil)
+						if err != nil {
+							t.Fatal(err)
+						}
+						if !bytes.Equal(decoded, p) {
+							t.Fatalf("dict %d, payload %d: decoded mismatch", i, j)
+						}
+					}
+				}
+			}
+		})
+	}
+
+	// Wrong dictionary.
+	enc, err := NewWriter(nil, WithEncoderSharedDict(dicts[0]))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer enc.Close()
+	comp := enc.EncodeAll(payloads[0], nil)
+	if _, err := dec.DecodeAllDict(dicts[1], comp, nil); err != ErrUnknownDictionary {
+		t.Errorf("want ErrUnknownDictionary, got %v", err)
+	}
+	if _, err := dec.DecodeAll(comp, nil); err != ErrUnknownDictionary {
+		t.Errorf("want ErrUnknownDictionary, got %v", err)
+	}
+}
+
+func TestDecoderDictResolver(t *testing.T) {
+	zr := testCreateZipReader("testdata/dict-tests-small.zip", t)
+	dicts := make(map[uint32][]byte)
+	var comp [][]byte
+	for _, tt := range zr.File {
+		r, err := tt.Open()
+		if err != nil {
+			t.Fatal(err)
+		}
+		in, err := io.ReadAll(r)
+		r.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		switch {
+		case strings.HasSuffix(tt.Name, ".dict"):
+			dicts[binary.LittleEndian.Uint32(in[4:8])] = in
+		case strings.HasSuffix(tt.Name, ".zst") && len(comp) < 20:
+			comp = append(comp, in)
+		}
+	}
+	raw := []byte(strings.Repeat("raw dictionary content ", 100))
+	dicts[1234] = raw
+	enc, err := NewWriter(nil, WithEncoderDictRaw(1234, raw))
+	if err != nil {
+		t.Fatal(err)
+	}
+	comp = append(comp, enc.EncodeAll(raw[:500], nil))
+	enc.Close()
+
+	var allDicts [][]byte
+	for id, b := range dicts {
+		if id != 1234 {
+			allDicts = append(allDicts, b)
+		}
+	}
+	ref, err := NewReader(nil, WithDecoderConcurrency(1), WithDecoderDicts(allDicts...), WithDecoderDictRaw(1234, raw))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ref.Close()
+
+	var mu sync.Mutex
+	calls := make(map[uint32]int)
+	dec, err := NewReader(nil, WithDecoderDictCacheSize(2), WithDecoderDictResolver(func(id uint32) ([]byte, error) {
+		mu.Lock()
+		calls[id]++
+		mu.Unlock()
+		return dicts[id], nil
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer dec.Close()
+	for i, in := range comp {
+		want, wantErr := ref.DecodeAll(in, nil)
+		for range 2 {
+			got, err := dec.DecodeAll(in, nil)
+			if err != wantErr {
+				t.Fatalf("input %d: want error %v, got %v", i, wantErr, err)
+			}
+			if !bytes.Equal(got, want) {
+				t.Fatalf("input %d: output mismatch", i)
+			}
+			// Stream decoding.
+			if err := dec.Reset(bytes.NewReader(in)); err != nil {
+				t.Fatal(err)
+			}
+			got, err = io.ReadAll(dec)
+			if wantErr == nil && (err != nil || !bytes.Equal(got, want)) {
+				t.Fatalf("input %d: stream mismatch, err: %v", i, err)
+			}
+		}
+	}
+	used := make(map[uint32]bool)
+	for _, in := range comp {
+		var h Header
+		if err := h.Decode(in); err != nil {
+			t.Fatal(err)
+		}
+		used[h.DictionaryID] = true
+	}
+	if len(calls) != len(used) {
+		t.Errorf("want %d dictionaries resolved, got %d", len(used), len(calls))
+	}
+	for id, n := range calls {
+		if n != 1 {
+			t.Errorf("dictionary %d resolved %d times", id, n)
+		}
+	}
+	if got := dec.dictResolver.lru.Len(); got != 2 {
+		t.Errorf("want 2 cached dictionaries, got %d", got)
+	}
+	// The first dictionary has been evicted and must be resolved again.
+	var h Header
+	if err := h.Decode(comp[0]); err != nil {
+		t.Fatal(err)
+	}
+	n := calls[h.DictionaryID]
+	if _, err := dec.DecodeAll(comp[0], nil); err != nil {
+		t.Fatal(err)
+	}
+	if calls[h.DictionaryID] != n+1 {
+		t.Errorf("dictionary %d not resolved again after eviction", h.DictionaryID)
+	}
+
+	// Resolving fails.
+	errFail := errors.New("fail")
+	dec2, err := NewReader(nil, WithDecoderDictResolver(func(id uint32) ([]byte, error) {
+		switch id {
+		case 1:
+			return nil, errFail
+		case 2:
+			return nil, nil
+		}
+		// Wrong dictionary ID.
+		return allDicts[0], nil
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer dec2.Close()
+	for _, id := range []uint32{1, 2, 3} {
+		enc, err := NewWriter(nil, WithEncoderDictRaw(id, raw), WithEncoderConcurrency(1))
+		if err != nil {
+			t.Fatal(err)
+		}
+		in := enc.EncodeAll(raw[:500], nil)
+		enc.Close()
+		_, err = dec2.DecodeAll(in, nil)
+		var rerr *DictResolveError
+		if !errors.As(err, &rerr) || rerr.ID != id || !errors.Is(err, ErrUnknownDictionary) {
+			t.Errorf("id %d: want *DictResolveError, got %v", id, err)
+		}
+		if id == 1 && !errors.Is(err, errFail) {
+			t.Errorf("want errFail, got %v", err)
+		}
+	}
+}
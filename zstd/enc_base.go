@@ -2,6 +2,7 @@ package zstd
 
 import (
 	"fmt"
+	"math"
 	"math/bits"
 
 	"github.com/snissn/compress/zstd/internal/xxhash"
@@ -21,14 +22,18 @@ type fastBase struct {
 	crc         *xxhash.Digest
 	tmp         [8]byte
 	blk         *blockEnc
-	lastDictID  uint32
-	// Whether the beginning of hist currently contains the dictionary content.
+	// The dictionary whose content is currently at the beginning of hist, if any.
 	// This allows Reset(dict, ...) to avoid copying dict.content into history on
 	// every reset for small independent frames.
-	histHasDict bool
-	histDictID  uint32
-	histDictLen int
-	lowMem      bool
+	histDict *dict
+	lowMem   bool
+
+	// Source of the dictionary tables built by the encoder.
+	dictTableSrc [dictTableKinds]dictTableSrc
+
+	// onShift is called by shiftCur before cur is moved,
+	// so offsets kept outside the encoder can be adjusted with shiftOffset.
+	onShift func()
 }
 
 // CRC returns the underlying CRC writer.
@@ -55,6 +60,11 @@ func (e *fastBase) WindowSize(size int64) int32 {
 	return e.maxMatchOff
 }
 
+// base returns the shared encoder state.
+func (e *fastBase) base() *fastBase {
+	return e
+}
+
 // Block returns the current block.
 func (e *fastBase) Block() *blockEnc {
 	return e.blk
@@ -78,7 +88,7 @@ func (e *fastBase) addBlock(src []byte) int32 {
 			e.cur += offset
 			e.hist = e.hist[:e.maxMatchOff]
 			// The history prefix has been overwritten.
-			e.histHasDict = false
+			e.histDict = nil
 		}
 	}
 	s := int32(len(e.hist))
@@ -107,7 +117,40 @@ func (e *fastBase) ensureHist(n int) {
 	}
 	e.hist = make([]byte, 0, l)
 	// New allocation; dictionary prefix is no longer present.
-	e.histHasDict = false
+	e.histDict = nil
+}
+
+// shiftCur will move cur back to maxMatchOff to protect against wraparound.
+// Offsets in tables must be adjusted with the rule of shiftOffset before this is called.
+func (e *fastBase) shiftCur() {
+	if e.onShift != nil {
+		e.onShift()
+	}
+	e.cur = e.maxMatchOff
+}
+
+// shiftOffset returns offset v adjusted for a following shiftCur,
+// or 0 if it will be out of reach.
+func (e *fastBase) shiftOffset(v int32) int32 {
+	if len(e.hist) == 0 || v < e.cur+int32(len(e.hist))-e.maxMatchOff {
+		return 0
+	}
+	return v - e.cur + e.maxMatchOff
+}
+
+// setWindow will change the window size to n.
+// The encoder must be reset before it is used again.
+// History is dropped if it cannot contain the new window.
+func (e *fastBase) setWindow(n int) {
+	if e.maxMatchOff == int32(n) {
+		return
+	}
+	e.maxMatchOff = int32(n)
+	e.bufferReset = math.MaxInt32 - int32(n*2)
+	if cap(e.hist) < n+maxCompressedBlockSize {
+		e.hist = nil
+		e.histDict = nil
+	}
 }
 
 // useBlock will replace the block with the provided one,
@@ -169,7 +212,7 @@ func (e *fastBase) resetBase(d *dict, singleBlock bool) {
 	}
 	if d == nil {
 		e.hist = e.hist[:0]
-		e.histHasDict = false
+		e.histDict = nil
 		return
 	}
 
@@ -182,14 +225,12 @@ func (e *fastBase) resetBase(d *dict, singleBlock bool) {
 	e.blk.dictLitEnc = d.litEnc
 
 	dictLen := len(d.content)
-	if e.histHasDict && e.histDictID == d.id && e.histDictLen == dictLen {
+	if e.histDict == d {
 		// Reuse previously copied dictionary content.
 		e.hist = e.hist[:dictLen]
 		return
 	}
 	e.hist = e.hist[:dictLen]
 	copy(e.hist, d.content)
-	e.histHasDict = true
-	e.histDictID = d.id
-	e.histDictLen = dictLen
+	e.histDict = d
 }
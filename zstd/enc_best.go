@@ -88,7 +88,7 @@ func (e *bestFastEncoder) Encode(blk *blockEnc, src []byte) {
 		if len(e.hist) == 0 {
 			e.table = [bestShortTableSize]prevEntry{}
 			e.longTable = [bestLongTableSize]prevEntry{}
-			e.cur = e.maxMatchOff
+			e.shiftCur()
 			break
 		}
 		// Shift down everything in the table that isn't already too far away.
@@ -131,7 +131,7 @@ func (e *bestFastEncoder) Encode(blk *blockEnc, src []byte) {
 				prev:   v2,
 			}
 		}
-		e.cur = e.maxMatchOff
+		e.shiftCur()
 		break
 	}
 
@@ -480,10 +480,8 @@ func (e *bestFastEncoder) Reset(d *dict, singleBlock bool) {
 		return
 	}
 	// Init or copy dict table
-	if len(e.dictTable) != len(e.table) || d.id != e.lastDictID {
-		if len(e.dictTable) != len(e.table) {
-			e.dictTable = make([]prevEntry, len(e.table))
-		}
+	e.dictTable = dictEncTable(&e.fastBase, d, dictTableBest, e.dictTable, func() []prevEntry {
+		dictTable := make([]prevEntry, len(e.table))
 		end := int32(len(d.content)) - 8 + e.maxMatchOff
 		for i := e.maxMatchOff; i < end; i += 4 {
 			const hashLog = bestShortTableBits
@@ -493,37 +491,35 @@ func (e *bestFastEncoder) Reset(d *dict, singleBlock bool) {
 			nextHash1 := hashLen(cv>>8, hashLog, bestShortLen)  // 1 -> 5
 			nextHash2 := hashLen(cv>>16, hashLog, bestShortLen) // 2 -> 6
 			nextHash3 := hashLen(cv>>24, hashLog, bestShortLen) // 3 -> 7
-			e.dictTable[nextHash] = prevEntry{
-				prev:   e.dictTable[nextHash].offset,
+			dictTable[nextHash] = prevEntry{
+				prev:   dictTable[nextHash].offset,
 				offset: i,
 			}
-			e.dictTable[nextHash1] = prevEntry{
-				prev:   e.dictTable[nextHash1].offset,
+			dictTable[nextHash1] = prevEntry{
+				prev:   dictTable[nextHash1].offset,
 				offset: i + 1,
 			}
-			e.dictTable[nextHash2] = prevEntry{
-				prev:   e.dictTable[nextHash2].offset,
+			dictTable[nextHash2] = prevEntry{
+				prev:   dictTable[nextHash2].offset,
 				offset: i + 2,
 			}
-			e.dictTable[nextHash3] = prevEntry{
-				prev:   e.dictTable[nextHash3].offset,
+			dictTable[nextHash3] = prevEntry{
+				prev:   dictTable[nextHash3].offset,
 				offset: i + 3,
 			}
 		}
-		e.lastDictID = d.id
-	}
+		return dictTable
+	})
 
 	// Init or copy dict table
-	if len(e.dictLongTable) != len(e.longTable) || d.id != e.lastDictID {
-		if len(e.dictLongTable) != len(e.longTable) {
-			e.dictLongTable = make([]prevEntry, len(e.longTable))
-		}
+	e.dictLongTable = dictEncTable(&e.fastBase, d, dictTableBestLong, e.dictLongTable, func() []prevEntry {
+		dictLongTable := make([]prevEntry, len(e.longTable))
 		if len(d.content) >= 8 {
 			cv := load6432(d.content, 0)
 			h := hashLen(cv, bestLongTableBits, bestLongLen)
-			e.dictLongTable[h] = prevEntry{
+			dictLongTable[h] = prevEntry{
 				offset: e.maxMatchOff,
-				prev:   e.dictLongTable[h].offset,
+				prev:   dictLongTable[h].offset,
 			}
 
 			end := int32(len(d.content)) - 8 + e.maxMatchOff
@@ -531,15 +527,15 @@ func (e *bestFastEncoder) Reset(d *dict, singleBlock bool) {
 			for i := e.maxMatchOff + 1; i < end; i++ {
 				cv = cv>>8 | (uint64(d.content[off]) << 56)
 				h := hashLen(cv, bestLongTableBits, bestLongLen)
-				e.dictLongTable[h] = prevEntry{
+				dictLongTable[h] = prevEntry{
 					offset: i,
-					prev:   e.dictLongTable[h].offset,
+					prev:   dictLongTable[h].offset,
 				}
 				off++
 			}
 		}
-		e.lastDictID = d.id
-	}
+		return dictLongTable
+	})
 	// Reset table to initial state
 	copy(e.longTable[:], e.dictLongTable)
 
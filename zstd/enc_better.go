@@ -66,7 +66,7 @@ func (e *betterFastEncoder) Encode(blk *blockEnc, src []byte) {
 		if len(e.hist) == 0 {
 			e.table = [betterShortTableSize]tableEntry{}
 			e.longTable = [betterLongTableSize]prevEntry{}
-			e.cur = e.maxMatchOff
+			e.shiftCur()
 			break
 		}
 		// Shift down everything in the table that isn't already too far away.
@@ -99,7 +99,7 @@ func (e *betterFastEncoder) Encode(blk *blockEnc, src []byte) {
 				prev:   v2,
 			}
 		}
-		e.cur = e.maxMatchOff
+		e.shiftCur()
 		break
 	}
 	// Add block to history
@@ -593,7 +593,7 @@ func (e *betterFastEncoderDict) Encode(blk *blockEnc, src []byte) {
 			for i := range e.longTable[:] {
 				e.longTable[i] = prevEntry{}
 			}
-			e.cur = e.maxMatchOff
+			e.shiftCur()
 			e.allDirty = true
 			break
 		}
@@ -628,7 +628,7 @@ func (e *betterFastEncoderDict) Encode(blk *blockEnc, src []byte) {
 			}
 		}
 		e.allDirty = true
-		e.cur = e.maxMatchOff
+		e.shiftCur()
 		break
 	}
 
@@ -1100,13 +1100,13 @@ func (e *betterFastEncoder) Reset(d *dict, singleBlock bool) {
 func (e *betterFastEncoderDict) Reset(d *dict, singleBlock bool) {
 	e.resetBase(d, singleBlock)
 	if d == nil {
+		// Tables will be modified without tracking dirty shards.
+		e.allDirty = true
 		return
 	}
 	// Init or copy dict table
-	if len(e.dictTable) != len(e.table) || d.id != e.lastDictID {
-		if len(e.dictTable) != len(e.table) {
-			e.dictTable = make([]tableEntry, len(e.table))
-		}
+	dictTable := dictEncTable(&e.fastBase, d, dictTableBetter, e.dictTable, func() []tableEntry {
+		dictTable := make([]tableEntry, len(e.table))
 		end := int32(len(d.content)) - 8 + e.maxMatchOff
 		for i := e.maxMatchOff; i < end; i += 4 {
 			const hashLog = betterShortTableBits
@@ -1116,38 +1116,39 @@ func (e *betterFastEncoderDict) Reset(d *dict, singleBlock bool) {
 			nextHash1 := hashLen(cv>>8, hashLog, betterShortLen)  // 1 -> 5
 			nextHash2 := hashLen(cv>>16, hashLog, betterShortLen) // 2 -> 6
 			nextHash3 := hashLen(cv>>24, hashLog, betterShortLen) // 3 -> 7
-			e.dictTable[nextHash] = tableEntry{
+			dictTable[nextHash] = tableEntry{
 				val:    uint32(cv),
 				offset: i,
 			}
-			e.dictTable[nextHash1] = tableEntry{
+			dictTable[nextHash1] = tableEntry{
 				val:    uint32(cv >> 8),
 				offset: i + 1,
 			}
-			e.dictTable[nextHash2] = tableEntry{
+			dictTable[nextHash2] = tableEntry{
 				val:    uint32(cv >> 16),
 				offset: i + 2,
 			}
-			e.dictTable[nextHash3] = tableEntry{
+			dictTable[nextHash3] = tableEntry{
 				val:    uint32(cv >> 24),
 				offset: i + 3,
 			}
 		}
-		e.lastDictID = d.id
+		return dictTable
+	})
+	if !sameTable(e.dictTable, dictTable) {
+		e.dictTable = dictTable
 		e.allDirty = true
 	}
 
 	// Init or copy dict table
-	if len(e.dictLongTable) != len(e.longTable) || d.id != e.lastDictID {
-		if len(e.dictLongTable) != len(e.longTable) {
-			e.dictLongTable = make([]prevEntry, len(e.longTable))
-		}
+	dictLongTable := dictEncTable(&e.fastBase, d, dictTableBetterLong, e.dictLongTable, func() []prevEntry {
+		dictLongTable := make([]prevEntry, len(e.longTable))
 		if len(d.content) >= 8 {
 			cv := load6432(d.content, 0)
 			h := hashLen(cv, betterLongTableBits, betterLongLen)
-			e.dictLongTable[h] = prevEntry{
+			dictLongTable[h] = prevEntry{
 				offset: e.maxMatchOff,
-				prev:   e.dictLongTable[h].offset,
+				prev:   dictLongTable[h].offset,
 			}
 
 			end := int32(len(d.content)) - 8 + e.maxMatchOff
@@ -1155,14 +1156,17 @@ func (e *betterFastEncoderDict) Reset(d *dict, singleBlock bool) {
 			for i := e.maxMatchOff + 1; i < end; i++ {
 				cv = cv>>8 | (uint64(d.content[off]) << 56)
 				h := hashLen(cv, betterLongTableBits, betterLongLen)
-				e.dictLongTable[h] = prevEntry{
+				dictLongTable[h] = prevEntry{
 					offset: i,
-					prev:   e.dictLongTable[h].offset,
+					prev:   dictLongTable[h].offset,
 				}
 				off++
 			}
 		}
-		e.lastDictID = d.id
+		return dictLongTable
+	})
+	if !sameTable(e.dictLongTable, dictLongTable) {
+		e.dictLongTable = dictLongTable
 		e.allDirty = true
 	}
 
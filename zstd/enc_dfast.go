@@ -48,7 +48,7 @@ func (e *doubleFastEncoder) Encode(blk *blockEnc, src []byte) {
 		if len(e.hist) == 0 {
 			e.table = [dFastShortTableSize]tableEntry{}
 			e.longTable = [dFastLongTableSize]tableEntry{}
-			e.cur = e.maxMatchOff
+			e.shiftCur()
 			break
 		}
 		// Shift down everything in the table that isn't already too far away.
@@ -71,7 +71,7 @@ func (e *doubleFastEncoder) Encode(blk *blockEnc, src []byte) {
 			}
 			e.longTable[i].offset = v
 		}
-		e.cur = e.maxMatchOff
+		e.shiftCur()
 		break
 	}
 
@@ -693,7 +693,7 @@ func (e *doubleFastEncoderDict) Encode(blk *blockEnc, src []byte) {
 				e.longTable[i] = tableEntry{}
 			}
 			e.markAllShardsDirty()
-			e.cur = e.maxMatchOff
+			e.shiftCur()
 			break
 		}
 		// Shift down everything in the table that isn't already too far away.
@@ -717,7 +717,7 @@ func (e *doubleFastEncoderDict) Encode(blk *blockEnc, src []byte) {
 			e.longTable[i].offset = v
 		}
 		e.markAllShardsDirty()
-		e.cur = e.maxMatchOff
+		e.shiftCur()
 		break
 	}
 
@@ -1046,26 +1046,27 @@ func (e *doubleFastEncoderDict) Reset(d *dict, singleBlock bool) {
 	}
 
 	// Init or copy dict table
-	if len(e.dictLongTable) != len(e.longTable) || d.id != e.lastDictID {
-		if len(e.dictLongTable) != len(e.longTable) {
-			e.dictLongTable = make([]tableEntry, len(e.longTable))
-		}
+	dictLongTable := dictEncTable(&e.fastBase, d, dictTableDFastLong, e.dictLongTable, func() []tableEntry {
+		dictLongTable := make([]tableEntry, len(e.longTable))
 		if len(d.content) >= 8 {
 			cv := load6432(d.content, 0)
-			e.dictLongTable[hashLen(cv, dFastLongTableBits, dFastLongLen)] = tableEntry{
+			dictLongTable[hashLen(cv, dFastLongTableBits, dFastLongLen)] = tableEntry{
 				val:    uint32(cv),
 				offset: e.maxMatchOff,
 			}
 			end := int32(len(d.content)) - 8 + e.maxMatchOff
 			for i := e.maxMatchOff + 1; i < end; i++ {
 				cv = cv>>8 | (uint64(d.content[i-e.maxMatchOff+7]) << 56)
-				e.dictLongTable[hashLen(cv, dFastLongTableBits, dFastLongLen)] = tableEntry{
+				dictLongTable[hashLen(cv, dFastLongTableBits, dFastLongLen)] = tableEntry{
 					val:    uint32(cv),
 					offset: i,
 				}
 			}
 		}
-		e.lastDictID = d.id
+		return dictLongTable
+	})
+	if !sameTable(e.dictLongTable, dictLongTable) {
+		e.dictLongTable = dictLongTable
 		allDirty = true
 	}
 	// Reset table to initial state
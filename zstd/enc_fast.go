@@ -48,7 +48,7 @@ func (e *fastEncoder) Encode(blk *blockEnc, src []byte) {
 			for i := range e.table[:] {
 				e.table[i] = tableEntry{}
 			}
-			e.cur = e.maxMatchOff
+			e.shiftCur()
 			break
 		}
 		// Shift down everything in the table that isn't already too far away.
@@ -62,7 +62,7 @@ func (e *fastEncoder) Encode(blk *blockEnc, src []byte) {
 			}
 			e.table[i].offset = v
 		}
-		e.cur = e.maxMatchOff
+		e.shiftCur()
 		break
 	}
 
@@ -545,7 +545,7 @@ func (e *fastEncoderDict) Encode(blk *blockEnc, src []byte) {
 	for e.cur >= e.bufferReset-int32(len(e.hist)) {
 		if len(e.hist) == 0 {
 			e.table = [tableSize]tableEntry{}
-			e.cur = e.maxMatchOff
+			e.shiftCur()
 			break
 		}
 		// Shift down everything in the table that isn't already too far away.
@@ -559,7 +559,7 @@ func (e *fastEncoderDict) Encode(blk *blockEnc, src []byte) {
 			}
 			e.table[i].offset = v
 		}
-		e.cur = e.maxMatchOff
+		e.shiftCur()
 		break
 	}
 
@@ -801,33 +801,34 @@ func (e *fastEncoder) Reset(d *dict, singleBlock bool) {
 func (e *fastEncoderDict) Reset(d *dict, singleBlock bool) {
 	e.resetBase(d, singleBlock)
 	if d == nil {
+		// Tables will be modified without tracking dirty shards.
+		e.allDirty = true
 		return
 	}
 
 	// Init or copy dict table
-	if len(e.dictTable) != len(e.table) || d.id != e.lastDictID {
-		if len(e.dictTable) != len(e.table) {
-			e.dictTable = make([]tableEntry, len(e.table))
-		}
-		if true {
-			end := e.maxMatchOff + int32(len(d.content)) - 8
-			for i := e.maxMatchOff; i < end; i += 2 {
-				const hashLog = tableBits
-
-				cv := load6432(d.content, i-e.maxMatchOff)
-				nextHash := hashLen(cv, hashLog, tableFastHashLen)     // 0 -> 6
-				nextHash1 := hashLen(cv>>8, hashLog, tableFastHashLen) // 1 -> 7
-				e.dictTable[nextHash] = tableEntry{
-					val:    uint32(cv),
-					offset: i,
-				}
-				e.dictTable[nextHash1] = tableEntry{
-					val:    uint32(cv >> 8),
-					offset: i + 1,
-				}
+	dictTable := dictEncTable(&e.fastBase, d, dictTableFast, e.dictTable, func() []tableEntry {
+		dictTable := make([]tableEntry, len(e.table))
+		end := e.maxMatchOff + int32(len(d.content)) - 8
+		for i := e.maxMatchOff; i < end; i += 2 {
+			const hashLog = tableBits
+
+			cv := load6432(d.content, i-e.maxMatchOff)
+			nextHash := hashLen(cv, hashLog, tableFastHashLen)     // 0 -> 6
+			nextHash1 := hashLen(cv>>8, hashLog, tableFastHashLen) // 1 -> 7
+			dictTable[nextHash] = tableEntry{
+				val:    uint32(cv),
+				offset: i,
+			}
+			dictTable[nextHash1] = tableEntry{
+				val:    uint32(cv >> 8),
+				offset: i + 1,
 			}
 		}
-		e.lastDictID = d.id
+		return dictTable
+	})
+	if !sameTable(e.dictTable, dictTable) {
+		e.dictTable = dictTable
 		e.allDirty = true
 	}
 
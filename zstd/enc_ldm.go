@@ -0,0 +1,238 @@
+// Copyright 2025+ Klaus Post. All rights reserved.
+// License information can be found in the LICENSE file.
+// Based on work by Yann Collet, released under BSD License.
+
+package zstd
+
+import (
+	"math/bits"
+
+	"github.com/snissn/compress/zstd/internal/xxhash"
+)
+
+const (
+	ldmMinMatch      = 64 // Minimum long distance match length. Also the rolling hash length.
+	ldmBucketLog     = 2  // Entries per hash bucket, log2
+	ldmBucketSize    = 1 << ldmBucketLog
+	ldmMinHashLog    = 16
+	ldmMaxHashLog    = 22
+	ldmDefaultWindow = 128 << 20
+)
+
+// ldmGear is the table used for the gear rolling hash.
+var ldmGear = func() (t [256]uint64) {
+	// splitmix64 with a fixed seed, so output is deterministic.
+	x := uint64(0x9E3779B97F4A7C15)
+	for i := range t {
+		x += 0x9E3779B97F4A7C15
+		z := x
+		z = (z ^ (z >> 30)) * 0xBF58476D1CE4E5B9
+		z = (z ^ (z >> 27)) * 0x94D049BB133111EB
+		t[i] = z ^ (z >> 31)
+	}
+	return t
+}()
+
+type ldmEntry struct {
+	offset   int32
+	checksum uint32
+}
+
+type ldmMatch struct {
+	s      int32 // Start in the current block.
+	length int32
+	offset int32 // Distance back.
+}
+
+// ldmEncoder wraps a block encoder and adds long distance matching.
+// Positions are sampled with a gear rolling hash over the input and stored
+// in a large hash table. Matches found in the table are emitted as sequences,
+// and the content between them is handed to the wrapped encoder.
+// This mimics zstd_ldm.c.
+type ldmEncoder struct {
+	encoder
+	fb *fastBase
+
+	table    []ldmEntry
+	dirty    bool // Entries have been added since the table was cleared.
+	hashLog  uint
+	stopMask uint64
+	gear     uint64
+	matches  []ldmMatch
+	tmp      blockEnc
+}
+
+// newLDMEncoder returns enc with long distance matching added.
+// enc must use a fastBase for history.
+func newLDMEncoder(enc encoder, windowSize int) *ldmEncoder {
+	b, ok := enc.(interface{ base() *fastBase })
+	if !ok {
+		panic("ldm: encoder without history")
+	}
+	hashLog := uint(bits.Len(uint(windowSize))-1) - 8
+	hashLog = min(max(hashLog, ldmMinHashLog), ldmMaxHashLog)
+	// Sample one position per 2^(windowLog-hashLog) on average.
+	rateLog := max(uint(bits.Len(uint(windowSize))-1)-hashLog, 4)
+	e := &ldmEncoder{
+		encoder:  enc,
+		fb:       b.base(),
+		hashLog:  hashLog,
+		stopMask: ((1 << rateLog) - 1) << (64 - rateLog),
+	}
+	e.fb.onShift = e.shift
+	e.tmp.init()
+	return e
+}
+
+// base returns the shared encoder state of the wrapped encoder.
+func (e *ldmEncoder) base() *fastBase {
+	return e.fb
+}
+
+// Reset will reset and set a dictionary if not nil
+func (e *ldmEncoder) Reset(d *dict, singleBlock bool) {
+	e.encoder.Reset(d, singleBlock)
+	e.gear = 0
+	if e.dirty {
+		// Entries from the previous stream must not be matched.
+		clear(e.table)
+		e.dirty = false
+	}
+	if e.table == nil && !singleBlock {
+		e.table = make([]ldmEntry, ldmBucketSize<<e.hashLog)
+	}
+}
+
+// Encode will find long distance matches in src and encode
+// the remaining content with the wrapped encoder.
+func (e *ldmEncoder) Encode(blk *blockEnc, src []byte) {
+	if e.table == nil {
+		e.table = make([]ldmEntry, ldmBucketSize<<e.hashLog)
+	}
+	e.findMatches(src)
+	if len(e.matches) == 0 {
+		e.encoder.Encode(blk, src)
+		return
+	}
+	if debugEncoder {
+		println("ldm: found", len(e.matches), "long matches in", len(src), "bytes")
+	}
+
+	blk.size = len(src)
+	recent := blk.recentOffsets
+	nextEmit := int32(0)
+	emit := func(until int32) (lits uint32) {
+		if until == nextEmit {
+			return 0
+		}
+		tmp := &e.tmp
+		tmp.reset(nil)
+		tmp.recentOffsets = recent
+		e.encoder.Encode(tmp, src[nextEmit:until])
+		blk.literals = append(blk.literals, tmp.literals...)
+		blk.sequences = append(blk.sequences, tmp.sequences...)
+		recent = tmp.recentOffsets
+		lits = uint32(len(tmp.literals))
+		for _, s := range tmp.sequences {
+			lits -= s.litLen
+		}
+		return lits
+	}
+	for _, m := range e.matches {
+		lits := emit(m.s)
+		end := m.s + m.length
+		e.fb.addBlock(src[m.s:end])
+		blk.sequences = append(blk.sequences, seq{
+			litLen:   lits,
+			matchLen: uint32(m.length - zstdMinMatch),
+			offset:   uint32(m.offset) + 3,
+		})
+		if debugSequences {
+			println("ldm sequence", blk.sequences[len(blk.sequences)-1])
+		}
+		recent[2], recent[1], recent[0] = recent[1], recent[0], uint32(m.offset)
+		nextEmit = end
+	}
+	blk.extraLits = int(emit(int32(len(src))))
+	blk.recentOffsets = recent
+}
+
+// shift will adjust table offsets when the wrapped encoder
+// moves its offsets to protect against wraparound.
+func (e *ldmEncoder) shift() {
+	for i := range e.table {
+		e.table[i].offset = e.fb.shiftOffset(e.table[i].offset)
+	}
+}
+
+// findMatches will find long matches in src against the current history
+// and add sampled positions of src to the table.
+// src has not yet been added to history.
+func (e *ldmEncoder) findMatches(src []byte) {
+	e.matches = e.matches[:0]
+	fb := e.fb
+	hist := fb.hist
+	histStart := fb.cur
+	absStart := fb.cur + int32(len(hist))
+	hashMask := uint64(1)<<e.hashLog - 1
+
+	// Content before nextAllowed is covered by a match.
+	nextAllowed := int32(0)
+	h := e.gear
+	for i := int32(0); i < int32(len(src)); i++ {
+		h = (h << 1) + ldmGear[src[i]]
+		if h&e.stopMask != 0 {
+			continue
+		}
+		start := i + 1 - ldmMinMatch
+		if start < 0 {
+			continue
+		}
+		cs := xxhash.Sum64(src[start : i+1])
+		bucket := e.table[(cs&hashMask)<<ldmBucketLog:][:ldmBucketSize]
+		check := uint32(cs >> 32)
+
+		if start >= nextAllowed {
+			var best ldmMatch
+			for _, cand := range bucket {
+				if cand.checksum != check || cand.offset < histStart || cand.offset > absStart-ldmMinMatch {
+					continue
+				}
+				dist := absStart + start - cand.offset
+				if dist <= 0 || dist >= fb.maxMatchOff {
+					continue
+				}
+				t := cand.offset - histStart
+				l := int32(matchLen(src[start:], hist[t:]))
+				if l < ldmMinMatch {
+					continue
+				}
+				// Extend backwards.
+				s := start
+				for s > nextAllowed && t > 0 && src[s-1] == hist[t-1] {
+					s--
+					t--
+					l++
+				}
+				if l > best.length {
+					best = ldmMatch{s: s, length: min(l, maxMatchLength), offset: dist}
+				}
+			}
+			if best.length >= ldmMinMatch {
+				e.matches = append(e.matches, best)
+				nextAllowed = best.s + best.length
+			}
+		}
+		// Insert, replacing the oldest entry.
+		e.dirty = true
+		copy(bucket[1:], bucket[:ldmBucketSize-1])
+		bucket[0] = ldmEntry{offset: absStart + start, checksum: check}
+	}
+	e.gear = h
+}
+
+// EncodeNoHist will encode a block with no history and no following blocks.
+// There is no history to match against, so the wrapped encoder is used directly.
+func (e *ldmEncoder) EncodeNoHist(blk *blockEnc, src []byte) {
+	e.encoder.EncodeNoHist(blk, src)
+}
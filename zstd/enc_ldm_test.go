@@ -0,0 +1,136 @@
+package zstd
+
+import (
+	"bytes"
+	"math/rand"
+	"testing"
+)
+
+func TestEncoderLongDistanceMatching(t *testing.T) {
+	if isRaceTest {
+		t.SkipNow()
+	}
+	rng := rand.New(rand.NewSource(1))
+	a := make([]byte, 1<<20)
+	rng.Read(a)
+	b := make([]byte, 12<<20)
+	rng.Read(b)
+	// Repeat a after b, and insert a few modifications.
+	in := append(append(append([]byte{}, a...), b...), a...)
+	for i := range 10 {
+		in[len(a)+len(b)+i*50000] ^= 0xff
+	}
+
+	dec, err := NewReader(nil, WithDecoderMaxWindow(16<<20))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer dec.Close()
+	for level := speedNotSet + 1; level < speedLast; level++ {
+		t.Run(level.String(), func(t *testing.T) {
+			if testing.Short() && level == SpeedUltraCompression {
+				t.SkipNow()
+			}
+			encode := func(ldm bool, conc int) (all, stream []byte) {
+				enc, err := NewWriter(nil, WithEncoderLevel(level), WithWindowSize(16<<20), WithLongDistanceMatching(ldm), WithEncoderConcurrency(conc))
+				if err != nil {
+					t.Fatal(err)
+				}
+				all = enc.EncodeAll(in, nil)
+				var buf bytes.Buffer
+				enc.Reset(&buf)
+				if _, err := enc.Write(in); err != nil {
+					t.Fatal(err)
+				}
+				if err := enc.Close(); err != nil {
+					t.Fatal(err)
+				}
+				return all, buf.Bytes()
+			}
+			plain, _ := encode(false, 1)
+			for conc := 1; conc <= 2; conc++ {
+				all, stream := encode(true, conc)
+				for _, b := range [][]byte{all, stream} {
+					got, err := dec.DecodeAll(b, nil)
+					if err != nil {
+						t.Fatal(err)
+					}
+					if !bytes.Equal(got, in) {
+						t.Fatal("output mismatch")
+					}
+				}
+				t.Logf("concurrency %d: %d -> %d bytes (plain %d bytes)", conc, len(in), len(all), len(plain))
+				// Random input, so only the repeat can be compressed.
+				limit := len(in) - len(a) + len(a)/50
+				if len(all) > limit || len(stream) > limit {
+					t.Errorf("long distance matching did not find repeated content. got %d/%d, want <= %d", len(all), len(stream), limit)
+				}
+			}
+		})
+	}
+}
+
+func TestEncoderLongDistanceMatchingShift(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	a := make([]byte, 256<<10)
+	rng.Read(a)
+	b := make([]byte, 1<<20)
+	rng.Read(b)
+	in := append(append(append([]byte{}, a...), b...), a...)
+
+	enc, err := NewWriter(nil, WithWindowSize(2<<20), WithLongDistanceMatching(true), WithEncoderConcurrency(1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	enc.Reset(&buf)
+	ldm := enc.state.encoder.(*ldmEncoder)
+	fb := ldm.fb
+	// Make the encoder protect against wraparound while the stream is encoded,
+	// so offsets are moved down in the middle of the stream.
+	fb.cur += 100 << 20
+	fb.bufferReset = fb.cur + int32(len(a)+len(b)/2)
+	shifts := 0
+	onShift := fb.onShift
+	fb.onShift = func() {
+		shifts++
+		onShift()
+	}
+	if _, err := enc.Write(in); err != nil {
+		t.Fatal(err)
+	}
+	if err := enc.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if shifts == 0 {
+		t.Fatal("offsets were not shifted")
+	}
+	for _, e := range ldm.table {
+		if e.offset > fb.cur+int32(len(fb.hist)) {
+			t.Fatalf("table offset %d not shifted, history ends at %d", e.offset, fb.cur+int32(len(fb.hist)))
+		}
+	}
+	dec, err := NewReader(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer dec.Close()
+	got, err := dec.DecodeAll(buf.Bytes(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, in) {
+		t.Fatal("output mismatch")
+	}
+	if limit := len(in) - len(a) + len(a)/50; buf.Len() > limit {
+		t.Errorf("repeated content not found after shift. got %d, want <= %d", buf.Len(), limit)
+	}
+
+	// Entries of the previous stream are removed by Reset.
+	enc.Reset(nil)
+	for _, e := range ldm.table {
+		if e != (ldmEntry{}) {
+			t.Fatal("table not cleared by Reset")
+		}
+	}
+}
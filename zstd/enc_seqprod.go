@@ -0,0 +1,217 @@
+// Copyright 2025+ Klaus Post. All rights reserved.
+// License information can be found in the LICENSE file.
+// Based on work by Yann Collet, released under BSD License.
+
+package zstd
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+)
+
+// Sequence is a match preceded by literals.
+// Sequences are produced by a SequenceProducer.
+type Sequence struct {
+	// LitLen is the number of literals before the match.
+	LitLen uint32
+
+	// MatchLen is the length of the match.
+	// If 0, the sequence only contains literals and Offset must be 0.
+	// Otherwise it must be at least 3.
+	MatchLen uint32
+
+	// Offset is the distance back from the start of the match to the matched content.
+	// The match may overlap itself, so Offset may be less than MatchLen.
+	Offset uint32
+}
+
+// SequenceProducer produces the sequences for a block.
+// src is the content of the block and hist contains the content
+// preceding src in the window, including any dictionary.
+// Neither must be modified or retained after the call.
+//
+// Sequences must be appended to dst and returned.
+// Matches may reference hist and previous content of src, but not further back than the window size.
+// Literals left after the last sequence are added as trailing literals.
+//
+// If an error is returned or the sequences are invalid, the block is compressed
+// with the match finder of the encoder level instead.
+// The producer may be called concurrently if encoder concurrency is above 1.
+type SequenceProducer func(dst []Sequence, src, hist []byte) ([]Sequence, error)
+
+// seqProducerEncoder wraps a block encoder and uses sequences from a SequenceProducer.
+// The wrapped encoder is used if the producer fails.
+// This mimics ZSTD_registerSequenceProducer with fallback enabled.
+type seqProducerEncoder struct {
+	encoder
+	fb   *fastBase
+	fn   SequenceProducer
+	seqs []Sequence
+	tmp  blockEnc
+}
+
+// newSeqProducerEncoder returns enc using sequences from fn.
+// enc must use a fastBase for history.
+func newSeqProducerEncoder(enc encoder, fn SequenceProducer) *seqProducerEncoder {
+	b, ok := enc.(interface{ base() *fastBase })
+	if !ok {
+		panic("sequence producer: encoder without history")
+	}
+	e := &seqProducerEncoder{encoder: enc, fb: b.base(), fn: fn}
+	e.tmp.init()
+	return e
+}
+
+// Encode will encode src with sequences from the producer.
+func (e *seqProducerEncoder) Encode(blk *blockEnc, src []byte) {
+	fb := e.fb
+	hist := fb.hist
+	if len(hist) > int(fb.maxMatchOff) {
+		hist = hist[len(hist)-int(fb.maxMatchOff):]
+	}
+	if !e.produce(blk, src, hist) {
+		e.encoder.Encode(blk, src)
+		return
+	}
+	if fb.cur >= fb.bufferReset-int32(len(fb.hist)) {
+		// Let the wrapped encoder protect against e.cur wraparound.
+		e.tmp.reset(nil)
+		e.encoder.Encode(&e.tmp, nil)
+	}
+	fb.addBlock(src)
+}
+
+// EncodeNoHist will encode a block with no history and no following blocks.
+func (e *seqProducerEncoder) EncodeNoHist(blk *blockEnc, src []byte) {
+	if !e.produce(blk, src, nil) {
+		e.encoder.EncodeNoHist(blk, src)
+	}
+}
+
+// produce will add sequences from the producer to blk.
+// If the producer fails or returns invalid sequences, blk is left unchanged
+// and false is returned.
+func (e *seqProducerEncoder) produce(blk *blockEnc, src, hist []byte) bool {
+	var err error
+	e.seqs, err = e.fn(e.seqs[:0], src, hist)
+	if err == nil {
+		err = e.validate(src, hist)
+	}
+	if err != nil {
+		if debugEncoder {
+			println("sequence producer:", err.Error(), "- using fallback")
+		}
+		return false
+	}
+
+	blk.size = len(src)
+	recent := blk.recentOffsets
+	nextEmit := 0
+	s := 0
+	for _, sq := range e.seqs {
+		s += int(sq.LitLen)
+		if sq.MatchLen == 0 {
+			continue
+		}
+		blk.literals = append(blk.literals, src[nextEmit:s]...)
+		litLen := uint32(s - nextEmit)
+		for ml := sq.MatchLen; ml > 0; {
+			// Split matches that are too long. Remaining parts must be at least zstdMinMatch.
+			n := ml
+			if n > maxMatchLength {
+				n = min(maxMatchLength, ml-zstdMinMatch)
+			}
+			offset := sq.Offset + 3
+			// Same rule as the built-in match finders.
+			if len(blk.sequences) > 2 {
+				offset = seqRepeatCode(sq.Offset, recent, litLen == 0)
+			}
+			blk.sequences = append(blk.sequences, seq{litLen: litLen, matchLen: n - zstdMinMatch, offset: offset})
+			if debugSequences {
+				println("producer sequence", blk.sequences[len(blk.sequences)-1])
+			}
+			recent = ultraUpdateReps(recent, offset, litLen == 0)
+			litLen = 0
+			ml -= n
+		}
+		s += int(sq.MatchLen)
+		nextEmit = s
+	}
+	if nextEmit < len(src) {
+		blk.literals = append(blk.literals, src[nextEmit:]...)
+		blk.extraLits = len(src) - nextEmit
+	}
+	blk.recentOffsets = recent
+	return true
+}
+
+// validate checks that the sequences in e.seqs cover src
+// and all matches are within the window and match the content.
+func (e *seqProducerEncoder) validate(src, hist []byte) error {
+	s := 0
+	for i, sq := range e.seqs {
+		if int(sq.LitLen) > len(src)-s {
+			return fmt.Errorf("sequence %d: literals beyond end of block", i)
+		}
+		s += int(sq.LitLen)
+		if sq.MatchLen == 0 {
+			if sq.Offset != 0 {
+				return fmt.Errorf("sequence %d: offset without match", i)
+			}
+			continue
+		}
+		ml, d := int(sq.MatchLen), int(sq.Offset)
+		switch {
+		case ml < zstdMinMatch:
+			return fmt.Errorf("sequence %d: match length %d < %d", i, ml, zstdMinMatch)
+		case ml > len(src)-s:
+			return fmt.Errorf("sequence %d: match beyond end of block", i)
+		case d == 0:
+			return fmt.Errorf("sequence %d: zero offset", i)
+		case d > s+len(hist) || d > int(e.fb.maxMatchOff):
+			return fmt.Errorf("sequence %d: offset %d out of window", i, d)
+		}
+		// Verify the content, so invalid sequences cannot corrupt output.
+		t := s - d
+		if t < 0 {
+			n := min(-t, ml)
+			if !bytes.Equal(hist[len(hist)+t:len(hist)+t+n], src[s:s+n]) {
+				return fmt.Errorf("sequence %d: %w", i, errSeqMismatch)
+			}
+			s, t, ml = s+n, 0, ml-n
+		}
+		if !bytes.Equal(src[t:t+ml], src[s:s+ml]) {
+			return fmt.Errorf("sequence %d: %w", i, errSeqMismatch)
+		}
+		s += ml
+	}
+	return nil
+}
+
+var errSeqMismatch = errors.New("match content mismatch")
+
+// seqRepeatCode returns the sequence offset of a match at offset,
+// using a repeat code if possible.
+func seqRepeatCode(offset uint32, recent [3]uint32, ll0 bool) uint32 {
+	if ll0 {
+		switch offset {
+		case recent[1]:
+			return 1
+		case recent[2]:
+			return 2
+		case recent[0] - 1:
+			return 3
+		}
+		return offset + 3
+	}
+	switch offset {
+	case recent[0]:
+		return 1
+	case recent[1]:
+		return 2
+	case recent[2]:
+		return 3
+	}
+	return offset + 3
+}
@@ -0,0 +1,144 @@
+package zstd
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"os"
+	"testing"
+)
+
+// testSeqProducer is a simple greedy match finder.
+func testSeqProducer(dst []Sequence, src, hist []byte) ([]Sequence, error) {
+	buf := append(append([]byte{}, hist...), src...)
+	table := make(map[uint32]int)
+	for i := 0; i+4 <= len(hist); i++ {
+		table[binary.LittleEndian.Uint32(buf[i:])] = i
+	}
+	nextEmit := len(hist)
+	for s := len(hist); s+4 <= len(buf); {
+		v := binary.LittleEndian.Uint32(buf[s:])
+		t, ok := table[v]
+		table[v] = s
+		if !ok {
+			s++
+			continue
+		}
+		l := 4
+		for s+l < len(buf) && buf[s+l] == buf[t+l] {
+			l++
+		}
+		dst = append(dst, Sequence{LitLen: uint32(s - nextEmit), MatchLen: uint32(l), Offset: uint32(s - t)})
+		s += l
+		nextEmit = s
+	}
+	return dst, nil
+}
+
+func TestEncoderSequenceProducer(t *testing.T) {
+	in, err := os.ReadFile("../testdata/Mark.Twain-Tom.Sawyer.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	dec, err := NewReader(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer dec.Close()
+
+	var calls int
+	producers := map[string]SequenceProducer{
+		"greedy": testSeqProducer,
+		"literals": func(dst []Sequence, src, hist []byte) ([]Sequence, error) {
+			// Only literals, split over several sequences.
+			for len(src) > 1000 {
+				dst = append(dst, Sequence{LitLen: 1000})
+				src = src[1000:]
+			}
+			return dst, nil
+		},
+		"error": func(dst []Sequence, src, hist []byte) ([]Sequence, error) {
+			calls++
+			return dst, errors.New("no sequences")
+		},
+		"invalid": func(dst []Sequence, src, hist []byte) ([]Sequence, error) {
+			dst, _ = testSeqProducer(dst, src, hist)
+			if len(dst) > 0 {
+				dst[len(dst)/2].Offset++
+			}
+			return dst, nil
+		},
+		"long": func(dst []Sequence, src, hist []byte) ([]Sequence, error) {
+			// Repeat the first byte for the entire block.
+			n := 1
+			for n < len(src) && src[n] == src[0] {
+				n++
+			}
+			if n < zstdMinMatch+1 {
+				return dst, nil
+			}
+			return append(dst, Sequence{LitLen: 1, MatchLen: uint32(n - 1), Offset: 1}), nil
+		},
+	}
+	long := bytes.Repeat([]byte{'a'}, 500000)
+	for name, p := range producers {
+		t.Run(name, func(t *testing.T) {
+			input := in
+			if name == "long" {
+				input = long
+			}
+			ref, err := NewWriter(nil, WithEncoderLevel(SpeedFastest), WithEncoderConcurrency(1))
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer ref.Close()
+			e, err := NewWriter(nil, WithEncoderLevel(SpeedFastest), WithEncoderConcurrency(1), WithEncoderSequenceProducer(p))
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer e.Close()
+			want := ref.EncodeAll(input, nil)
+			for _, blockSize := range []int{len(input), 16 << 10} {
+				var buf bytes.Buffer
+				e.Reset(&buf)
+				for rem := input; len(rem) > 0; {
+					n := min(len(rem), blockSize)
+					if _, err := e.Write(rem[:n]); err != nil {
+						t.Fatal(err)
+					}
+					if err := e.Flush(); err != nil {
+						t.Fatal(err)
+					}
+					rem = rem[n:]
+				}
+				if err := e.Close(); err != nil {
+					t.Fatal(err)
+				}
+				for _, b := range [][]byte{e.EncodeAll(input, nil), buf.Bytes()} {
+					got, err := dec.DecodeAll(b, nil)
+					if err != nil {
+						t.Fatal(err)
+					}
+					if !bytes.Equal(got, input) {
+						t.Fatal("decoded mismatch")
+					}
+				}
+				t.Logf("block size %d: %d -> %d bytes (fastest: %d bytes)", blockSize, len(input), buf.Len(), len(want))
+			}
+			switch name {
+			case "error":
+				if calls == 0 {
+					t.Error("producer not called")
+				}
+				// Fallback should be identical.
+				if got := e.EncodeAll(input, nil); !bytes.Equal(got, want) {
+					t.Error("fallback output differs")
+				}
+			case "literals":
+				if got := e.EncodeAll(input, nil); len(got) < len(input)/2 {
+					t.Errorf("literals only, but compressed to %d bytes", len(got))
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,571 @@
+// Copyright 2025+ Klaus Post. All rights reserved.
+// License information can be found in the LICENSE file.
+// Based on work by Yann Collet, released under BSD License.
+
+package zstd
+
+import (
+	"fmt"
+	"math"
+	"math/bits"
+)
+
+const (
+	ultraHashLog      = 22 // Bits used in the hash head table
+	ultraMaxTreeLog   = 23 // Maximum bits used for the binary tree
+	ultraHashLen      = 4  // Bytes used for hashing
+	ultraSearchDepth  = 256
+	ultraSufficient   = 512  // Matches of this length are taken without further parsing.
+	ultraOptNum       = 4096 // Maximum number of positions parsed at once.
+	ultraInputMargin  = 8
+	ultraMinBlockSize = 16
+
+	// Prices are in 1/256 bits.
+	ultraPriceBits = 8
+	ultraMaxPrice  = math.MaxInt32
+)
+
+// ultraMatch is a match candidate.
+type ultraMatch struct {
+	length int32
+	offset uint32 // Offset as stored in sequences, 1-3 for repeats.
+}
+
+// ultraNode is the cheapest way to reach a position while parsing.
+type ultraNode struct {
+	price  int32
+	litLen int32  // Literals since last match.
+	mLen   int32  // Length of the match ending here. 0 for literals.
+	offset uint32 // Sequence offset of the match ending here.
+	reps   [3]uint32
+	nSeq   uint8 // Sequences in block, max 3.
+}
+
+// ultraStats contains symbol statistics used for pricing.
+type ultraStats struct {
+	lit [256]uint32
+	ll  [maxLLCode + 1]uint32
+	ml  [maxMLCode + 1]uint32
+	of  [maxOffsetBits + 1]uint32
+}
+
+// ultraEncoder uses price based optimal parsing, similar to the "btultra2"
+// strategy of the reference implementation.
+// Positions are indexed in binary trees sorted by content.
+// For every position the cheapest combination of literals and matches is found
+// using prices estimated from the statistics of previous blocks,
+// with FSE prices from real FSE tables.
+// The first block of a frame is parsed twice, so the statistics of the block itself can be used.
+type ultraEncoder struct {
+	fastBase
+	head   []int32
+	bt     []int32 // Binary tree with smaller and larger entries for each position.
+	btMask int32
+	next   int32 // Next position to add to the tree.
+
+	// Undo log for tables, when parsing for statistics only.
+	undo    []ultraUndo
+	logUndo bool
+
+	stats      ultraStats
+	statsValid bool
+
+	litPrice [256]int32
+	llPrice  [maxLLCode + 1]int32
+	mlPrice  [maxMLCode + 1]int32
+	ofPrice  [maxOffsetBits + 1]int32
+	fse      fseEncoder
+
+	opt     []ultraNode
+	matches []ultraMatch
+	path    []int32
+}
+
+type ultraUndo struct {
+	p   *int32
+	old int32
+}
+
+// Encode will encode the content with optimal parsing.
+func (e *ultraEncoder) Encode(blk *blockEnc, src []byte) {
+	if e.head == nil {
+		e.init()
+	}
+
+	// Protect against e.cur wraparound.
+	// Tables are cleared and existing history is indexed again.
+	if e.cur >= e.bufferReset-int32(len(e.hist)) {
+		clear(e.head)
+		clear(e.bt)
+		e.shiftCur()
+		e.next = e.cur
+	}
+
+	// Add block to history
+	s := e.addBlock(src)
+	blk.size = len(src)
+	// History may have been moved down.
+	e.next = max(e.next, e.cur)
+
+	if len(src) < ultraMinBlockSize {
+		blk.extraLits = len(src)
+		blk.literals = blk.literals[:len(src)]
+		copy(blk.literals, src)
+		return
+	}
+
+	src = e.hist
+	if !e.statsValid {
+		// Parse once to collect statistics for the first block.
+		e.setPrices(src[s:])
+		nSeqs, nLits := len(blk.sequences), len(blk.literals)
+		reps := blk.recentOffsets
+		next := e.next
+		e.logUndo = true
+		e.parse(blk, src, s)
+		e.logUndo = false
+		e.updateStats(blk, nSeqs, nLits)
+		e.statsValid = true
+
+		// Restore state.
+		for i := len(e.undo) - 1; i >= 0; i-- {
+			u := e.undo[i]
+			*u.p = u.old
+		}
+		e.undo = e.undo[:0]
+		e.next = next
+		blk.recentOffsets = reps
+		blk.sequences = blk.sequences[:nSeqs]
+		blk.literals = blk.literals[:nLits]
+		blk.extraLits = 0
+	}
+	e.setPrices(nil)
+	nSeqs, nLits := len(blk.sequences), len(blk.literals)
+	e.parse(blk, src, s)
+	e.updateStats(blk, nSeqs, nLits)
+	if debugEncoder {
+		println("returning, recent offsets:", blk.recentOffsets, "extra literals:", blk.extraLits)
+	}
+}
+
+// init will allocate the tables.
+func (e *ultraEncoder) init() {
+	btLog := min(bits.Len(uint(e.maxMatchOff))-1, ultraMaxTreeLog)
+	if e.lowMem {
+		btLog = min(btLog, 20)
+	}
+	e.head = make([]int32, 1<<ultraHashLog)
+	e.bt = make([]int32, 2<<btLog)
+	e.btMask = 1<<btLog - 1
+	e.opt = make([]ultraNode, ultraOptNum+ultraSufficient+1)
+	e.next = e.cur
+}
+
+// parse will parse the block starting at s in src and add sequences and literals to blk.
+func (e *ultraEncoder) parse(blk *blockEnc, src []byte, s int32) {
+	ilimit := int32(len(src)) - ultraInputMargin
+	reps := blk.recentOffsets
+	nextEmit := s
+	ip := s
+
+	// emit will emit a match at s.
+	emit := func(s int32, m ultraMatch) {
+		sq := seq{
+			litLen:   uint32(s - nextEmit),
+			matchLen: uint32(m.length - zstdMinMatch),
+			offset:   m.offset,
+		}
+		blk.literals = append(blk.literals, src[nextEmit:s]...)
+		blk.sequences = append(blk.sequences, sq)
+		if debugSequences {
+			println("sequence", sq, "next s:", s+m.length)
+		}
+		if debugAsserts {
+			d := int32(ultraOffset(m.offset, reps, sq.litLen == 0))
+			if d <= 0 || d > s || d >= e.maxMatchOff {
+				panic(fmt.Sprintf("invalid offset %d at %d", d, s))
+			}
+			if string(src[s:s+m.length]) != string(src[s-d:s-d+m.length]) {
+				panic(fmt.Sprintf("invalid match at %d, offset %d, length %d", s, d, m.length))
+			}
+		}
+		reps = ultraUpdateReps(reps, m.offset, sq.litLen == 0)
+		nextEmit = s + m.length
+	}
+
+	for ip < ilimit {
+		litLen := ip - nextEmit
+		nSeq := uint8(min(len(blk.sequences), 3))
+		matches := e.findMatches(src, ip, litLen, reps, nSeq == 3)
+		if len(matches) == 0 {
+			ip++
+			continue
+		}
+		if m := matches[len(matches)-1]; m.length >= ultraSufficient {
+			emit(ip, m)
+			ip += m.length
+			continue
+		}
+
+		// Find the cheapest path from ip.
+		opt := e.opt
+		opt[0] = ultraNode{price: e.llPrice[llCode(uint32(litLen))] + int32(llBitsTable[llCode(uint32(litLen))])<<ultraPriceBits, litLen: litLen, reps: reps, nSeq: nSeq}
+		lastPos := e.addMatches(0, 0, matches)
+		var tail ultraMatch
+		end := int32(0)
+		for cur := int32(1); cur <= lastPos; cur++ {
+			p := ip + cur
+			prev := &opt[cur-1]
+			price := prev.price + e.litPrice[src[p-1]] + e.litLenDelta(prev.litLen)
+			if price <= opt[cur].price {
+				opt[cur] = ultraNode{price: price, litLen: prev.litLen + 1, reps: prev.reps, nSeq: prev.nSeq}
+			}
+			if cur == lastPos || p >= ilimit {
+				continue
+			}
+			if cur >= ultraOptNum {
+				break
+			}
+			node := &opt[cur]
+			matches := e.findMatches(src, p, node.litLen, node.reps, node.nSeq == 3)
+			if len(matches) == 0 {
+				continue
+			}
+			if m := matches[len(matches)-1]; m.length >= ultraSufficient {
+				end = cur
+				tail = m
+				break
+			}
+			lastPos = e.addMatches(cur, lastPos, matches)
+		}
+		if tail.length == 0 {
+			end = lastPos
+		}
+
+		// Backtrack to find the matches on the path.
+		e.path = e.path[:0]
+		for pos := end; pos > 0; {
+			if n := &opt[pos]; n.mLen > 0 {
+				e.path = append(e.path, pos)
+				pos -= n.mLen
+				continue
+			}
+			pos--
+		}
+		for i := len(e.path) - 1; i >= 0; i-- {
+			n := &opt[e.path[i]]
+			emit(ip+e.path[i]-n.mLen, ultraMatch{length: n.mLen, offset: n.offset})
+		}
+		if debugAsserts && reps != opt[end].reps {
+			panic(fmt.Sprintf("recent offsets mismatch: %v != %v", reps, opt[end].reps))
+		}
+		ip += end
+		if tail.length > 0 {
+			emit(ip, tail)
+			ip += tail.length
+		}
+	}
+
+	if int(nextEmit) < len(src) {
+		blk.literals = append(blk.literals, src[nextEmit:]...)
+		blk.extraLits = len(src) - int(nextEmit)
+	}
+	blk.recentOffsets = reps
+}
+
+// addMatches will update the nodes reachable from cur with the matches.
+// The new last position is returned.
+func (e *ultraEncoder) addMatches(cur, lastPos int32, matches []ultraMatch) int32 {
+	opt := e.opt
+	node := opt[cur]
+	base := node.price + e.llPrice[0]
+	nSeq := min(node.nSeq+1, 3)
+	length := int32(zstdMinMatch)
+	for _, m := range matches {
+		reps := ultraUpdateReps(node.reps, m.offset, node.litLen == 0)
+		ofc := ofCode(m.offset)
+		mPrice := base + e.ofPrice[ofc] + int32(ofc)<<ultraPriceBits
+		for ; length <= m.length; length++ {
+			pos := cur + length
+			for lastPos < pos {
+				lastPos++
+				opt[lastPos].price = ultraMaxPrice
+			}
+			mlc := mlCode(uint32(length - zstdMinMatch))
+			price := mPrice + e.mlPrice[mlc] + int32(mlBitsTable[mlc])<<ultraPriceBits
+			if price < opt[pos].price {
+				opt[pos] = ultraNode{price: price, mLen: length, offset: m.offset, reps: reps, nSeq: nSeq}
+			}
+		}
+	}
+	return lastPos
+}
+
+// litLenDelta returns the price difference of adding a literal to litLen literals.
+func (e *ultraEncoder) litLenDelta(litLen int32) int32 {
+	a, b := llCode(uint32(litLen)), llCode(uint32(litLen+1))
+	if a == b {
+		return 0
+	}
+	return e.llPrice[b] - e.llPrice[a] + int32(llBitsTable[b]-llBitsTable[a])<<ultraPriceBits
+}
+
+// findMatches returns matches at s with increasing length.
+// Positions up to and including s are added to the tree.
+func (e *ultraEncoder) findMatches(src []byte, s, litLen int32, reps [3]uint32, canRepeat bool) []ultraMatch {
+	matches := e.matches[:0]
+	best := int32(zstdMinMatch - 1)
+	maxLen := min(int32(len(src))-s, maxMatchLength)
+
+	if canRepeat {
+		for i := uint32(1); i <= 3; i++ {
+			d := int32(ultraOffset(i, reps, litLen == 0))
+			if d <= 0 || d > s || d >= e.maxMatchOff {
+				continue
+			}
+			if src[s+best] != src[s-d+best] {
+				continue
+			}
+			l := min(e.matchlen(s, s-d, src), maxLen)
+			if l > best {
+				best = l
+				matches = append(matches, ultraMatch{length: l, offset: i})
+				if l == maxLen {
+					break
+				}
+			}
+		}
+	}
+
+	// Add skipped positions.
+	for e.next < s+e.cur {
+		e.insert(src, e.next-e.cur, false, nil, 0)
+	}
+	if e.next == s+e.cur {
+		matches = e.insert(src, s, true, matches, best)
+	}
+	e.matches = matches
+	return matches
+}
+
+// insert will add position s in src to the binary tree.
+// If collect is set, matches longer than best are appended to matches.
+func (e *ultraEncoder) insert(src []byte, s int32, collect bool, matches []ultraMatch, best int32) []ultraMatch {
+	abs := s + e.cur
+	h := hashLen(uint64(load3232(src, s)), ultraHashLog, ultraHashLen)
+	cand := e.head[h]
+	e.set(&e.head[h], abs)
+
+	minPos := max(s-e.maxMatchOff+1, s-e.btMask, 0) + e.cur
+	smaller := &e.bt[2*(abs&e.btMask)]
+	larger := &e.bt[2*(abs&e.btMask)+1]
+	var commonSmaller, commonLarger int32
+	matchEnd := abs + 9
+	end := int32(len(src))
+	for depth := 0; depth < ultraSearchDepth && cand >= minPos; depth++ {
+		t := cand - e.cur
+		next := e.bt[2*(cand&e.btMask):]
+		l := min(commonSmaller, commonLarger)
+		l += int32(matchLen(src[s+l:], src[t+l:]))
+		if cand+l > matchEnd {
+			matchEnd = cand + l
+		}
+		if collect && l > best {
+			best = l
+			matches = append(matches, ultraMatch{length: min(l, maxMatchLength), offset: uint32(s-t) + 3})
+			if l >= ultraSufficient {
+				break
+			}
+		}
+		if s+l == end {
+			// Cannot compare further, drop the remaining tree.
+			break
+		}
+		if src[t+l] < src[s+l] {
+			e.set(smaller, cand)
+			commonSmaller = l
+			if cand <= minPos {
+				smaller = nil
+				break
+			}
+			smaller = &next[1]
+			cand = next[1]
+		} else {
+			e.set(larger, cand)
+			commonLarger = l
+			if cand <= minPos {
+				larger = nil
+				break
+			}
+			larger = &next[0]
+			cand = next[0]
+		}
+	}
+	if smaller != nil {
+		e.set(smaller, 0)
+	}
+	if larger != nil {
+		e.set(larger, 0)
+	}
+	// Skip positions inside long matches.
+	e.next = max(abs+1, matchEnd-8)
+	return matches
+}
+
+// set will set *p to v and log the old value if needed.
+func (e *ultraEncoder) set(p *int32, v int32) {
+	if e.logUndo {
+		e.undo = append(e.undo, ultraUndo{p: p, old: *p})
+	}
+	*p = v
+}
+
+// ultraOffset returns the actual offset of a sequence offset.
+func ultraOffset(offset uint32, reps [3]uint32, ll0 bool) uint32 {
+	if offset > 3 {
+		return offset - 3
+	}
+	if ll0 {
+		if offset == 3 {
+			return reps[0] - 1
+		}
+		return reps[offset]
+	}
+	return reps[offset-1]
+}
+
+// ultraUpdateReps returns the recent offsets after a match with the sequence offset.
+func ultraUpdateReps(reps [3]uint32, offset uint32, ll0 bool) [3]uint32 {
+	if offset > 3 {
+		return [3]uint32{offset - 3, reps[0], reps[1]}
+	}
+	rep := offset - 1
+	if ll0 {
+		rep++
+	}
+	switch rep {
+	case 0:
+		return reps
+	case 1:
+		return [3]uint32{reps[1], reps[0], reps[2]}
+	case 2:
+		return [3]uint32{reps[2], reps[0], reps[1]}
+	default:
+		return [3]uint32{reps[0] - 1, reps[0], reps[1]}
+	}
+}
+
+// setPrices will calculate prices.
+// If no statistics are available, literal prices are estimated from src
+// and predefined FSE tables are used.
+func (e *ultraEncoder) setPrices(src []byte) {
+	if !e.statsValid {
+		var hist [256]uint32
+		for _, b := range src {
+			hist[b]++
+		}
+		ultraPrices(e.litPrice[:], hist[:], 1, 11)
+		ultraPredefPrices(e.llPrice[:], &fsePredefEnc[tableLiteralLengths])
+		ultraPredefPrices(e.mlPrice[:], &fsePredefEnc[tableMatchLengths])
+		ultraPredefPrices(e.ofPrice[:], &fsePredefEnc[tableOffsets])
+		return
+	}
+	ultraPrices(e.litPrice[:], e.stats.lit[:], 1, 11)
+	e.fsePrices(e.llPrice[:], e.stats.ll[:])
+	e.fsePrices(e.mlPrice[:], e.stats.ml[:])
+	e.fsePrices(e.ofPrice[:], e.stats.of[:])
+}
+
+// ultraPrices will set prices from log2 of the frequencies in hist.
+// Prices are limited to be between minBits and maxBits.
+func ultraPrices(dst []int32, hist []uint32, minBits, maxBits int32) {
+	var total uint32
+	for _, v := range hist {
+		total += v + 1
+	}
+	lt := math.Log2(float64(total))
+	for i, v := range hist {
+		p := int32((lt - math.Log2(float64(v+1))) * (1 << ultraPriceBits))
+		dst[i] = min(max(p, minBits<<ultraPriceBits), maxBits<<ultraPriceBits)
+	}
+}
+
+// ultraPredefPrices will set prices from a predefined table.
+func ultraPredefPrices(dst []int32, enc *fseEncoder) {
+	for i := range dst {
+		if i >= int(enc.symbolLen) {
+			dst[i] = int32(enc.actualTableLog+1) << ultraPriceBits
+			continue
+		}
+		dst[i] = int32(enc.bitCost(uint8(i), ultraPriceBits))
+	}
+}
+
+// fsePrices will set prices from an FSE table built from hist.
+func (e *ultraEncoder) fsePrices(dst []int32, hist []uint32) {
+	enc := &e.fse
+	count := enc.Histogram()
+	clear(count[len(hist):])
+	var total, maxCount int
+	for i, v := range hist {
+		// All symbols must be representable.
+		v++
+		count[i] = v
+		total += int(v)
+		maxCount = max(maxCount, int(v))
+	}
+	enc.HistogramFinished(uint8(len(hist)-1), maxCount)
+	if err := enc.normalizeCount(total); err != nil {
+		// Should not happen, but fall back to estimates.
+		ultraPrices(dst, hist, 0, maxEncTableLog+1)
+		return
+	}
+	for i := range dst {
+		dst[i] = int32(enc.bitCost(uint8(i), ultraPriceBits))
+	}
+}
+
+// updateStats will add the literals and sequences added to blk to the statistics.
+// Previous statistics are given half the weight.
+func (e *ultraEncoder) updateStats(blk *blockEnc, nSeqs, nLits int) {
+	st := &e.stats
+	if e.statsValid {
+		for i := range st.lit {
+			st.lit[i] >>= 1
+		}
+		for i := range st.ll {
+			st.ll[i] >>= 1
+		}
+		for i := range st.ml {
+			st.ml[i] >>= 1
+		}
+		for i := range st.of {
+			st.of[i] >>= 1
+		}
+	} else {
+		*st = ultraStats{}
+	}
+	for _, b := range blk.literals[nLits:] {
+		st.lit[b]++
+	}
+	for _, s := range blk.sequences[nSeqs:] {
+		st.ll[llCode(s.litLen)]++
+		st.ml[mlCode(s.matchLen)]++
+		st.of[ofCode(s.offset)]++
+	}
+}
+
+// EncodeNoHist will encode a block with no history and no following blocks.
+// Most notable difference is that src will not be copied for history and
+// we do not need to check for max match length.
+func (e *ultraEncoder) EncodeNoHist(blk *blockEnc, src []byte) {
+	e.ensureHist(len(src))
+	e.Encode(blk, src)
+}
+
+// Reset will reset and set a dictionary if not nil
+func (e *ultraEncoder) Reset(d *dict, singleBlock bool) {
+	e.resetBase(d, singleBlock)
+	e.next = e.cur
+	e.statsValid = false
+}
@@ -5,7 +5,9 @@
 package zstd
 
 import (
+	"context"
 	"crypto/rand"
+	"encoding/binary"
 	"errors"
 	"fmt"
 	"io"
@@ -27,6 +29,11 @@ type Encoder struct {
 	encoders chan encoder
 	state    encoderState
 	init     sync.Once
+
+	// Encoders that can use dictionaries for EncodeAllDict,
+	// if the Encoder was created without a dictionary.
+	dictEnc  chan encoder
+	dictInit sync.Once
 }
 
 type encoder interface {
@@ -41,7 +48,9 @@ type encoder interface {
 }
 
 type encoderState struct {
+	ctx              context.Context
 	w                io.Writer
+	crc              *xxhash.Digest
 	filling          []byte
 	current          []byte
 	previous         []byte
@@ -56,6 +65,21 @@ type encoderState struct {
 	eofWritten       bool
 	fullFrameWritten bool
 
+	// Jobs, when WithEncoderJobSize is used.
+	job      *encoderJob
+	jobs     []*encoderJob
+	freeJobs []*encoderJob
+
+	// Adaptive level, when WithEncoderAdapt is used.
+	adapt encoderAdapt
+
+	// Rolling hash and bytes since the last synchronization point, when WithEncoderRsyncable is used.
+	rsyncHash uint64
+	rsyncN    int
+
+	// Statistics of the current frame, when WithEncoderStats is used.
+	stats FrameStats
+
 	// This waitgroup indicates an encode is running.
 	wg sync.WaitGroup
 	// This waitgroup indicates we have a block encoding/writing.
@@ -74,6 +98,9 @@ func NewWriter(w io.Writer, opts ...EOption) (*Encoder, error) {
 			return nil, err
 		}
 	}
+	if err := e.o.validate(); err != nil {
+		return nil, err
+	}
 	if w != nil {
 		e.Reset(w)
 	}
@@ -94,9 +121,18 @@ func (e *Encoder) initialize() {
 // Reset will re-initialize the writer and new writes will encode to the supplied writer
 // as a new, independent stream.
 func (e *Encoder) Reset(w io.Writer) {
+	e.ResetContext(context.Background(), w)
+}
+
+// ResetContext is like Reset, but encoding of the stream is stopped when ctx is done.
+// When that happens, no more output is written, and Write, ReadFrom, Flush and Close
+// will return ctx.Err() when the next block is encoded.
+// Cancellation is checked between blocks, so a blocked read or write is not interrupted.
+func (e *Encoder) ResetContext(ctx context.Context, w io.Writer) {
 	s := &e.state
 	s.wg.Wait()
 	s.wWg.Wait()
+	e.resetJobs()
 	if cap(s.filling) == 0 {
 		s.filling = make([]byte, 0, e.o.blockSize)
 	}
@@ -120,15 +156,26 @@ func (e *Encoder) Reset(w io.Writer) {
 	}
 	s.filling = s.filling[:0]
 	s.encoder.Reset(e.o.dict, false)
+	if s.crc == nil {
+		s.crc = xxhash.New()
+	} else {
+		s.crc.Reset()
+	}
+	if e.o.adaptMax != 0 {
+		s.adapt.reset(&e.o, s.encoder)
+	}
 	s.headerWritten = false
 	s.eofWritten = false
 	s.fullFrameWritten = false
+	s.ctx = ctx
 	s.w = w
 	s.err = nil
 	s.nWritten = 0
 	s.nInput = 0
 	s.writeErr = nil
 	s.frameContentSize = 0
+	s.rsyncHash, s.rsyncN = 0, 0
+	s.stats = FrameStats{}
 }
 
 // ResetContentSize will reset and set a content size for the next stream.
@@ -143,12 +190,134 @@ func (e *Encoder) ResetContentSize(w io.Writer, size int64) {
 	}
 }
 
+// ResetWithOptions will apply opts to the options of the encoder and reset it
+// to write a new stream to w, like Reset.
+// Options that are not given keep their current value.
+// Encoders and buffers are kept if they can be used with the new options,
+// so the level, window size and dictionary of a pooled encoder can be changed
+// without allocating all state again.
+// Encoders are kept if the level is unchanged, and a dictionary is used
+// both before and after or not at all.
+// ResetWithOptions must not be called concurrently with other methods, including EncodeAll.
+// If an error is returned, the encoder is unchanged.
+func (e *Encoder) ResetWithOptions(w io.Writer, opts ...EOption) error {
+	o := e.o
+	for _, opt := range opts {
+		if err := opt(&o); err != nil {
+			return err
+		}
+	}
+	if err := o.validate(); err != nil {
+		return err
+	}
+	e.setOptions(o)
+	e.Reset(w)
+	return nil
+}
+
+// ResetWithDict will reset the encoder to write a new stream to w like Reset,
+// and use dict for the following streams and EncodeAll.
+// dict must be in the format accepted by WithEncoderDict.
+// If dict is nil, no dictionary is used.
+// See ResetWithOptions for details.
+func (e *Encoder) ResetWithDict(w io.Writer, dict []byte) error {
+	if dict == nil {
+		return e.ResetWithOptions(w, func(o *encoderOptions) error {
+			o.dict = nil
+			return nil
+		})
+	}
+	return e.ResetWithOptions(w, WithEncoderDict(dict))
+}
+
+// setOptions will wait for the current stream and change the options to n.
+// Encoders and buffers are kept if they can be used with the new options.
+func (e *Encoder) setOptions(n encoderOptions) {
+	s := &e.state
+	s.wg.Wait()
+	s.wWg.Wait()
+	e.resetJobs()
+	o := e.o
+	e.o = n
+
+	reuse := o.canReuse(n)
+	if reuse && e.encoders != nil {
+		e.encoders = reuseEncoders(e.encoders, n)
+	} else {
+		e.encoders = nil
+		e.init = sync.Once{}
+	}
+	if e.dictEnc != nil {
+		od, nd := o, n
+		od.dict, nd.dict = &dict{}, &dict{}
+		if od.canReuse(nd) {
+			e.dictEnc = reuseEncoders(e.dictEnc, nd)
+		} else {
+			e.dictEnc = nil
+			e.dictInit = sync.Once{}
+		}
+	}
+	if reuse && s.encoder != nil {
+		setWindow(s.encoder, n.windowSize)
+	} else {
+		s.encoder = nil
+	}
+	if !reuse || o.windowSize != n.windowSize || o.adaptMin != n.adaptMin || o.adaptMax != n.adaptMax {
+		s.adapt.encoders = nil
+	}
+
+	// Buffers are allocated by ResetContext if dropped.
+	if cap(s.filling) < n.blockSize {
+		s.filling = nil
+	}
+	if cap(s.current) < n.blockSize || cap(s.previous) < n.blockSize {
+		s.current, s.previous = nil, nil
+	}
+	if o.lowMem != n.lowMem {
+		s.writing = nil
+	}
+	if n.jobSize == 0 || n.jobOverlap()+n.jobSize > o.jobOverlap()+o.jobSize || n.blockSize > o.blockSize {
+		s.freeJobs = nil
+	}
+}
+
+// reuseEncoders returns n.concurrent encoders for the options n,
+// reusing the encoders in ch, which must be reusable with n.
+func reuseEncoders(ch chan encoder, n encoderOptions) chan encoder {
+	encoders := make(chan encoder, n.concurrent)
+	for len(ch) > 0 && len(encoders) < n.concurrent {
+		enc := <-ch
+		setWindow(enc, n.windowSize)
+		encoders <- enc
+	}
+	for len(encoders) < n.concurrent {
+		encoders <- n.encoder()
+	}
+	return encoders
+}
+
+// setWindow will change the window size of enc to n.
+func setWindow(enc encoder, n int) {
+	if b, ok := enc.(interface{ base() *fastBase }); ok {
+		b.base().setWindow(n)
+	}
+}
+
 // Write data to the encoder.
 // Input data will be buffered and as the buffer fills up
 // content will be compressed and written to the output.
 // When done writing, use Close to flush the remaining output
 // and write CRC if requested.
 func (e *Encoder) Write(p []byte) (n int, err error) {
+	n, err = e.write(p)
+	if err == nil && e.o.flushOnWrite {
+		err = e.AsyncFlush()
+	}
+	return n, err
+}
+
+// write will add p to the current block and compress full blocks.
+func (e *Encoder) write(p []byte) (n int, err error) {
 	s := &e.state
 	if s.eofWritten {
 		return 0, ErrEncoderClosed
@@ -156,7 +325,7 @@ func (e *Encoder) Write(p []byte) (n int, err error) {
 	for len(p) > 0 {
 		if len(p)+len(s.filling) < e.o.blockSize {
 			if e.o.crc {
-				_, _ = s.encoder.CRC().Write(p)
+				_, _ = s.crc.Write(p)
 			}
 			s.filling = append(s.filling, p...)
 			return n + len(p), nil
@@ -166,7 +335,7 @@ func (e *Encoder) Write(p []byte) (n int, err error) {
 			add = add[:e.o.blockSize-len(s.filling)]
 		}
 		if e.o.crc {
-			_, _ = s.encoder.CRC().Write(add)
+			_, _ = s.crc.Write(add)
 		}
 		s.filling = append(s.filling, add...)
 		p = p[len(add):]
@@ -194,6 +363,10 @@ func (e *Encoder) nextBlock(final bool) error {
 	if s.err != nil {
 		return s.err
 	}
+	if err := s.ctx.Err(); err != nil {
+		s.err = err
+		return err
+	}
 	if len(s.filling) > e.o.blockSize {
 		return fmt.Errorf("block > maxStoreBlockSize")
 	}
@@ -205,8 +378,9 @@ func (e *Encoder) nextBlock(final bool) error {
 			s.eofWritten = true
 			return nil
 		}
-		if final && len(s.filling) > 0 {
-			s.current = e.encodeAll(s.encoder, s.filling, s.current[:0])
+		// encodeAll adds padding for the frame, which is only correct if nothing has been written before.
+		if final && len(s.filling) > 0 && (e.o.pad == 0 || s.nWritten == 0) {
+			s.current, _ = e.encodeAll(s.ctx, s.encoder, e.o.dict, s.filling, s.current[:0])
 			var n2 int
 			n2, s.err = s.w.Write(s.current)
 			if s.err != nil {
@@ -214,6 +388,7 @@ func (e *Encoder) nextBlock(final bool) error {
 			}
 			s.nWritten += int64(n2)
 			s.nInput += int64(len(s.filling))
+			e.frameStats(s.encoder, len(s.filling))
 			s.current = s.current[:0]
 			s.filling = s.filling[:0]
 			s.headerWritten = true
@@ -229,6 +404,7 @@ func (e *Encoder) nextBlock(final bool) error {
 			SingleSegment: false,
 			Checksum:      e.o.crc,
 			DictID:        e.o.dict.ID(),
+			Format:        e.o.format,
 		}
 
 		dst := fh.appendTo(tmp[:0])
@@ -240,12 +416,17 @@ func (e *Encoder) nextBlock(final bool) error {
 			return s.err
 		}
 		s.nWritten += int64(n2)
+		s.stats.Compressed += int64(len(dst))
 	}
 	if s.eofWritten {
 		// Ensure we only write it once.
 		final = false
 	}
 
+	if e.o.jobSize > 0 {
+		return e.nextJob(final)
+	}
+
 	if len(s.filling) == 0 {
 		// Final block, but no data.
 		if final {
@@ -257,6 +438,7 @@ func (e *Encoder) nextBlock(final bool) error {
 			s.wWg.Wait()
 			_, s.err = s.w.Write(blk.output)
 			s.nWritten += int64(len(blk.output))
+			e.blockStats(&blk.stats)
 			s.eofWritten = true
 		}
 		return s.err
@@ -269,9 +451,10 @@ func (e *Encoder) nextBlock(final bool) error {
 		if debugEncoder {
 			println("Adding sync block,", len(src), "bytes, final:", final)
 		}
-		enc := s.encoder
+		enc := e.blockEncoder(src)
 		blk := enc.Block()
 		blk.reset(nil)
+		start := e.adaptNow()
 		enc.Encode(blk, src)
 		blk.last = final
 		if final {
@@ -282,8 +465,11 @@ func (e *Encoder) nextBlock(final bool) error {
 		if s.err != nil {
 			return s.err
 		}
+		compressed := e.adaptNow()
 		_, s.err = s.w.Write(blk.output)
+		e.addAdaptStats(compressed.Sub(start), e.adaptNow().Sub(compressed))
 		s.nWritten += int64(len(blk.output))
+		e.blockStats(&blk.stats)
 		s.filling = s.filling[:0]
 		return s.err
 	}
@@ -295,7 +481,7 @@ func (e *Encoder) nextBlock(final bool) error {
 	if final {
 		s.eofWritten = true
 	}
-	go func(src []byte) {
+	go func(enc encoder, src []byte) {
 		if debugEncoder {
 			println("Adding block,", len(src), "bytes, final:", final)
 		}
@@ -306,9 +492,10 @@ func (e *Encoder) nextBlock(final bool) error {
 			}
 			s.wg.Done()
 		}()
-		enc := s.encoder
 		blk := enc.Block()
+		start := e.adaptNow()
 		enc.Encode(blk, src)
+		compress := e.adaptNow().Sub(start)
 		blk.last = final
 		// Wait for pending writes.
 		s.wWg.Wait()
@@ -316,6 +503,7 @@ func (e *Encoder) nextBlock(final bool) error {
 			s.err = s.writeErr
 			return
 		}
+		e.addAdaptStats(compress+s.adapt.lastCompress, s.adapt.lastWrite)
 		// Transfer encoders from previous write block.
 		blk.swapEncoders(s.writing)
 		// Transfer recent offsets to next.
@@ -330,14 +518,18 @@ func (e *Encoder) nextBlock(final bool) error {
 				}
 				s.wWg.Done()
 			}()
+			start := e.adaptNow()
 			s.writeErr = blk.encode(src, e.o.noEntropy, !e.o.allLitEntropy)
 			if s.writeErr != nil {
 				return
 			}
+			compressed := e.adaptNow()
 			_, s.writeErr = s.w.Write(blk.output)
+			s.adapt.lastCompress, s.adapt.lastWrite = compressed.Sub(start), e.adaptNow().Sub(compressed)
 			s.nWritten += int64(len(blk.output))
+			e.blockStats(&blk.stats)
 		}()
-	}(s.current)
+	}(e.blockEncoder(s.current), s.current)
 	return nil
 }
 
@@ -362,7 +554,7 @@ func (e *Encoder) ReadFrom(r io.Reader) (n int64, err error) {
 	for {
 		n2, err := r.Read(src)
 		if e.o.crc {
-			_, _ = e.state.encoder.CRC().Write(src[:n2])
+			_, _ = e.state.crc.Write(src[:n2])
 		}
 		// src is now the unfilled part...
 		src = src[n2:]
@@ -412,6 +604,11 @@ func (e *Encoder) Flush() error {
 			return err
 		}
 	}
+	if e.o.jobSize > 0 {
+		if err := e.flushJobs(true); err != nil && !errors.Is(err, ErrEncoderClosed) {
+			return err
+		}
+	}
 	s.wg.Wait()
 	s.wWg.Wait()
 	if s.err != nil {
@@ -424,6 +621,33 @@ func (e *Encoder) Flush() error {
 	return s.writeErr
 }
 
+// AsyncFlush will start compressing the currently written data as a block,
+// without waiting for the output to be written like Flush does.
+// The output is written in the background, while more data can be written.
+// Use Flush to wait for the output to be written.
+// Errors when compressing or writing the block are returned by later calls.
+// With WithEncoderConcurrency(1) the output is written before AsyncFlush returns.
+// With WithEncoderJobSize, the current job is started, and finished jobs are
+// written by this and later calls.
+func (e *Encoder) AsyncFlush() error {
+	s := &e.state
+	if len(s.filling) > 0 {
+		if err := e.nextBlock(false); err != nil {
+			// Ignore AsyncFlush after Close.
+			if errors.Is(s.err, ErrEncoderClosed) {
+				return nil
+			}
+			return err
+		}
+	}
+	if e.o.jobSize > 0 {
+		if err := e.flushJobs(false); err != nil && !errors.Is(err, ErrEncoderClosed) {
+			return err
+		}
+	}
+	return nil
+}
+
 // Close will flush the final output and close the stream.
 // The function will block until everything has been written.
 // The Encoder can still be re-used after calling this.
@@ -439,6 +663,36 @@ func (e *Encoder) Close() error {
 		}
 		return err
 	}
+	if err := e.endFrame(); err != nil {
+		return err
+	}
+
+	// Add padding with content from crypto/rand.Reader
+	// Output written by encodeAll may already be padded.
+	if s.err == nil && e.o.pad > 0 {
+		if add := calcSkippableFrame(s.nWritten, int64(e.o.pad)); add > 0 {
+			frame, err := skippableFrame(s.filling[:0], add, rand.Reader)
+			if err != nil {
+				return err
+			}
+			_, s.err = s.w.Write(frame)
+		}
+	}
+	if s.fullFrameWritten {
+		return s.err
+	}
+	if s.err == nil {
+		s.err = ErrEncoderClosed
+		return nil
+	}
+
+	return s.err
+}
+
+// endFrame will wait for the final block of the current frame to be written
+// and write the checksum.
+func (e *Encoder) endFrame() error {
+	s := &e.state
 	if s.frameContentSize > 0 {
 		if s.nInput != s.frameContentSize {
 			return fmt.Errorf("frame content size %d given, but %d bytes was written", s.frameContentSize, s.nInput)
@@ -461,25 +715,64 @@ func (e *Encoder) Close() error {
 	if e.o.crc && s.err == nil {
 		// heap alloc.
 		var tmp [4]byte
-		_, s.err = s.w.Write(s.encoder.AppendCRC(tmp[:0]))
+		binary.LittleEndian.PutUint32(tmp[:], uint32(s.crc.Sum64()))
+		_, s.err = s.w.Write(tmp[:])
 		s.nWritten += 4
+		s.stats.Compressed += 4
 	}
+	if s.err == nil && e.o.stats != nil {
+		s.stats.Uncompressed = s.nInput
+		e.o.stats(&s.stats)
+	}
+	return s.err
+}
 
-	// Add padding with content from crypto/rand.Reader
-	if s.err == nil && e.o.pad > 0 {
-		add := calcSkippableFrame(s.nWritten, int64(e.o.pad))
-		frame, err := skippableFrame(s.filling[:0], add, rand.Reader)
-		if err != nil {
+// WriteSkippableFrame will write a skippable frame with the given id and data to the stream.
+// The id must be less than 16 and is stored in the magic number of the frame.
+// If data has been written to the stream, the current frame is ended first,
+// and data written after this will start a new frame.
+// A content size given to ResetContentSize only applies to the first frame.
+// Skippable frames are ignored by decoders, but can be read with WithDecoderSkippableCB.
+// Skippable frames cannot be written with formats other than FormatZstd1.
+func (e *Encoder) WriteSkippableFrame(id uint8, data []byte) error {
+	s := &e.state
+	switch {
+	case e.o.format != FormatZstd1:
+		return errors.New("skippable frames cannot be written without frame magic")
+	case id > 15:
+		return fmt.Errorf("skippable frame id %d must be less than 16", id)
+	case int64(len(data)) > math.MaxUint32:
+		return fmt.Errorf("skippable frame size %d exceeds maximum", len(data))
+	case s.eofWritten:
+		return ErrEncoderClosed
+	case s.err != nil:
+		return s.err
+	}
+	if s.headerWritten || len(s.filling) > 0 {
+		if err := e.nextBlock(true); err != nil {
+			return err
+		}
+		if err := e.endFrame(); err != nil {
 			return err
 		}
-		_, s.err = s.w.Write(frame)
+		// Start a new frame, keeping the size written for padding.
+		nWritten := s.nWritten
+		e.ResetContext(s.ctx, s.w)
+		s.nWritten = nWritten
 	}
-	if s.err == nil {
-		s.err = ErrEncoderClosed
-		return nil
+	var hdr [skippableFrameHeader]byte
+	hdr[0] = 0x50 | id
+	copy(hdr[1:4], skippableFrameMagic)
+	binary.LittleEndian.PutUint32(hdr[4:], uint32(len(data)))
+	for _, b := range [][]byte{hdr[:], data} {
+		n, err := s.w.Write(b)
+		s.nWritten += int64(n)
+		if err != nil {
+			s.err = err
+			return err
+		}
 	}
-
-	return s.err
+	return nil
 }
 
 // EncodeAll will encode all input in src and append it to dst.
@@ -494,7 +787,177 @@ func (e *Encoder) EncodeAll(src, dst []byte) []byte {
 	defer func() {
 		e.encoders <- enc
 	}()
-	return e.encodeAll(enc, src, dst)
+	dst, _ = e.encodeAll(context.Background(), enc, e.o.dict, src, dst)
+	e.frameStats(enc, len(src))
+	return dst
+}
+
+// EncodeAllContext is like EncodeAll, but stops encoding and returns ctx.Err() when ctx is done.
+// Cancellation is checked while waiting for an encoder and between blocks.
+// If an error is returned, dst is returned unmodified.
+// This function can be called concurrently.
+func (e *Encoder) EncodeAllContext(ctx context.Context, src, dst []byte) ([]byte, error) {
+	e.init.Do(e.initialize)
+	var enc encoder
+	select {
+	case <-ctx.Done():
+		return dst, ctx.Err()
+	case enc = <-e.encoders:
+	}
+	defer func() {
+		e.encoders <- enc
+	}()
+	dst, err := e.encodeAll(ctx, enc, e.o.dict, src, dst)
+	if err == nil {
+		e.frameStats(enc, len(src))
+	}
+	return dst, err
+}
+
+// EncodeAllMaxCompressed will encode a prefix of src as a single frame
+// of at most maxSize bytes and append it to dst.
+// The number of bytes of src that was encoded is returned.
+// This can be used to split input into independently decodable frames that each fit
+// in a datagram. Unlike targetCBlockSize in the reference implementation, maxSize is a hard limit:
+//
+//	for len(src) > 0 {
+//		frame, n, err := enc.EncodeAllMaxCompressed(src, buf[:0], 1200)
+//		...
+//		src = src[n:]
+//	}
+//
+// Blocks are added to the frame while they fit.
+// The block that does not fit is cut after the most sequences and literals
+// that fit in the remaining space and ends the frame.
+// Only the entropy coding of that block is repeated, so the cost is close to EncodeAll.
+// Padding is not added.
+// An error is returned if not even a single byte of input can be encoded in maxSize bytes.
+// This function can be called concurrently.
+func (e *Encoder) EncodeAllMaxCompressed(src, dst []byte, maxSize int) ([]byte, int, error) {
+	if len(src) == 0 {
+		return dst, 0, nil
+	}
+	e.init.Do(e.initialize)
+	enc := <-e.encoders
+	defer func() {
+		e.encoders <- enc
+	}()
+	d := e.o.dict
+	header := func(n int) frameHeader {
+		single := n <= e.o.windowSize && n > MinWindowSize
+		if e.o.single != nil {
+			single = *e.o.single
+		}
+		return frameHeader{
+			ContentSize:   uint64(n),
+			WindowSize:    uint32(enc.WindowSize(int64(n))),
+			SingleSegment: single,
+			Checksum:      e.o.crc,
+			DictID:        d.ID(),
+			Format:        e.o.format,
+		}
+	}
+	// The size is not known until the blocks are encoded, so reserve space for the largest header.
+	// Frames below MinWindowSize are not single segment and may have a larger header.
+	hdrSize := max(len(header(len(src)).appendTo(nil)), len(header(min(len(src), MinWindowSize)).appendTo(nil)))
+	limit := maxSize - hdrSize
+	if e.o.crc {
+		limit -= 4
+	}
+	start := len(dst)
+	dst = append(dst, make([]byte, hdrSize)...)
+
+	enc.Reset(d, false)
+	blk := enc.Block()
+	// Start with blocks that are not likely to fit,
+	// and grow them while they do, so input that compresses well needs few blocks.
+	size := min(e.o.blockSize, max(limit*8, 1024))
+	n, lastBlock := 0, -1
+	for n < len(src) {
+		todo := src[n:min(n+size, len(src))]
+		blk.pushOffsets()
+		enc.Encode(blk, todo)
+		blk.last = n+len(todo) == len(src)
+		done, err := blk.encodeLimit(todo, limit, e.o.noEntropy, !e.o.allLitEntropy)
+		if err != nil {
+			return dst[:start], 0, err
+		}
+		if done == 0 {
+			if lastBlock < 0 {
+				return dst[:start], 0, fmt.Errorf("maximum compressed size %d is too small", maxSize)
+			}
+			// Mark the previous block as the last.
+			dst[lastBlock] |= 1
+			break
+		}
+		lastBlock = len(dst)
+		dst = append(dst, blk.output...)
+		limit -= len(blk.output)
+		n += done
+		blk.reset(nil)
+		if done < len(todo) {
+			break
+		}
+		size = min(size*2, e.o.blockSize)
+	}
+
+	hdr := header(n).appendTo(dst[:start])
+	dst = dst[:len(hdr)+copy(dst[len(hdr):], dst[start+hdrSize:])]
+	if e.o.crc {
+		_, _ = enc.CRC().Write(src[:n])
+		dst = enc.AppendCRC(dst)
+	}
+	// Blocks are already counted, so this adds the frame header and checksum.
+	blk.stats.Compressed = int64(len(dst) - start)
+	e.frameStats(enc, n)
+	return dst, n, nil
+}
+
+// EncodeAllDict will encode all input in src and append it to dst, using d as dictionary.
+// d replaces any dictionary set on the encoder for this call.
+// If d is nil, no dictionary is used.
+// This allows a single Encoder to be used with different dictionaries,
+// for example selected per message type.
+// If the Encoder was created without a dictionary,
+// a separate set of encoders that can use dictionaries is created on first use.
+// This function can be called concurrently.
+func (e *Encoder) EncodeAllDict(d *Dict, src, dst []byte) []byte {
+	if d == nil {
+		e.init.Do(e.initialize)
+		enc := <-e.encoders
+		defer func() {
+			e.encoders <- enc
+		}()
+		dst, _ = e.encodeAll(context.Background(), enc, nil, src, dst)
+		e.frameStats(enc, len(src))
+		return dst
+	}
+	encoders := e.dictEncoders()
+	enc := <-encoders
+	defer func() {
+		encoders <- enc
+	}()
+	dst, _ = e.encodeAll(context.Background(), enc, d.d, src, dst)
+	e.frameStats(enc, len(src))
+	return dst
+}
+
+// dictEncoders returns encoders that can be used with dictionaries.
+func (e *Encoder) dictEncoders() chan encoder {
+	e.init.Do(e.initialize)
+	if e.o.dict != nil {
+		return e.encoders
+	}
+	e.dictInit.Do(func() {
+		// Create encoders as if a dictionary was given.
+		o := e.o
+		o.dict = &dict{}
+		e.dictEnc = make(chan encoder, o.concurrent)
+		for i := 0; i < o.concurrent; i++ {
+			e.dictEnc <- o.encoder()
+		}
+	})
+	return e.dictEnc
 }
 
 // EncodeAllParts will encode the concatenation of all src parts and append it
@@ -509,10 +972,152 @@ func (e *Encoder) EncodeAllParts(src [][]byte, dst []byte) []byte {
 	defer func() {
 		e.encoders <- enc
 	}()
-	return e.encodeAllParts(enc, src, dst)
+	dst = e.encodeAllParts(enc, src, dst)
+	if e.o.stats != nil {
+		n := 0
+		for _, p := range src {
+			n += len(p)
+		}
+		e.frameStats(enc, n)
+	}
+	return dst
 }
 
-func (e *Encoder) encodeAll(enc encoder, src, dst []byte) []byte {
+// EncodeAllWithPrefix will encode all input in src and append it to dst,
+// using prefix as history that matches can reference.
+// This can be used to compress a new version of a file against an old version,
+// without building a dictionary.
+// This is equivalent to ZSTD_CCtx_refPrefix and "zstd --patch-from" in the reference implementation.
+// Only the last window size bytes of the prefix can be referenced.
+// To use bigger prefixes, increase the window size with WithWindowSize.
+// The prefix is indexed on every call, so the cost is similar to compressing the prefix.
+// The prefix replaces any dictionary set on the encoder, and no dictionary ID is written.
+// The output must be decoded with Decoder.DecodeAllWithPrefix using the same prefix.
+// This function can be called concurrently.
+func (e *Encoder) EncodeAllWithPrefix(prefix, src, dst []byte) []byte {
+	if len(prefix) == 0 {
+		return e.EncodeAll(src, dst)
+	}
+	e.init.Do(e.initialize)
+	enc := <-e.encoders
+	defer func() {
+		e.encoders <- enc
+	}()
+	dst = e.encodeAllPrefix(enc, prefix, src, dst)
+	e.frameStats(enc, len(src))
+	return dst
+}
+
+func (e *Encoder) encodeAllPrefix(enc encoder, prefix, src, dst []byte) []byte {
+	if len(src) == 0 {
+		dst, _ = e.encodeAll(context.Background(), enc, e.o.dict, src, dst)
+		return dst
+	}
+	if len(prefix) > e.o.windowSize {
+		prefix = prefix[len(prefix)-e.o.windowSize:]
+	}
+	// The window must include the prefix, so single segment cannot be used.
+	fh := frameHeader{
+		ContentSize:   uint64(len(src)),
+		WindowSize:    uint32(enc.WindowSize(int64(len(prefix) + len(src)))),
+		SingleSegment: false,
+		Checksum:      e.o.crc,
+		DictID:        0,
+		Format:        e.o.format,
+	}
+	start := len(dst)
+	if len(dst) == 0 && cap(dst) == 0 && len(src) < 1<<20 && !e.o.lowMem {
+		dst = make([]byte, 0, len(src))
+	}
+	dst = fh.appendTo(dst)
+
+	enc.Reset(nil, false)
+	blk := enc.Block()
+	e.addHistory(enc, blk, prefix)
+	for len(src) > 0 {
+		todo := src
+		if len(todo) > e.o.blockSize {
+			todo = todo[:e.o.blockSize]
+		}
+		src = src[len(todo):]
+		if e.o.crc {
+			_, _ = enc.CRC().Write(todo)
+		}
+		blk.pushOffsets()
+		enc.Encode(blk, todo)
+		if len(src) == 0 {
+			blk.last = true
+		}
+		err := blk.encode(todo, e.o.noEntropy, !e.o.allLitEntropy)
+		if err != nil {
+			panic(err)
+		}
+		dst = append(dst, blk.output...)
+		blk.reset(nil)
+	}
+	if e.o.crc {
+		dst = enc.AppendCRC(dst)
+	}
+	// Blocks are already counted, so this adds the frame header and checksum.
+	blk.stats.Compressed = int64(len(dst) - start)
+	// Add padding with content from crypto/rand.Reader
+	if e.o.pad > 0 {
+		add := calcSkippableFrame(int64(len(dst)), int64(e.o.pad))
+		var err error
+		dst, err = skippableFrame(dst, add, rand.Reader)
+		if err != nil {
+			panic(err)
+		}
+	}
+	return dst
+}
+
+// addHistory will add hist to the history of enc
+// by compressing it to blk and discarding the output.
+// Block state is reset, so the next block can be encoded without
+// knowing the state of the blocks before it.
+// Encoders will not use repeat offsets until they have been set in the block.
+func (e *Encoder) addHistory(enc encoder, blk *blockEnc, hist []byte) {
+	for len(hist) > 0 {
+		todo := hist[:min(len(hist), e.o.blockSize)]
+		hist = hist[len(todo):]
+		enc.Encode(blk, todo)
+		blk.reset(nil)
+	}
+	blk.initNewEncode()
+}
+
+// frameStats will send the statistics of the frame encoded by enc
+// to the function set with WithEncoderStats.
+// The statistics are cleared, so nothing is sent if no frame was encoded.
+func (e *Encoder) frameStats(enc encoder, uncompressed int) {
+	blk := enc.Block()
+	if blk == nil {
+		return
+	}
+	s := blk.stats
+	blk.stats = FrameStats{}
+	if e.o.stats != nil && s.Compressed > 0 {
+		s.Uncompressed = int64(uncompressed)
+		e.o.stats(&s)
+	}
+}
+
+// blockStats will move the statistics of encoded blocks in s to the current stream.
+func (e *Encoder) blockStats(s *FrameStats) {
+	if e.o.stats != nil {
+		e.state.stats.add(s)
+	}
+	*s = FrameStats{}
+}
+
+// encodeAll will encode src as a single frame using d as dictionary.
+// An error is only returned if ctx is done, in which case dst is returned unmodified.
+func (e *Encoder) encodeAll(ctx context.Context, enc encoder, d *dict, src, dst []byte) ([]byte, error) {
+	if err := ctx.Err(); err != nil {
+		return dst, err
+	}
+	dstIn := dst
 	if len(src) == 0 {
 		if e.o.fullZero {
 			// Add frame header.
@@ -523,6 +1128,7 @@ func (e *Encoder) encodeAll(enc encoder, src, dst []byte) []byte {
 				// Adding a checksum would be a waste of space.
 				Checksum: false,
 				DictID:   0,
+				Format:   e.o.format,
 			}
 			dst = fh.appendTo(dst)
 
@@ -532,8 +1138,11 @@ func (e *Encoder) encodeAll(enc encoder, src, dst []byte) []byte {
 			blk.setType(blockTypeRaw)
 			blk.setLast(true)
 			dst = blk.appendTo(dst)
+			if e.o.stats != nil {
+				e.o.stats(&FrameStats{Compressed: int64(len(dst) - len(dstIn)), RawBlocks: 1})
+			}
 		}
-		return dst
+		return dst, nil
 	}
 
 	// Use single segments when above minimum window and below window size.
@@ -546,7 +1155,8 @@ func (e *Encoder) encodeAll(enc encoder, src, dst []byte) []byte {
 		WindowSize:    uint32(enc.WindowSize(int64(len(src)))),
 		SingleSegment: single,
 		Checksum:      e.o.crc,
-		DictID:        e.o.dict.ID(),
+		DictID:        d.ID(),
+		Format:        e.o.format,
 	}
 
 	// If less than 1MB, allocate a buffer up front.
@@ -557,14 +1167,15 @@ func (e *Encoder) encodeAll(enc encoder, src, dst []byte) []byte {
 
 	// If we can do everything in one block, prefer that.
 	if len(src) <= e.o.blockSize {
-		enc.Reset(e.o.dict, true)
+		enc.Reset(d, true)
 		// Slightly faster with no history and everything in one block.
 		if e.o.crc {
 			_, _ = enc.CRC().Write(src)
 		}
 		blk := enc.Block()
 		blk.last = true
-		if e.o.dict == nil {
+		// EncodeNoHist of encoders created for dictionaries may use a faster level.
+		if d == nil && e.o.dict == nil {
 			enc.EncodeNoHist(blk, src)
 		} else {
 			enc.Encode(blk, src)
@@ -583,7 +1194,7 @@ func (e *Encoder) encodeAll(enc encoder, src, dst []byte) []byte {
 		dst = blk.output
 		blk.output = oldout
 	} else {
-		enc.Reset(e.o.dict, false)
+		enc.Reset(d, false)
 		blk := enc.Block()
 		for len(src) > 0 {
 			todo := src
@@ -591,6 +1202,10 @@ func (e *Encoder) encodeAll(enc encoder, src, dst []byte) []byte {
 				todo = todo[:e.o.blockSize]
 			}
 			src = src[len(todo):]
+			if err := ctx.Err(); err != nil {
+				blk.stats = FrameStats{}
+				return dstIn, err
+			}
 			if e.o.crc {
 				_, _ = enc.CRC().Write(todo)
 			}
@@ -610,6 +1225,8 @@ func (e *Encoder) encodeAll(enc encoder, src, dst []byte) []byte {
 	if e.o.crc {
 		dst = enc.AppendCRC(dst)
 	}
+	// Blocks are already counted, so this adds the frame header and checksum.
+	enc.Block().stats.Compressed = int64(len(dst) - len(dstIn))
 	// Add padding with content from crypto/rand.Reader
 	if e.o.pad > 0 {
 		add := calcSkippableFrame(int64(len(dst)), int64(e.o.pad))
@@ -619,10 +1236,11 @@ func (e *Encoder) encodeAll(enc encoder, src, dst []byte) []byte {
 			panic(err)
 		}
 	}
-	return dst
+	return dst, nil
 }
 
 func (e *Encoder) encodeAllParts(enc encoder, src [][]byte, dst []byte) []byte {
+	start := len(dst)
 	if len(src) == 0 {
 		if e.o.fullZero {
 			// Add frame header.
@@ -633,6 +1251,7 @@ func (e *Encoder) encodeAllParts(enc encoder, src [][]byte, dst []byte) []byte {
 				// Adding a checksum would be a waste of space.
 				Checksum: false,
 				DictID:   0,
+				Format:   e.o.format,
 			}
 			dst = fh.appendTo(dst)
 
@@ -642,6 +1261,9 @@ func (e *Encoder) encodeAllParts(enc encoder, src [][]byte, dst []byte) []byte {
 			blk.setType(blockTypeRaw)
 			blk.setLast(true)
 			dst = blk.appendTo(dst)
+			if e.o.stats != nil {
+				e.o.stats(&FrameStats{Compressed: int64(len(dst) - start), RawBlocks: 1})
+			}
 		}
 		return dst
 	}
@@ -664,6 +1286,7 @@ func (e *Encoder) encodeAllParts(enc encoder, src [][]byte, dst []byte) []byte {
 				// Adding a checksum would be a waste of space.
 				Checksum: false,
 				DictID:   0,
+				Format:   e.o.format,
 			}
 			dst = fh.appendTo(dst)
 
@@ -673,6 +1296,9 @@ func (e *Encoder) encodeAllParts(enc encoder, src [][]byte, dst []byte) []byte {
 			blk.setType(blockTypeRaw)
 			blk.setLast(true)
 			dst = blk.appendTo(dst)
+			if e.o.stats != nil {
+				e.o.stats(&FrameStats{Compressed: int64(len(dst) - start), RawBlocks: 1})
+			}
 		}
 		return dst
 	}
@@ -688,6 +1314,7 @@ func (e *Encoder) encodeAllParts(enc encoder, src [][]byte, dst []byte) []byte {
 		SingleSegment: single,
 		Checksum:      e.o.crc,
 		DictID:        e.o.dict.ID(),
+		Format:        e.o.format,
 	}
 
 	// If less than 1MB, allocate a buffer up front.
@@ -737,6 +1364,8 @@ func (e *Encoder) encodeAllParts(enc encoder, src [][]byte, dst []byte) []byte {
 	if e.o.crc {
 		dst = enc.AppendCRC(dst)
 	}
+	// Blocks are already counted, so this adds the frame header and checksum.
+	blk.stats.Compressed = int64(len(dst) - start)
 	// Add padding with content from crypto/rand.Reader
 	if e.o.pad > 0 {
 		add := calcSkippableFrame(int64(len(dst)), int64(e.o.pad))
@@ -0,0 +1,138 @@
+// Copyright 2025+ Klaus Post. All rights reserved.
+// License information can be found in the LICENSE file.
+
+package zstd
+
+import (
+	"time"
+)
+
+const (
+	// adaptBlocks is the minimum number of blocks between level changes.
+	adaptBlocks = 4
+
+	// adaptHistory is the amount of recent input an encoder is given
+	// when switching level, so matches can reference it.
+	adaptHistory = 256 << 10
+)
+
+// encoderAdapt contains the state of the adaptive compression level
+// when WithEncoderAdapt is used.
+type encoderAdapt struct {
+	// Encoders for each level from the minimum level, created when first used.
+	encoders []encoder
+	level    EncoderLevel
+	enc      encoder
+
+	// Most recent input.
+	// fromStart is set while hist starts at the start of the frame.
+	hist      []byte
+	fromStart bool
+
+	// now returns the current time. Replaced in tests.
+	now func() time.Time
+
+	// Time spent compressing and writing since the last level change.
+	compress, write time.Duration
+	blocks          int
+
+	// Time spent by the last asynchronous write.
+	// Only accessed by the write and the following block encode.
+	lastCompress, lastWrite time.Duration
+}
+
+// reset the adaptive state for a new stream, starting with enc at the encoder level.
+func (a *encoderAdapt) reset(o *encoderOptions, enc encoder) {
+	if a.encoders == nil {
+		a.encoders = make([]encoder, o.adaptMax-o.adaptMin+1)
+	}
+	a.level = o.level
+	a.enc = enc
+	a.encoders[a.level-o.adaptMin] = enc
+	a.hist = a.hist[:0]
+	a.fromStart = true
+	if a.now == nil {
+		a.now = time.Now
+	}
+	a.compress, a.write, a.blocks = 0, 0, 0
+	a.lastCompress, a.lastWrite = 0, 0
+}
+
+// blockEncoder returns the encoder to use for the next block of the stream
+// and adds src to the history.
+// If enough blocks have been written since the last change, the level is updated with nextLevel.
+// When the level changes, the encoder for the new level is given the most recent input as history.
+func (e *Encoder) blockEncoder(src []byte) encoder {
+	s := &e.state
+	if e.o.adaptMax == 0 {
+		return s.encoder
+	}
+	a := &s.adapt
+	if a.blocks >= adaptBlocks {
+		level := a.nextLevel(e.o.adaptMin, e.o.adaptMax)
+		a.compress, a.write, a.blocks = 0, 0, 0
+		if level != a.level {
+			if debugEncoder {
+				println("adapt: switching level from", a.level.String(), "to", level.String())
+			}
+			a.level = level
+			a.enc = a.encoders[level-e.o.adaptMin]
+			if a.enc == nil {
+				o := e.o
+				o.level = level
+				a.enc = o.encoder()
+				a.encoders[level-e.o.adaptMin] = a.enc
+			}
+			// The dictionary precedes the frame, so it can only be placed before the history
+			// if the history starts at the start of the frame.
+			hist := a.hist[max(len(a.hist)-e.o.windowSize, 0):]
+			if a.fromStart && len(hist) == len(a.hist) {
+				a.enc.Reset(e.o.dict, false)
+			} else {
+				a.enc.Reset(nil, false)
+			}
+			e.addHistory(a.enc, a.enc.Block(), hist)
+		}
+	}
+	if len(a.hist)+len(src) > adaptHistory {
+		keep := min(len(a.hist), max(adaptHistory-len(src), 0))
+		a.hist = append(a.hist[:0], a.hist[len(a.hist)-keep:]...)
+		src = src[max(len(src)-adaptHistory, 0):]
+		a.fromStart = false
+	}
+	a.hist = append(a.hist, src...)
+	return a.enc
+}
+
+// nextLevel returns the level to use from the time spent since the last level change.
+// The level is increased if writing takes longer than compressing,
+// and decreased if writing takes less than a quarter of the time.
+func (a *encoderAdapt) nextLevel(minLevel, maxLevel EncoderLevel) EncoderLevel {
+	switch {
+	case a.write > a.compress && a.level < maxLevel:
+		return a.level + 1
+	case a.write*4 < a.compress && a.level > minLevel:
+		return a.level - 1
+	}
+	return a.level
+}
+
+// adaptNow returns the current time for measuring adaptive compression.
+// The zero time is returned if the level is not adaptive.
+func (e *Encoder) adaptNow() time.Time {
+	if e.o.adaptMax == 0 {
+		return time.Time{}
+	}
+	return e.state.adapt.now()
+}
+
+// addAdaptStats adds the time spent compressing and writing a block.
+func (e *Encoder) addAdaptStats(compress, write time.Duration) {
+	if e.o.adaptMax == 0 {
+		return
+	}
+	a := &e.state.adapt
+	a.compress += compress
+	a.write += write
+	a.blocks++
+}
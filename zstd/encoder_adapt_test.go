@@ -0,0 +1,127 @@
+package zstd
+
+import (
+	"bytes"
+	"io"
+	"math/rand"
+	"testing"
+	"time"
+)
+
+// clockWriter is a writer that advances a fake clock on every write,
+// so writing appears slow compared to compressing.
+type clockWriter struct {
+	bytes.Buffer
+	now *time.Time
+}
+
+func (w *clockWriter) Write(p []byte) (int, error) {
+	*w.now = w.now.Add(time.Second)
+	return w.Buffer.Write(p)
+}
+
+func TestEncoderAdaptLevel(t *testing.T) {
+	const ms = time.Millisecond
+	for i, test := range []struct {
+		level, minLevel, maxLevel EncoderLevel
+		compress, write           time.Duration
+		want                      EncoderLevel
+	}{
+		{level: SpeedDefault, minLevel: SpeedFastest, maxLevel: SpeedBestCompression, compress: ms, write: 2 * ms, want: SpeedBetterCompression},
+		{level: SpeedBestCompression, minLevel: SpeedFastest, maxLevel: SpeedBestCompression, compress: ms, write: 2 * ms, want: SpeedBestCompression},
+		{level: SpeedDefault, minLevel: SpeedFastest, maxLevel: SpeedBestCompression, compress: 5 * ms, write: ms, want: SpeedFastest},
+		{level: SpeedDefault, minLevel: SpeedDefault, maxLevel: SpeedBestCompression, compress: 5 * ms, write: ms, want: SpeedDefault},
+		{level: SpeedDefault, minLevel: SpeedFastest, maxLevel: SpeedBestCompression, compress: 2 * ms, write: ms, want: SpeedDefault},
+		{level: SpeedDefault, minLevel: SpeedFastest, maxLevel: SpeedBestCompression, compress: ms, write: ms, want: SpeedDefault},
+	} {
+		a := encoderAdapt{level: test.level, compress: test.compress, write: test.write}
+		if got := a.nextLevel(test.minLevel, test.maxLevel); got != test.want {
+			t.Errorf("%d: got level %v, want %v", i, got, test.want)
+		}
+	}
+
+	// The level is only changed after adaptBlocks blocks.
+	e, err := NewWriter(nil, WithEncoderAdapt(SpeedFastest, SpeedBestCompression), WithEncoderLevel(SpeedFastest), WithEncoderConcurrency(1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer e.Close()
+	e.Reset(io.Discard)
+	src := make([]byte, 1000)
+	want := SpeedFastest
+	for i := range 3 * adaptBlocks {
+		if i > 0 && i%adaptBlocks == 0 {
+			want++
+		}
+		e.blockEncoder(src)
+		if got := e.state.adapt.level; got != want {
+			t.Fatalf("block %d: got level %v, want %v", i, got, want)
+		}
+		e.addAdaptStats(time.Millisecond, 2*time.Millisecond)
+	}
+	if got := len(e.state.adapt.hist); got != 3*adaptBlocks*len(src) {
+		t.Errorf("got %d bytes of history, want %d", got, 3*adaptBlocks*len(src))
+	}
+}
+
+func TestEncoderAdapt(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	words := make([][]byte, 1000)
+	for i := range words {
+		w := make([]byte, 2+rng.Intn(10))
+		for j := range w {
+			w[j] = byte(rng.Intn(26)) + 'a'
+		}
+		words[i] = append(w, ' ')
+	}
+	var in []byte
+	for len(in) < 512<<10 {
+		in = append(in, words[rng.Intn(len(words))]...)
+	}
+	dict := in[:10000]
+	dec, err := NewReader(nil, WithDecoderDictRaw(1234, dict))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer dec.Close()
+
+	// Every block is written separately, so the level is increased every adaptBlocks writes,
+	// and the first level change is given the dictionary.
+	e, err := NewWriter(nil, WithEncoderAdapt(SpeedFastest, SpeedBestCompression), WithEncoderLevel(SpeedFastest),
+		WithEncoderConcurrency(1), WithEncoderFlushOnWrite(true), WithEncoderDictRaw(1234, dict))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer e.Close()
+	var now time.Time
+	e.state.adapt.now = func() time.Time { return now }
+	w := &clockWriter{now: &now}
+	e.Reset(w)
+	for rem := in; len(rem) > 0; {
+		n := min(len(rem), 8<<10)
+		if _, err := e.Write(rem[:n]); err != nil {
+			t.Fatal(err)
+		}
+		rem = rem[n:]
+	}
+	if err := e.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if got := e.state.adapt.level; got != SpeedBestCompression {
+		t.Errorf("slow output: level %v, want %v", got, SpeedBestCompression)
+	}
+	got, err := dec.DecodeAll(w.Bytes(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, in) {
+		t.Fatal("decoded mismatch")
+	}
+
+	if _, err := NewWriter(nil, WithEncoderAdapt(SpeedBestCompression, SpeedFastest)); err == nil {
+		t.Error("want error for min > max")
+	}
+	if _, err := NewWriter(nil, WithEncoderAdapt(SpeedFastest, SpeedBestCompression), WithEncoderJobSize(1<<20)); err == nil {
+		t.Error("want error with jobs")
+	}
+}
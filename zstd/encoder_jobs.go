@@ -0,0 +1,282 @@
+// Copyright 2025+ Klaus Post. All rights reserved.
+// License information can be found in the LICENSE file.
+// Based on work by Yann Collet, released under BSD License.
+
+package zstd
+
+import (
+	"context"
+	"fmt"
+	"math/bits"
+	rdebug "runtime/debug"
+)
+
+// encoderJob is a part of a stream that is compressed independently.
+// Jobs are compressed concurrently and written in order as part of the same frame.
+type encoderJob struct {
+	// data contains the history followed by the input of the job.
+	data []byte
+	hist int
+
+	// offset of data in the frame.
+	offset int64
+
+	// sync is set if the job starts at a synchronization point.
+	// The job is compressed without history or dictionary.
+	sync bool
+
+	final bool
+	ctx   context.Context
+	out   []byte
+	stats FrameStats
+	err   error
+	done  chan struct{}
+}
+
+// nextJob adds the input in e.state.filling to the current job.
+// If the job is full or final it is started.
+// Finished jobs are written to the output.
+func (e *Encoder) nextJob(final bool) error {
+	s := &e.state
+	if s.job == nil {
+		s.job = e.newJob()
+	}
+	s.nInput += int64(len(s.filling))
+	if e.o.rsyncable {
+		e.addRsyncable(s.filling)
+	} else {
+		s.job.data = append(s.job.data, s.filling...)
+	}
+	s.filling = s.filling[:0]
+	if final {
+		s.eofWritten = true
+		e.startJob(true, false)
+		return e.writeJobs(true)
+	}
+	if j := s.job; len(j.data)-j.hist >= e.o.jobSize {
+		e.startJob(false, false)
+	}
+	return e.writeJobs(false)
+}
+
+const (
+	// rsyncLength is the number of bytes in the rolling hash.
+	rsyncLength = 32
+	// rsyncMinSize is the minimum distance between synchronization points.
+	rsyncMinSize = maxCompressedBlockSize
+	rsyncPrime   = 0xcf1bbcdcb7a56463
+)
+
+// rsyncPrimePower is rsyncPrime^rsyncLength, used for removing bytes from the rolling hash.
+var rsyncPrimePower = func() uint64 {
+	p := uint64(1)
+	for range rsyncLength {
+		p *= rsyncPrime
+	}
+	return p
+}()
+
+// addRsyncable adds src to the current job.
+// Jobs are started at synchronization points found with a rolling hash of the input,
+// or when the job size is reached.
+// Since the positions only depend on the content since the previous synchronization point,
+// a change of the input will only change the output until the next synchronization point.
+func (e *Encoder) addRsyncable(src []byte) {
+	s := &e.state
+	// Synchronization points are on average every job size bytes.
+	shift := 64 - (bits.Len(uint(e.o.jobSize)) - 1)
+	for len(src) > 0 {
+		j := s.job
+		n := min(len(src), e.o.jobSize-(len(j.data)-j.hist))
+		start := len(j.data)
+		j.data = append(j.data, src[:n]...)
+		h, cut := s.rsyncHash, -1
+		for i := start; i < len(j.data); i++ {
+			h = h*rsyncPrime + uint64(j.data[i]) + 1
+			if s.rsyncN >= rsyncLength {
+				// Jobs not starting at synchronization points have enough history.
+				h -= (uint64(j.data[i-rsyncLength]) + 1) * rsyncPrimePower
+			}
+			s.rsyncN++
+			if s.rsyncN >= rsyncMinSize && h>>shift == 0 {
+				cut = i + 1
+				break
+			}
+		}
+		s.rsyncHash = h
+		if cut < 0 {
+			src = src[n:]
+			if len(j.data)-j.hist >= e.o.jobSize {
+				e.startJob(false, false)
+			}
+			continue
+		}
+		src = src[cut-start:]
+		j.data = j.data[:cut]
+		s.rsyncHash, s.rsyncN = 0, 0
+		e.startJob(false, true)
+	}
+}
+
+// flushJobs will start the current job if it has any input
+// and write finished jobs.
+// If wait is true, all pending jobs are written.
+func (e *Encoder) flushJobs(wait bool) error {
+	s := &e.state
+	if j := s.job; j != nil && len(j.data) > j.hist {
+		e.startJob(false, false)
+	}
+	return e.writeJobs(wait)
+}
+
+// newJob returns an empty job.
+func (e *Encoder) newJob() *encoderJob {
+	s := &e.state
+	if n := len(s.freeJobs); n > 0 {
+		j := s.freeJobs[n-1]
+		s.freeJobs = s.freeJobs[:n-1]
+		j.data = j.data[:0]
+		j.hist = 0
+		j.offset = 0
+		j.sync = false
+		j.out = j.out[:0]
+		j.err = nil
+		return j
+	}
+	return &encoderJob{data: make([]byte, 0, e.o.jobOverlap()+e.o.jobSize+e.o.blockSize)}
+}
+
+// startJob will start compressing the current job
+// and set up a new job with the end of the current as history.
+// If sync is set, the new job starts at a synchronization point and is given no history.
+func (e *Encoder) startJob(final, sync bool) {
+	e.init.Do(e.initialize)
+	s := &e.state
+	j := s.job
+	j.final = final
+	j.ctx = s.ctx
+	j.done = make(chan struct{})
+	s.jobs = append(s.jobs, j)
+
+	next := e.newJob()
+	if sync {
+		next.sync = true
+		next.offset = j.offset + int64(len(j.data))
+	} else {
+		ov := min(len(j.data), e.o.jobOverlap())
+		next.data = append(next.data, j.data[len(j.data)-ov:]...)
+		next.hist = ov
+		next.offset = j.offset + int64(len(j.data)-ov)
+	}
+	s.job = next
+
+	go func() {
+		enc := <-e.encoders
+		defer func() {
+			if r := recover(); r != nil {
+				j.err = fmt.Errorf("panic while encoding: %v", r)
+				rdebug.PrintStack()
+			}
+			e.encoders <- enc
+			close(j.done)
+		}()
+		e.encodeJob(enc, j)
+		blk := enc.Block()
+		j.stats, blk.stats = blk.stats, FrameStats{}
+	}()
+}
+
+// writeJobs will write finished jobs in order.
+// If wait is true, all jobs are written.
+// Otherwise, it will only wait if the maximum number of jobs is queued.
+func (e *Encoder) writeJobs(wait bool) error {
+	s := &e.state
+	for len(s.jobs) > 0 {
+		j := s.jobs[0]
+		if !wait && len(s.jobs) < e.o.concurrent {
+			select {
+			case <-j.done:
+			default:
+				return s.err
+			}
+		}
+		<-j.done
+		s.jobs[0] = nil
+		s.jobs = s.jobs[1:]
+		if s.err == nil {
+			s.err = j.err
+		}
+		if s.err == nil {
+			var n int
+			n, s.err = s.w.Write(j.out)
+			s.nWritten += int64(n)
+			e.blockStats(&j.stats)
+		}
+		s.freeJobs = append(s.freeJobs, j)
+	}
+	return s.err
+}
+
+// resetJobs will wait for running jobs and discard all jobs.
+func (e *Encoder) resetJobs() {
+	s := &e.state
+	for i, j := range s.jobs {
+		<-j.done
+		s.freeJobs = append(s.freeJobs, j)
+		s.jobs[i] = nil
+	}
+	s.jobs = s.jobs[:0]
+	if s.job != nil {
+		s.freeJobs = append(s.freeJobs, s.job)
+		s.job = nil
+	}
+}
+
+// encodeJob will compress the input of a job to blocks.
+// The encoder is primed with the history of the job,
+// so matches can reference content of the previous job.
+func (e *Encoder) encodeJob(enc encoder, j *encoderJob) {
+	hist, src := j.data[:j.hist], j.data[j.hist:]
+	if !j.sync && j.offset == 0 {
+		// The dictionary precedes the frame, so it can be referenced
+		// when the history starts at the start of the frame.
+		enc.Reset(e.o.dict, false)
+	} else {
+		// Content between the dictionary and the history is unknown to the encoder,
+		// so the dictionary cannot be placed before the history.
+		enc.Reset(nil, false)
+	}
+	blk := enc.Block()
+	if j.hist > 0 {
+		// The decoder state from previous blocks depends on the previous job,
+		// so addHistory will start with a clean state.
+		e.addHistory(enc, blk, hist)
+	}
+	if len(src) == 0 {
+		blk.last = true
+		blk.encodeRaw(nil)
+		j.out = append(j.out, blk.output...)
+		return
+	}
+	for len(src) > 0 {
+		todo := src
+		if len(todo) > e.o.blockSize {
+			todo = todo[:e.o.blockSize]
+		}
+		src = src[len(todo):]
+		if err := j.ctx.Err(); err != nil {
+			j.err = err
+			return
+		}
+		blk.pushOffsets()
+		enc.Encode(blk, todo)
+		blk.last = j.final && len(src) == 0
+		err := blk.encode(todo, e.o.noEntropy, !e.o.allLitEntropy)
+		if err != nil {
+			j.err = err
+			return
+		}
+		j.out = append(j.out, blk.output...)
+		blk.reset(nil)
+	}
+}
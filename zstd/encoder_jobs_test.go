@@ -0,0 +1,269 @@
+package zstd
+
+import (
+	"bytes"
+	"io"
+	"math/rand"
+	"os"
+	"testing"
+)
+
+func TestEncoderJobs(t *testing.T) {
+	f, err := os.Open("testdata/xml.zst")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	dec, err := NewReader(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer dec.Close()
+	in, err := io.ReadAll(dec)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if testing.Short() {
+		in = in[:1<<20]
+	}
+
+	for level := speedNotSet + 1; level < speedLast; level++ {
+		t.Run(level.String(), func(t *testing.T) {
+			if isRaceTest && level >= SpeedBestCompression {
+				t.SkipNow()
+			}
+			var want []byte
+			for _, conc := range []int{1, 3, 8} {
+				e, err := NewWriter(nil, WithEncoderLevel(level), WithWindowSize(1<<20), WithEncoderJobSize(512<<10), WithEncoderConcurrency(conc))
+				if err != nil {
+					t.Fatal(err)
+				}
+				var buf bytes.Buffer
+				e.Reset(&buf)
+				rng := rand.New(rand.NewSource(1))
+				for rem := in; len(rem) > 0; {
+					n := min(len(rem), 1+rng.Intn(200<<10))
+					if _, err := e.Write(rem[:n]); err != nil {
+						t.Fatal(err)
+					}
+					rem = rem[n:]
+				}
+				if err := e.Close(); err != nil {
+					t.Fatal(err)
+				}
+				if want == nil {
+					want = buf.Bytes()
+					t.Logf("%d -> %d bytes", len(in), len(want))
+					ref := e.EncodeAll(in, nil)
+					if len(want) > len(ref)+len(ref)/20 {
+						t.Errorf("jobs: got %d bytes, without jobs %d bytes", len(want), len(ref))
+					}
+				} else if !bytes.Equal(want, buf.Bytes()) {
+					t.Errorf("concurrency %d: output differs", conc)
+				}
+				got, err := dec.DecodeAll(buf.Bytes(), nil)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if !bytes.Equal(got, in) {
+					t.Fatalf("concurrency %d: decoded mismatch", conc)
+				}
+				e.Close()
+			}
+		})
+	}
+}
+
+func TestEncoderJobsDict(t *testing.T) {
+	const jobSize = 64 << 10
+	// Each job contains a different part of the dictionary,
+	// so it can only be compressed by referencing the dictionary.
+	dict := make([]byte, 4*jobSize)
+	rand.New(rand.NewSource(1)).Read(dict)
+	in := dict
+	e, err := NewWriter(nil, WithEncoderJobSize(jobSize), WithWindowSize(1<<20), WithEncoderConcurrency(2), WithEncoderDictRaw(1234, dict))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer e.Close()
+	var buf bytes.Buffer
+	e.Reset(&buf)
+	if _, err := e.Write(in); err != nil {
+		t.Fatal(err)
+	}
+	if err := e.Close(); err != nil {
+		t.Fatal(err)
+	}
+	dec, err := NewReader(nil, WithDecoderDictRaw(1234, dict))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer dec.Close()
+	got, err := dec.DecodeAll(buf.Bytes(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, in) {
+		t.Fatal("decoded mismatch")
+	}
+	// The history of the first three jobs starts at the start of the frame,
+	// so only the last job cannot use the dictionary.
+	if limit := jobSize + jobSize/4; buf.Len() > limit {
+		t.Errorf("dictionary not used by later jobs, got %d bytes, want <= %d", buf.Len(), limit)
+	}
+}
+
+func TestEncoderJobsFlush(t *testing.T) {
+	in := make([]byte, 600<<10)
+	rng := rand.New(rand.NewSource(1))
+	for i := range in {
+		in[i] = byte(rng.Intn(10)) + 'a'
+	}
+	e, err := NewWriter(nil, WithEncoderJobSize(64<<10), WithEncoderConcurrency(4))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer e.Close()
+	pr, pw := io.Pipe()
+	e.Reset(pw)
+	dec, err := NewReader(pr, WithDecoderConcurrency(1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer dec.Close()
+
+	// Data written before a Flush must be decodable.
+	const partSize = 50 << 10
+	go func() {
+		for i := 0; i < len(in); i += partSize {
+			if _, err := e.Write(in[i:min(i+partSize, len(in))]); err != nil {
+				pw.CloseWithError(err)
+				return
+			}
+			if err := e.Flush(); err != nil {
+				pw.CloseWithError(err)
+				return
+			}
+		}
+		pw.CloseWithError(e.Close())
+	}()
+	for i := 0; i < len(in); i += partSize {
+		part := in[i:min(i+partSize, len(in))]
+		got := make([]byte, len(part))
+		if _, err := io.ReadFull(dec, got); err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(got, part) {
+			t.Fatalf("offset %d: mismatch", i)
+		}
+	}
+	if n, err := io.Copy(io.Discard, dec); n != 0 || err != nil {
+		t.Fatalf("want 0, nil got %d, %v", n, err)
+	}
+
+	var buf bytes.Buffer
+	// Re-use with ReadFrom.
+	buf.Reset()
+	e.Reset(&buf)
+	if _, err := e.ReadFrom(bytes.NewReader(in)); err != nil {
+		t.Fatal(err)
+	}
+	if err := e.Close(); err != nil {
+		t.Fatal(err)
+	}
+	got, err := dec.DecodeAll(buf.Bytes(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, in) {
+		t.Fatal("ReadFrom: decoded mismatch")
+	}
+}
+
+func TestEncoderRsyncable(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	words := make([][]byte, 500)
+	for i := range words {
+		w := make([]byte, 2+rng.Intn(10))
+		for j := range w {
+			w[j] = byte(rng.Intn(26)) + 'a'
+		}
+		words[i] = append(w, ' ')
+	}
+	var in []byte
+	for len(in) < 4<<20 {
+		in = append(in, words[rng.Intn(len(words))]...)
+	}
+	// Insert some bytes near the start.
+	modified := append(append(append([]byte{}, in[:1000]...), "inserted"...), in[1000:]...)
+
+	dec, err := NewReader(nil, WithDecoderDictRaw(1234, in[:10000]))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer dec.Close()
+	for _, test := range []testEncOpt{
+		{name: "default", o: []EOption{WithEncoderRsyncable(true), WithEncoderCRC(false)}},
+		{name: "sync", o: []EOption{WithEncoderRsyncable(true), WithEncoderCRC(false), WithEncoderConcurrency(1), WithEncoderJobSize(256 << 10)}},
+		{name: "concurrent", o: []EOption{WithEncoderRsyncable(true), WithEncoderCRC(false), WithEncoderConcurrency(4), WithEncoderJobSize(256 << 10)}},
+		{name: "dict", o: []EOption{WithEncoderRsyncable(true), WithEncoderCRC(false), WithEncoderJobSize(256 << 10), WithEncoderDictRaw(1234, in[:10000])}},
+		{name: "best", o: []EOption{WithEncoderRsyncable(true), WithEncoderCRC(false), WithEncoderJobSize(256 << 10), WithEncoderLevel(SpeedBestCompression)}},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			e, err := NewWriter(nil, test.o...)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer e.Close()
+			compress := func(b []byte, readFrom bool) []byte {
+				var buf bytes.Buffer
+				e.Reset(&buf)
+				if readFrom {
+					if _, err := e.ReadFrom(bytes.NewReader(b)); err != nil {
+						t.Fatal(err)
+					}
+				} else {
+					for rem := b; len(rem) > 0; {
+						n := min(len(rem), 1+rng.Intn(200<<10))
+						if _, err := e.Write(rem[:n]); err != nil {
+							t.Fatal(err)
+						}
+						rem = rem[n:]
+					}
+				}
+				if err := e.Close(); err != nil {
+					t.Fatal(err)
+				}
+				got, err := dec.DecodeAll(buf.Bytes(), nil)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if !bytes.Equal(got, b) {
+					t.Fatal("decoded mismatch")
+				}
+				return buf.Bytes()
+			}
+			a := compress(in, true)
+			if b := compress(in, false); !bytes.Equal(a, b) {
+				t.Error("output depends on write sizes")
+			}
+			b := compress(modified, true)
+			if ref := e.EncodeAll(in, nil); len(a) > len(ref)+len(ref)/10 {
+				t.Errorf("rsyncable: got %d bytes, without %d bytes", len(a), len(ref))
+			}
+			if test.name == "default" {
+				// The default job size is bigger than the input.
+				return
+			}
+			// Everything after the first synchronization point should be identical.
+			n := 0
+			for n < len(a) && n < len(b) && a[len(a)-1-n] == b[len(b)-1-n] {
+				n++
+			}
+			t.Logf("%d bytes, %d bytes identical at end", len(a), n)
+			if n < len(a)*3/4 {
+				t.Errorf("only %d of %d bytes identical at end", n, len(a))
+			}
+		})
+	}
+}
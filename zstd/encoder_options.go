@@ -26,9 +26,26 @@ type encoderOptions struct {
 	allLitEntropy   bool
 	customWindow    bool
 	customALEntropy bool
-	customBlockSize bool
 	lowMem          bool
+	ldm             bool
+	jobSize         int
+	rsyncable       bool
+	adaptMin        EncoderLevel
+	adaptMax        EncoderLevel
+	seqProducer     SequenceProducer
+	stats           func(s *FrameStats)
+	flushOnWrite    bool
+	format          FrameFormat
 	dict            *dict
+
+	// Level and job size set by options.
+	// validate derives the values above from these.
+	userLevel   EncoderLevel
+	userJobSize int
+
+	// smallBlocks is set if SpeedFastest was selected without a custom window,
+	// which uses 64KB blocks.
+	smallBlocks bool
 }
 
 func (o *encoderOptions) setDefault() {
@@ -39,13 +56,87 @@ func (o *encoderOptions) setDefault() {
 		blockSize:     maxCompressedBlockSize,
 		windowSize:    8 << 20,
 		level:         SpeedDefault,
+		userLevel:     SpeedDefault,
 		allLitEntropy: false,
 		lowMem:        false,
 	}
 }
 
+// validate will check that the options can be combined
+// and set options that depend on other options.
+// Derived values are recomputed on every call,
+// so options applied on reset don't see values derived from previous options.
+func (o *encoderOptions) validate() error {
+	o.level = o.userLevel
+	if !o.customWindow {
+		o.windowSize = 8 << 20
+		if o.level == SpeedFastest {
+			o.windowSize = 4 << 20
+		}
+		if o.ldm {
+			o.windowSize = ldmDefaultWindow
+		}
+	}
+	o.blockSize = maxCompressedBlockSize
+	if o.smallBlocks {
+		o.blockSize = 1 << 16
+	}
+	// Blocks are limited by the window, regardless of the level.
+	o.blockSize = min(o.blockSize, o.windowSize)
+	if !o.customALEntropy {
+		o.allLitEntropy = o.level > SpeedDefault
+	}
+	o.jobSize = o.userJobSize
+	if o.rsyncable && o.jobSize == 0 {
+		o.jobSize = max(4*o.windowSize, 1<<20)
+	}
+	if o.flushOnWrite && o.jobSize > 0 {
+		return errors.New("flush on write cannot be used with jobs")
+	}
+	if o.pad > 0 && o.format != FormatZstd1 {
+		return errors.New("padding cannot be used without frame magic")
+	}
+	if o.adaptMax != 0 {
+		if o.jobSize > 0 {
+			return errors.New("adaptive level cannot be used with jobs")
+		}
+		o.level = min(max(o.level, o.adaptMin), o.adaptMax)
+	}
+	return nil
+}
+
+// canReuse returns whether encoders created with the options o
+// can be used with the options n, once the window size has been updated.
+func (o encoderOptions) canReuse(n encoderOptions) bool {
+	switch {
+	case o.level != n.level, o.lowMem != n.lowMem, o.ldm != n.ldm:
+		return false
+	case (o.dict == nil) != (n.dict == nil):
+		// Encoders for the fastest levels only support dictionaries if created with one.
+		return false
+	case o.seqProducer != nil || n.seqProducer != nil:
+		return false
+	case o.windowSize != n.windowSize && (o.ldm || o.level == SpeedUltraCompression):
+		// Tables are sized by the window.
+		return false
+	}
+	return true
+}
+
 // encoder returns an encoder with the selected options.
 func (o encoderOptions) encoder() encoder {
+	enc := o.levelEncoder()
+	if o.ldm {
+		enc = newLDMEncoder(enc, o.windowSize)
+	}
+	if o.seqProducer != nil {
+		enc = newSeqProducerEncoder(enc, o.seqProducer)
+	}
+	return enc
+}
+
+// levelEncoder returns the match finder for the selected level.
+func (o encoderOptions) levelEncoder() encoder {
 	switch o.level {
 	case SpeedFastest:
 		if o.dict != nil {
@@ -65,10 +156,27 @@ func (o encoderOptions) encoder() encoder {
 		return &betterFastEncoder{fastBase: fastBase{maxMatchOff: int32(o.windowSize), bufferReset: math.MaxInt32 - int32(o.windowSize*2), lowMem: o.lowMem}}
 	case SpeedBestCompression:
 		return &bestFastEncoder{fastBase: fastBase{maxMatchOff: int32(o.windowSize), bufferReset: math.MaxInt32 - int32(o.windowSize*2), lowMem: o.lowMem}}
+	case SpeedUltraCompression:
+		return &ultraEncoder{fastBase: fastBase{maxMatchOff: int32(o.windowSize), bufferReset: math.MaxInt32 - int32(o.windowSize*2), lowMem: o.lowMem}}
 	}
 	panic("unknown compression level")
 }
 
+// jobOverlap returns the amount of history each job is given
+// from the previous job when WithEncoderJobSize is used.
+// Similar to the reference implementation, stronger levels get more history.
+func (o encoderOptions) jobOverlap() int {
+	switch o.level {
+	case SpeedBetterCompression:
+		return o.windowSize / 4
+	case SpeedBestCompression:
+		return o.windowSize / 2
+	case SpeedUltraCompression:
+		return o.windowSize
+	}
+	return o.windowSize / 8
+}
+
 // WithEncoderCRC will add CRC value to output.
 // Output will be 4 bytes larger.
 func WithEncoderCRC(b bool) EOption {
@@ -90,6 +198,69 @@ func WithEncoderConcurrency(n int) EOption {
 	}
 }
 
+// WithEncoderJobSize will split streams into jobs of n bytes that are compressed in parallel.
+// This allows a single stream to use up to the encoder concurrency for compression,
+// similar to the "-T" option of the reference implementation.
+// Each job is given the end of the previous job as history,
+// so compression is only slightly worse than with regular streams.
+// Output is still a single frame and only depends on the job size,
+// not the concurrency, so output is deterministic regardless of the number of goroutines.
+// Memory usage is about concurrency * (job size + window size).
+// n must be 0 or at least 64KB. A value of 0 disables jobs, which is the default.
+// A job size of 4 times the window size is a good starting point.
+// Only streams are affected, EncodeAll will not split input into jobs.
+func WithEncoderJobSize(n int) EOption {
+	return func(o *encoderOptions) error {
+		switch {
+		case n < 0:
+			return errors.New("job size must not be negative")
+		case n > 0 && n < 64<<10:
+			return errors.New("job size must be at least 64KB")
+		}
+		o.userJobSize = n
+		return nil
+	}
+}
+
+// WithEncoderRsyncable will end jobs at content-defined synchronization points
+// found with a rolling hash of the input, similar to the "--rsyncable" option of the reference implementation.
+// Jobs starting at a synchronization point are compressed without history,
+// so a change of the input only changes the output until the next synchronization point.
+// This makes the output suited for rsync and deduplication, at a small cost in compression.
+// Output is a regular frame.
+//
+// Synchronization points are on average every job size bytes, and at least 128KB apart.
+// If WithEncoderJobSize is not used, a job size of 4 times the window size, but at least 1MB, is used.
+// Only streams are affected, EncodeAll will not split input into jobs.
+func WithEncoderRsyncable(b bool) EOption {
+	return func(o *encoderOptions) error {
+		o.rsyncable = b
+		return nil
+	}
+}
+
+// WithEncoderAdapt will adapt the compression level of streams to the speed of the output,
+// similar to the "--adapt" option of the reference implementation.
+// The level is changed between minLevel and maxLevel at block boundaries within a frame.
+// When writing the output takes longer than compressing it, the level is increased,
+// and when writing is much faster than compressing, the level is decreased.
+// Compression starts at the level set with WithEncoderLevel, limited to the range.
+// When switching to another level, the encoder is given the last 256KB of input as history.
+// Only streams are affected, and it cannot be combined with WithEncoderJobSize or WithEncoderRsyncable.
+// A typical range is SpeedFastest to SpeedBestCompression.
+func WithEncoderAdapt(minLevel, maxLevel EncoderLevel) EOption {
+	return func(o *encoderOptions) error {
+		switch {
+		case minLevel < SpeedFastest || maxLevel >= speedLast:
+			return errors.New("unknown encoder level")
+		case minLevel > maxLevel:
+			return errors.New("minimum level must not be above maximum level")
+		}
+		o.adaptMin, o.adaptMax = minLevel, maxLevel
+		return nil
+	}
+}
+
 // WithWindowSize will set the maximum allowed back-reference distance.
 // The value must be a power of two between MinWindowSize and MaxWindowSize.
 // A larger value will enable better compression but allocate more memory and,
@@ -108,10 +279,49 @@ func WithWindowSize(n int) EOption {
 
 		o.windowSize = n
 		o.customWindow = true
-		if o.blockSize > o.windowSize {
-			o.blockSize = o.windowSize
-			o.customBlockSize = true
-		}
+		return nil
+	}
+}
+
+// WithLongDistanceMatching enables long distance matching,
+// similar to the "--long" option of the reference implementation.
+// A rolling hash is used to find long matches far back in the window,
+// which the regular match finders are unlikely to find in large windows.
+// The remaining content is compressed with the selected level.
+// This is mainly useful for big inputs with repeated content spread far apart,
+// for example disk images, backups and archives.
+// If no window size has been specified, the window size will be set to 128MB.
+// Decoders must allow the window size. See WithDecoderMaxWindow.
+func WithLongDistanceMatching(b bool) EOption {
+	return func(o *encoderOptions) error {
+		o.ldm = b
+		return nil
+	}
+}
+
+// WithEncoderSequenceProducer will use sequences from p instead of the built-in match finder,
+// similar to ZSTD_registerSequenceProducer of the reference implementation.
+// This allows custom match finders, while entropy coding is done by the encoder.
+// If p returns an error or invalid sequences for a block,
+// the block is compressed with the match finder of the selected level instead.
+// Matches in sequences are verified, so invalid sequences cannot corrupt the output.
+// See SequenceProducer for details.
+// Supplying nil will remove the sequence producer.
+func WithEncoderSequenceProducer(p SequenceProducer) EOption {
+	return func(o *encoderOptions) error {
+		o.seqProducer = p
+		return nil
+	}
+}
+
+// WithEncoderStats will call fn with statistics for every encoded frame.
+// Streams are reported when the frame is completed by Close.
+// Since EncodeAll can be called concurrently, fn may be called concurrently.
+// s is only valid until fn returns.
+// Supplying nil will disable statistics.
+func WithEncoderStats(fn func(s *FrameStats)) EOption {
+	return func(o *encoderOptions) error {
+		o.stats = fn
 		return nil
 	}
 }
@@ -139,6 +349,20 @@ func WithEncoderPadding(n int) EOption {
 	}
 }
 
+// WithEncoderFlushOnWrite will end a block on every call to Write, similar to AsyncFlush.
+// This allows the receiver to decode everything written without waiting for more data,
+// which is useful for streaming RPC and other protocols sending messages on a stream.
+// Blocks are compressed and written in the background, so writes can be pipelined.
+// Use WithEncoderConcurrency(1) to write the output before Write returns.
+// Compression is worse if writes are small, since blocks are limited to the size of each write.
+// Flush on write cannot be used with WithEncoderJobSize or WithEncoderRsyncable.
+func WithEncoderFlushOnWrite(b bool) EOption {
+	return func(o *encoderOptions) error {
+		o.flushOnWrite = b
+		return nil
+	}
+}
+
 // EncoderLevel predefines encoder compression levels.
 // Only use the constants made available, since the actual mapping
 // of these values are very likely to change and your compression could change
@@ -165,6 +389,13 @@ const (
 	// This will offer the best compression no matter the CPU cost.
 	SpeedBestCompression
 
+	// SpeedUltraCompression will use optimal parsing to find the smallest output.
+	// This is roughly equivalent to Zstandard levels 19-22, and is very slow.
+	// It is mainly intended for data that is compressed once and stored for a long time.
+	// Decompression speed is not affected.
+	// This level is never returned by EncoderLevelFromZstd and must be selected explicitly.
+	SpeedUltraCompression
+
 	// speedLast should be kept as the last actual compression option.
 	// The is not for external usage, but is used to keep track of the valid options.
 	speedLast
@@ -209,6 +440,8 @@ func (e EncoderLevel) String() string {
 		return "better"
 	case SpeedBestCompression:
 		return "best"
+	case SpeedUltraCompression:
+		return "ultra"
 	default:
 		return "invalid"
 	}
@@ -221,26 +454,10 @@ func WithEncoderLevel(l EncoderLevel) EOption {
 		case l <= speedNotSet || l >= speedLast:
 			return fmt.Errorf("unknown encoder level")
 		}
-		o.level = l
+		o.userLevel = l
 		if !o.customWindow {
-			switch o.level {
-			case SpeedFastest:
-				o.windowSize = 4 << 20
-				if !o.customBlockSize {
-					o.blockSize = 1 << 16
-				}
-			case SpeedDefault:
-				o.windowSize = 8 << 20
-			case SpeedBetterCompression:
-				o.windowSize = 8 << 20
-			case SpeedBestCompression:
-				o.windowSize = 8 << 20
-			}
-		}
-		if !o.customALEntropy {
-			o.allLitEntropy = l > SpeedDefault
+			o.smallBlocks = l == SpeedFastest
 		}
-
 		return nil
 	}
 }
@@ -294,6 +511,21 @@ func WithSingleSegment(b bool) EOption {
 	}
 }
 
+// WithEncoderFormat sets the format of the frames written.
+// With FormatMinimal the window size set with WithWindowSize and the ID of the dictionary
+// must be given to the decoder with WithDecoderMinimalHeader.
+// Padding cannot be used unless the format is FormatZstd1.
+// Default is FormatZstd1.
+func WithEncoderFormat(f FrameFormat) EOption {
+	return func(o *encoderOptions) error {
+		if f > FormatMinimal {
+			return fmt.Errorf("unknown frame format %d", f)
+		}
+		o.format = f
+		return nil
+	}
+}
+
 // WithLowerEncoderMem will trade in some memory cases trade less memory usage for
 // slower encoding speed.
 // This will not change the window size which is the primary function for reducing
@@ -337,3 +569,17 @@ func WithEncoderDictRaw(id uint32, content []byte) EOption {
 		return nil
 	}
 }
+
+// WithEncoderSharedDict will use a dictionary shared with other encoders and decoders.
+// Hash tables built from the dictionary are shared by all encoders using d with the same level and window size,
+// so encoders only allocate tables for their own state.
+// See Dict for details.
+func WithEncoderSharedDict(d *Dict) EOption {
+	return func(o *encoderOptions) error {
+		if d == nil {
+			return errors.New("nil dictionary")
+		}
+		o.dict = d.d
+		return nil
+	}
+}
@@ -112,6 +112,11 @@ func TestEncoderLevelFromZstd(t *testing.T) {
 			args: args{level: 4},
 			want: SpeedDefault,
 		},
+		{
+			name: "level-11",
+			args: args{level: 11},
+			want: SpeedBestCompression,
+		},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
@@ -152,3 +157,27 @@ func TestWindowSize(t *testing.T) {
 		})
 	}
 }
+
+func TestEncoderBlockSize(t *testing.T) {
+	for i, test := range []struct {
+		opts []EOption
+		want int
+	}{
+		{opts: nil, want: maxCompressedBlockSize},
+		{opts: []EOption{WithEncoderLevel(SpeedFastest)}, want: 64 << 10},
+		{opts: []EOption{WithEncoderLevel(SpeedFastest), WithWindowSize(1 << 20)}, want: 64 << 10},
+		{opts: []EOption{WithWindowSize(1 << 20), WithEncoderLevel(SpeedFastest)}, want: maxCompressedBlockSize},
+		{opts: []EOption{WithWindowSize(32 << 10), WithEncoderLevel(SpeedFastest)}, want: 32 << 10},
+		{opts: []EOption{WithEncoderLevel(SpeedFastest), WithWindowSize(32 << 10)}, want: 32 << 10},
+		{opts: []EOption{WithWindowSize(64 << 10), WithEncoderLevel(SpeedBestCompression)}, want: 64 << 10},
+		{opts: []EOption{WithEncoderLevel(SpeedFastest), WithEncoderLevel(SpeedBestCompression)}, want: maxCompressedBlockSize},
+	} {
+		e, err := NewWriter(nil, test.opts...)
+		if err != nil {
+			t.Fatal(i, err)
+		}
+		if e.o.blockSize != test.want {
+			t.Errorf("%d: got block size %d, want %d", i, e.o.blockSize, test.want)
+		}
+	}
+}
@@ -6,6 +6,7 @@ package zstd
 
 import (
 	"bytes"
+	"context"
 	"errors"
 	"fmt"
 	"io"
@@ -52,6 +53,7 @@ func getEncOpts(cMax int) []testEncOpt {
 				addOpt("pad1k", WithEncoderPadding(1024))
 				addOpt("zerof", WithZeroFrames(true))
 				addOpt("1seg", WithSingleSegment(true))
+				addOpt("ldm", WithLongDistanceMatching(true))
 			}
 			if testing.Short() && conc == 2 {
 				break
@@ -824,6 +826,96 @@ func TestEncoderReadFrom(t *testing.T) {
 	dec.Close()
 }
 
+// cancelWriter cancels a context when more than n bytes have been written.
+type cancelWriter struct {
+	n       int
+	written int
+	cancel  context.CancelFunc
+}
+
+func (w *cancelWriter) Write(p []byte) (int, error) {
+	w.written += len(p)
+	if w.written > w.n {
+		w.cancel()
+	}
+	return len(p), nil
+}
+
+func TestEncoderContext(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	in := make([]byte, 8<<20)
+	for i := range in {
+		in[i] = 'a' + byte(rng.Intn(16))
+	}
+	for _, test := range []testEncOpt{
+		{name: "sync", o: []EOption{WithEncoderConcurrency(1)}},
+		{name: "async", o: []EOption{WithEncoderConcurrency(4)}},
+		{name: "jobs", o: []EOption{WithEncoderConcurrency(4), WithEncoderJobSize(1 << 20)}},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			e, err := NewWriter(nil, test.o...)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer e.Close()
+
+			// Stream canceled after the first output.
+			ctx, cancel := context.WithCancel(context.Background())
+			w := &cancelWriter{n: 0, cancel: cancel}
+			e.ResetContext(ctx, w)
+			_, err = e.ReadFrom(bytes.NewReader(in))
+			if err == nil {
+				err = e.Close()
+			}
+			if !errors.Is(err, context.Canceled) {
+				t.Fatalf("want context.Canceled, got %v", err)
+			}
+			if err := e.Close(); !errors.Is(err, context.Canceled) {
+				t.Errorf("Close: want context.Canceled, got %v", err)
+			}
+
+			// Reset waits for pending writes and clears the error.
+			var buf bytes.Buffer
+			e.Reset(&buf)
+			if w.written > len(in)/2 {
+				t.Errorf("wrote %d bytes after cancel", w.written)
+			}
+			if _, err := e.Write(in[:1<<20]); err != nil {
+				t.Fatal(err)
+			}
+			if err := e.Close(); err != nil {
+				t.Fatal(err)
+			}
+			dec, err := NewReader(nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer dec.Close()
+			got, err := dec.DecodeAll(buf.Bytes(), nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(got, in[:1<<20]) {
+				t.Fatal("output mismatch")
+			}
+
+			// EncodeAllContext
+			dst := []byte("prefix")
+			got, err = e.EncodeAllContext(ctx, in, dst)
+			if !errors.Is(err, context.Canceled) || string(got) != "prefix" {
+				t.Errorf("want context.Canceled and unmodified dst, got %v, %d bytes", err, len(got))
+			}
+			got, err = e.EncodeAllContext(context.Background(), in, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(got, e.EncodeAll(in, nil)) {
+				t.Error("EncodeAllContext and EncodeAll output differs")
+			}
+		})
+	}
+}
+
 func TestInterleavedWriteReadFrom(t *testing.T) {
 	var encoded bytes.Buffer
 
@@ -1297,3 +1389,452 @@ func BenchmarkRandomEncoderDefault(b *testing.B) {
 		}
 	}
 }
+
+func TestEncoder_EncodeAllMaxCompressed(t *testing.T) {
+	text, err := os.ReadFile("../testdata/Mark.Twain-Tom.Sawyer.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	random := make([]byte, 100<<10)
+	rand.New(rand.NewSource(1)).Read(random)
+	dec, err := NewReader(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer dec.Close()
+	for _, level := range []EncoderLevel{SpeedFastest, SpeedDefault, SpeedBestCompression} {
+		e, err := NewWriter(nil, WithEncoderLevel(level), WithEncoderConcurrency(1))
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, input := range []struct {
+			name string
+			b    []byte
+		}{{"text", text}, {"random", random}, {"zeros", make([]byte, 1<<20)}} {
+			for _, maxSize := range []int{100, 1200, 64 << 10} {
+				t.Run(fmt.Sprint(level, "-", input.name, "-", maxSize), func(t *testing.T) {
+					var frames int
+					var total int
+					src := input.b
+					for len(src) > 0 {
+						frame, n, err := e.EncodeAllMaxCompressed(src, nil, maxSize)
+						if err != nil {
+							t.Fatal(err)
+						}
+						if len(frame) > maxSize {
+							t.Fatalf("frame %d: %d > %d bytes", frames, len(frame), maxSize)
+						}
+						got, err := dec.DecodeAll(frame, nil)
+						if err != nil {
+							t.Fatal(err)
+						}
+						if !bytes.Equal(got, src[:n]) {
+							t.Fatalf("frame %d: decoded mismatch", frames)
+						}
+						src = src[n:]
+						frames++
+						total += len(frame)
+					}
+					// Frames should be almost full.
+					if frames > 2 && total/(frames-1) < maxSize*9/10 {
+						t.Errorf("%d frames, %d bytes average", frames, total/frames)
+					}
+				})
+			}
+		}
+		e.Close()
+	}
+
+	e, err := NewWriter(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer e.Close()
+	dst := []byte("prefix")
+	if _, _, err := e.EncodeAllMaxCompressed(text, dst, 10); err == nil {
+		t.Error("want error")
+	}
+	got, n, err := e.EncodeAllMaxCompressed(text[:100], dst, 1000)
+	if err != nil || n != 100 || !bytes.HasPrefix(got, dst) {
+		t.Fatalf("want all input, got %d, %v", n, err)
+	}
+	if got, err := dec.DecodeAll(got[len(dst):], nil); err != nil || !bytes.Equal(got, text[:100]) {
+		t.Error("decoded mismatch", err)
+	}
+}
+
+func TestEncoder_EncodeAllWithPrefix(t *testing.T) {
+	f, err := os.Open("testdata/xml.zst")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	dec, err := NewReader(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer dec.Close()
+	in, err := io.ReadAll(dec)
+	if err != nil {
+		t.Fatal(err)
+	}
+	old := in[:2<<20]
+	// Create a new version with some changes.
+	rng := rand.New(rand.NewSource(1))
+	var changed []byte
+	for rem := old; len(rem) > 0; {
+		n := min(len(rem), rng.Intn(100<<10))
+		changed = append(changed, rem[:n]...)
+		rem = rem[n:]
+		switch rng.Intn(3) {
+		case 0:
+			changed = append(changed, "inserted"...)
+		case 1:
+			rem = rem[min(len(rem), 100):]
+		}
+	}
+
+	for level := speedNotSet + 1; level < speedLast; level++ {
+		t.Run(level.String(), func(t *testing.T) {
+			if isRaceTest && level >= SpeedBestCompression || testing.Short() && level == SpeedUltraCompression {
+				t.SkipNow()
+			}
+			for _, window := range []int{1 << 20, 4 << 20} {
+				e, err := NewWriter(nil, WithEncoderLevel(level), WithWindowSize(window))
+				if err != nil {
+					t.Fatal(err)
+				}
+				defer e.Close()
+				ref := e.EncodeAll(changed, nil)
+				dst := e.EncodeAllWithPrefix(old, changed, nil)
+				t.Logf("window %d: %d -> %d bytes, without prefix %d bytes", window, len(changed), len(dst), len(ref))
+				if window > len(old) && len(dst) > len(ref)/10 {
+					t.Errorf("window %d: prefix compression too large: %d", window, len(dst))
+				}
+				decoded, err := dec.DecodeAllWithPrefix(old, dst, nil)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if !bytes.Equal(decoded, changed) {
+					t.Fatal("decoded does not match")
+				}
+				if _, err := dec.DecodeAll(dst, nil); err == nil {
+					t.Error("want error without prefix")
+				}
+				// Concatenated frames use the same prefix.
+				dst = e.EncodeAllWithPrefix(old, old[:1000], dst)
+				decoded, err = dec.DecodeAllWithPrefix(old, dst, []byte("x"))
+				if err != nil {
+					t.Fatal(err)
+				}
+				if !bytes.Equal(decoded, append(append([]byte("x"), changed...), old[:1000]...)) {
+					t.Fatal("decoded concatenated does not match")
+				}
+			}
+		})
+	}
+}
+
+func TestEncoder_UltraCompression(t *testing.T) {
+	if isRaceTest {
+		t.SkipNow()
+	}
+	in, err := os.ReadFile("../testdata/Mark.Twain-Tom.Sawyer.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	dec, err := NewReader(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer dec.Close()
+	best, err := NewWriter(nil, WithEncoderLevel(SpeedBestCompression))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer best.Close()
+	e, err := NewWriter(nil, WithEncoderLevel(SpeedUltraCompression))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer e.Close()
+
+	want := best.EncodeAll(in, nil)
+	dst := e.EncodeAll(in, nil)
+	t.Logf("best: %d, ultra: %d", len(want), len(dst))
+	if len(dst) >= len(want) {
+		t.Errorf("ultra (%d bytes) should be smaller than best (%d bytes)", len(dst), len(want))
+	}
+	got, err := dec.DecodeAll(dst, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, in) {
+		t.Fatal("decoded mismatch")
+	}
+
+	// Stream with flushes, so blocks are small.
+	var buf bytes.Buffer
+	e.Reset(&buf)
+	for rem := in; len(rem) > 0; {
+		n := min(len(rem), 10000)
+		if _, err := e.Write(rem[:n]); err != nil {
+			t.Fatal(err)
+		}
+		if err := e.Flush(); err != nil {
+			t.Fatal(err)
+		}
+		rem = rem[n:]
+	}
+	if err := e.Close(); err != nil {
+		t.Fatal(err)
+	}
+	got, err = dec.DecodeAll(buf.Bytes(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, in) {
+		t.Fatal("stream: decoded mismatch")
+	}
+	t.Logf("stream with flushes: %d", buf.Len())
+}
+
+func TestEncoder_ResetWithOptions(t *testing.T) {
+	in, err := os.ReadFile("../testdata/Mark.Twain-Tom.Sawyer.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	samples := coverSamples(200)
+	dict, err := BuildDict(BuildDictOptions{ID: 1, Contents: samples, History: bytes.Join(samples[:100], nil), Offsets: [3]int{1, 4, 8}, Level: SpeedDefault})
+	if err != nil {
+		t.Fatal(err)
+	}
+	pooled := func(e *Encoder) map[encoder]bool {
+		m := make(map[encoder]bool)
+		for range len(e.encoders) {
+			enc := <-e.encoders
+			m[enc] = true
+			e.encoders <- enc
+		}
+		return m
+	}
+	e, err := NewWriter(nil, WithEncoderLevel(SpeedFastest), WithEncoderConcurrency(2))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer e.Close()
+	dec, err := NewReader(nil, WithDecoderDicts(dict))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer dec.Close()
+	e.EncodeAll(in, nil)
+	before := pooled(e)
+
+	for i, opts := range [][]EOption{
+		{WithWindowSize(1 << 20)},
+		{WithWindowSize(1 << 16), WithEncoderConcurrency(4)},
+		{WithEncoderDict(dict)},
+		{WithWindowSize(1 << 22)},
+		{WithEncoderLevel(SpeedBetterCompression)},
+		{WithEncoderLevel(SpeedUltraCompression), WithEncoderConcurrency(1)},
+		{WithWindowSize(1 << 18)},
+		{WithLowerEncoderMem(true), WithEncoderLevel(SpeedDefault)},
+		{WithLongDistanceMatching(true), WithEncoderConcurrency(2)},
+		{WithEncoderAdapt(SpeedFastest, SpeedBestCompression)},
+	} {
+		var buf bytes.Buffer
+		if err := e.ResetWithOptions(&buf, opts...); err != nil {
+			t.Fatal(i, err)
+		}
+		if _, err := e.Write(in); err != nil {
+			t.Fatal(i, err)
+		}
+		if err := e.Close(); err != nil {
+			t.Fatal(i, err)
+		}
+		for _, comp := range [][]byte{buf.Bytes(), e.EncodeAll(in, nil)} {
+			got, err := dec.DecodeAll(comp, nil)
+			if err != nil {
+				t.Fatal(i, err)
+			}
+			if !bytes.Equal(got, in) {
+				t.Fatal(i, "output mismatch")
+			}
+		}
+		if i == 0 {
+			// Encoders are kept when only the window changes.
+			after := pooled(e)
+			for enc := range after {
+				if !before[enc] {
+					t.Error("encoder was not reused")
+				}
+				if got := enc.(interface{ base() *fastBase }).base().maxMatchOff; got != 1<<20 {
+					t.Errorf("window not updated, got %d", got)
+				}
+			}
+		}
+	}
+
+	// A dictionary can be removed.
+	dictID := func(b []byte) uint32 {
+		var h Header
+		if err := h.Decode(b); err != nil {
+			t.Fatal(err)
+		}
+		return h.DictionaryID
+	}
+	if err := e.ResetWithDict(nil, dict); err != nil {
+		t.Fatal(err)
+	}
+	if id := dictID(e.EncodeAll(samples[150], nil)); id != 1 {
+		t.Error("dictionary not used")
+	}
+	if err := e.ResetWithDict(nil, nil); err != nil {
+		t.Fatal(err)
+	}
+	if id := dictID(e.EncodeAll(samples[150], nil)); id != 0 {
+		t.Error("dictionary still used")
+	}
+
+	// Invalid combinations must leave the encoder unchanged.
+	o := e.o
+	if err := e.ResetWithOptions(nil, WithEncoderPadding(1024), WithEncoderFormat(FormatMagicless)); err == nil {
+		t.Error("no error for padding without magic")
+	}
+	if err := e.ResetWithDict(nil, []byte("not a dictionary")); err == nil {
+		t.Error("no error for invalid dictionary")
+	}
+	if e.o.format != o.format || e.o.pad != o.pad || e.o.dict != o.dict {
+		t.Error("options changed by failed reset")
+	}
+}
+
+func TestEncoder_ResetWithOptionsDerived(t *testing.T) {
+	in, err := os.ReadFile("../testdata/Mark.Twain-Tom.Sawyer.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	in = bytes.Repeat(in, 3)
+	for i, test := range []struct {
+		// keep is used by both encoders, and reset overrides first.
+		keep, first, reset []EOption
+	}{
+		{first: []EOption{WithEncoderLevel(SpeedFastest)}, reset: []EOption{WithEncoderLevel(SpeedBestCompression)}},
+		{first: []EOption{WithLongDistanceMatching(true)}, reset: []EOption{WithLongDistanceMatching(false)}},
+		{keep: []EOption{WithEncoderLevel(SpeedBestCompression)}, first: []EOption{WithEncoderAdapt(SpeedFastest, SpeedDefault)}, reset: []EOption{WithEncoderAdapt(SpeedFastest, SpeedBestCompression)}},
+		{first: []EOption{WithEncoderRsyncable(true)}, reset: []EOption{WithEncoderRsyncable(false), WithEncoderAdapt(SpeedFastest, SpeedBestCompression)}},
+		{first: []EOption{WithEncoderRsyncable(true)}, reset: []EOption{WithEncoderRsyncable(false), WithEncoderFlushOnWrite(true)}},
+		{first: []EOption{WithEncoderJobSize(1 << 20)}, reset: []EOption{WithEncoderJobSize(0), WithEncoderFlushOnWrite(true)}},
+	} {
+		encode := func(e *Encoder) []byte {
+			var buf bytes.Buffer
+			e.Reset(&buf)
+			if _, err := e.Write(in); err != nil {
+				t.Fatal(i, err)
+			}
+			if err := e.Close(); err != nil {
+				t.Fatal(i, err)
+			}
+			return append(buf.Bytes(), e.EncodeAll(in, nil)...)
+		}
+		keep := append([]EOption{WithEncoderConcurrency(1)}, test.keep...)
+		e, err := NewWriter(nil, append(keep, test.first...)...)
+		if err != nil {
+			t.Fatal(i, err)
+		}
+		if err := e.ResetWithOptions(nil, test.reset...); err != nil {
+			t.Fatal(i, err)
+		}
+		n, err := NewWriter(nil, append(keep[:len(keep):len(keep)], test.reset...)...)
+		if err != nil {
+			t.Fatal(i, err)
+		}
+		got, want := e.o, n.o
+		if got.level != want.level || got.windowSize != want.windowSize || got.blockSize != want.blockSize ||
+			got.jobSize != want.jobSize || got.allLitEntropy != want.allLitEntropy {
+			t.Errorf("%d: reset options differ from new encoder, got level %v, window %d, block %d, job %d, all lit %v, want %v, %d, %d, %d, %v", i,
+				got.level, got.windowSize, got.blockSize, got.jobSize, got.allLitEntropy,
+				want.level, want.windowSize, want.blockSize, want.jobSize, want.allLitEntropy)
+		}
+		// The adaptive level depends on timing, so output is only compared without it.
+		if want.adaptMax != 0 {
+			continue
+		}
+		if got, want := encode(e), encode(n); !bytes.Equal(got, want) {
+			t.Errorf("%d: reset encoder output differs from new encoder, got %d bytes, want %d", i, len(got), len(want))
+		}
+	}
+}
+
+func TestEncoderFlushOnWrite(t *testing.T) {
+	in, err := os.ReadFile("../testdata/Mark.Twain-Tom.Sawyer.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, encConc := range []int{1, 4} {
+		for _, decConc := range []int{1, 4} {
+			for _, async := range []bool{false, true} {
+				t.Run(fmt.Sprintf("enc-%d-dec-%d-async-%v", encConc, decConc, async), func(t *testing.T) {
+					opts := []EOption{WithEncoderConcurrency(encConc)}
+					if !async {
+						opts = append(opts, WithEncoderFlushOnWrite(true))
+					}
+					pr, pw := io.Pipe()
+					enc, err := NewWriter(pw, opts...)
+					if err != nil {
+						t.Fatal(err)
+					}
+					dec, err := NewReader(pr, WithDecoderConcurrency(decConc))
+					if err != nil {
+						t.Fatal(err)
+					}
+					defer dec.Close()
+
+					// Every message must be readable before the next is written.
+					var msgs [][]byte
+					for rest := in; len(rest) > 0; {
+						n := min(len(rest), 1+len(msgs)*len(msgs)*97)
+						msgs = append(msgs, rest[:n])
+						rest = rest[n:]
+					}
+					errc := make(chan error, 1)
+					go func() {
+						for _, msg := range msgs {
+							if _, err := enc.Write(msg); err != nil {
+								errc <- err
+								return
+							}
+							if async {
+								if err := enc.AsyncFlush(); err != nil {
+									errc <- err
+									return
+								}
+							}
+						}
+						errc <- enc.Close()
+						pw.Close()
+					}()
+					for i, msg := range msgs {
+						got := make([]byte, len(msg))
+						if _, err := io.ReadFull(dec, got); err != nil {
+							t.Fatal(i, err)
+						}
+						if !bytes.Equal(got, msg) {
+							t.Fatal(i, "output mismatch")
+						}
+					}
+					if n, err := io.Copy(io.Discard, dec); n != 0 || err != nil {
+						t.Fatal("unexpected output", n, err)
+					}
+					if err := <-errc; err != nil {
+						t.Fatal(err)
+					}
+				})
+			}
+		}
+	}
+	if _, err := NewWriter(nil, WithEncoderFlushOnWrite(true), WithEncoderJobSize(1<<20)); err == nil {
+		t.Error("no error with jobs")
+	}
+}
@@ -5,6 +5,7 @@
 package zstd
 
 import (
+	"context"
 	"encoding/binary"
 	"encoding/hex"
 	"errors"
@@ -27,6 +28,11 @@ type frameDec struct {
 	// Byte buffer that can be reused for small input blocks.
 	bBuf byteBuf
 
+	// Statistics of the current frame, when WithDecoderStats is used.
+	// If statsOut is set, statistics are stored in it instead of being sent.
+	stats    FrameStats
+	statsOut *FrameStats
+
 	FrameContentSize uint64
 
 	DictionaryID  uint32
@@ -49,13 +55,17 @@ const (
 )
 
 func newFrameDec(o decoderOptions) *frameDec {
+	var d frameDec
+	d.setOptions(o)
+	return &d
+}
+
+// setOptions will set the options used for decoding frames.
+func (d *frameDec) setOptions(o decoderOptions) {
 	if o.maxWindowSize > o.maxDecodedSize {
 		o.maxWindowSize = o.maxDecodedSize
 	}
-	d := frameDec{
-		o: o,
-	}
-	return &d
+	d.o = o
 }
 
 // reset will read the frame header and prepare for block decoding.
@@ -65,64 +75,72 @@ func newFrameDec(o decoderOptions) *frameDec {
 func (d *frameDec) reset(br byteBuffer) error {
 	d.HasCheckSum = false
 	d.WindowSize = 0
-	var signature [4]byte
-	for {
-		var err error
-		// Check if we can read more...
-		b, err := br.readSmall(1)
-		switch err {
-		case io.EOF, io.ErrUnexpectedEOF:
-			return io.EOF
-		case nil:
-			signature[0] = b[0]
-		default:
-			return err
-		}
-		// Read the rest, don't allow io.ErrUnexpectedEOF
-		b, err = br.readSmall(3)
-		switch err {
-		case io.EOF:
-			return io.EOF
-		case nil:
-			copy(signature[1:], b)
-		default:
-			return err
-		}
+	if d.o.format == FormatZstd1 {
+		var signature [4]byte
+		for {
+			var err error
+			// Check if we can read more...
+			b, err := br.readSmall(1)
+			switch err {
+			case io.EOF, io.ErrUnexpectedEOF:
+				return io.EOF
+			case nil:
+				signature[0] = b[0]
+			default:
+				return err
+			}
+			// Read the rest, don't allow io.ErrUnexpectedEOF
+			b, err = br.readSmall(3)
+			switch err {
+			case io.EOF:
+				return io.EOF
+			case nil:
+				copy(signature[1:], b)
+			default:
+				return err
+			}
 
-		if string(signature[1:4]) != skippableFrameMagic || signature[0]&0xf0 != 0x50 {
-			if debugDecoder {
-				println("Not skippable", hex.EncodeToString(signature[:]), hex.EncodeToString([]byte(skippableFrameMagic)))
+			if string(signature[1:4]) != skippableFrameMagic || signature[0]&0xf0 != 0x50 {
+				if debugDecoder {
+					println("Not skippable", hex.EncodeToString(signature[:]), hex.EncodeToString([]byte(skippableFrameMagic)))
+				}
+				// Break if not skippable frame.
+				break
 			}
-			// Break if not skippable frame.
-			break
-		}
-		// Read size to skip
-		b, err = br.readSmall(4)
-		if err != nil {
+			// Read size to skip
+			b, err = br.readSmall(4)
+			if err != nil {
+				if debugDecoder {
+					println("Reading Frame Size", err)
+				}
+				return err
+			}
+			n := uint32(b[0]) | (uint32(b[1]) << 8) | (uint32(b[2]) << 16) | (uint32(b[3]) << 24)
 			if debugDecoder {
-				println("Reading Frame Size", err)
+				println("Skipping frame with", n, "bytes.")
+			}
+			err = d.o.skipFrame(br, signature[0]&0xf, n)
+			if err != nil {
+				if debugDecoder {
+					println("Reading discarded frame", err)
+				}
+				return err
 			}
-			return err
 		}
-		n := uint32(b[0]) | (uint32(b[1]) << 8) | (uint32(b[2]) << 16) | (uint32(b[3]) << 24)
-		println("Skipping frame with", n, "bytes.")
-		err = br.skipN(int64(n))
-		if err != nil {
+		if string(signature[:]) != frameMagic {
 			if debugDecoder {
-				println("Reading discarded frame", err)
+				println("Got magic numbers: ", signature, "want:", []byte(frameMagic))
 			}
-			return err
+			return ErrMagicMismatch
 		}
 	}
-	if string(signature[:]) != frameMagic {
-		if debugDecoder {
-			println("Got magic numbers: ", signature, "want:", []byte(frameMagic))
-		}
-		return ErrMagicMismatch
-	}
 
 	// Read Frame_Header_Descriptor
 	fhd, err := br.readByte()
+	if err == io.ErrUnexpectedEOF && d.o.format != FormatZstd1 {
+		// Without magic, the descriptor is the first byte of the frame.
+		return io.EOF
+	}
 	if err != nil {
 		if debugDecoder {
 			println("Reading Frame_Header_Descriptor", err)
@@ -134,11 +152,16 @@ func (d *frameDec) reset(br byteBuffer) error {
 	if fhd&(1<<3) != 0 {
 		return errors.New("reserved bit set on frame header")
 	}
+	if d.o.format == FormatMinimal && fhd&3 != 0 {
+		return errors.New("dictionary ID set on minimal frame header")
+	}
 
 	// Read Window_Descriptor
 	// https://github.com/facebook/zstd/blob/dev/doc/zstd_compression_format.md#window_descriptor
 	d.WindowSize = 0
-	if !d.SingleSegment {
+	if !d.SingleSegment && d.o.format == FormatMinimal {
+		d.WindowSize = d.o.minimalWindow
+	} else if !d.SingleSegment {
 		wd, err := br.readByte()
 		if err != nil {
 			if debugDecoder {
@@ -182,6 +205,9 @@ func (d *frameDec) reset(br byteBuffer) error {
 		}
 		d.DictionaryID = id
 	}
+	if d.o.format == FormatMinimal {
+		d.DictionaryID = d.o.minimalDictID
+	}
 
 	// Read Frame_Content_Size
 	// https://github.com/facebook/zstd/blob/dev/doc/zstd_compression_format.md#frame_content_size
@@ -220,6 +246,18 @@ func (d *frameDec) reset(br byteBuffer) error {
 		}
 	}
 
+	if d.o.stats != nil {
+		// Magic, Frame_Header_Descriptor, Window_Descriptor, Dictionary_ID and Frame_Content_Size.
+		hdrSize := 1 + [4]int{0, 1, 2, 4}[fhd&3] + fcsSize
+		if d.o.format == FormatZstd1 {
+			hdrSize += len(frameMagic)
+		}
+		if !d.SingleSegment && d.o.format != FormatMinimal {
+			hdrSize++
+		}
+		d.stats = FrameStats{Compressed: int64(hdrSize)}
+	}
+
 	// Move this to shared.
 	d.HasCheckSum = fhd&(1<<2) != 0
 	if d.HasCheckSum {
@@ -277,6 +315,52 @@ func (d *frameDec) reset(br byteBuffer) error {
 	return nil
 }
 
+// skipFrame will skip a skippable frame with the given id and content size.
+// If a callback is registered for the id, it is called with the content first.
+func (o *decoderOptions) skipFrame(br byteBuffer, id uint8, size uint32) error {
+	fn := o.skippableCB[id]
+	if fn == nil {
+		return br.skipN(int64(size))
+	}
+	r := skippableReader{br: br, n: int64(size)}
+	if err := fn(&r); err != nil {
+		return err
+	}
+	if r.err != nil {
+		return r.err
+	}
+	return br.skipN(r.n)
+}
+
+// skippableReader reads the content of a skippable frame.
+type skippableReader struct {
+	br  byteBuffer
+	n   int64
+	err error
+}
+
+func (r *skippableReader) Read(p []byte) (int, error) {
+	if r.err != nil {
+		return 0, r.err
+	}
+	if r.n == 0 {
+		return 0, io.EOF
+	}
+	if len(p) == 0 {
+		return 0, nil
+	}
+	b, err := r.br.readBig(int(min(int64(len(p)), r.n)), p)
+	n := copy(p, b)
+	r.n -= int64(n)
+	if err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		r.err = err
+	}
+	return n, err
+}
+
 // next will start decoding the next block from stream.
 func (d *frameDec) next(block *blockDec) error {
 	if debugDecoder {
@@ -292,6 +376,30 @@ func (d *frameDec) next(block *blockDec) error {
 	return nil
 }
 
+// addStats will add the statistics of a decoded block to the current frame.
+func (d *frameDec) addStats(block *blockDec) {
+	if d.o.stats != nil {
+		d.stats.add(&block.stats)
+	}
+}
+
+// sendStats will send the statistics of the current frame
+// to the function set with WithDecoderStats.
+func (d *frameDec) sendStats(uncompressed uint64) {
+	if d.o.stats == nil {
+		return
+	}
+	d.stats.Uncompressed = int64(uncompressed)
+	if d.HasCheckSum {
+		d.stats.Compressed += 4
+	}
+	if d.statsOut != nil {
+		*d.statsOut = d.stats
+		return
+	}
+	d.o.stats(&d.stats)
+}
+
 // checkCRC will check the checksum, assuming the frame has one.
 // Will return ErrCRCMismatch if crc check failed, otherwise nil.
 func (d *frameDec) checkCRC() error {
@@ -327,7 +435,7 @@ func (d *frameDec) consumeCRC() error {
 }
 
 // runDecoder will run the decoder for the remainder of the frame.
-func (d *frameDec) runDecoder(dst []byte, dec *blockDec) ([]byte, error) {
+func (d *frameDec) runDecoder(ctx context.Context, dst []byte, dec *blockDec) ([]byte, error) {
 	saved := d.history.b
 
 	// We use the history for output to avoid copying it.
@@ -361,6 +469,9 @@ func (d *frameDec) runDecoder(dst []byte, dec *blockDec) ([]byte, error) {
 	}
 	var err error
 	for {
+		if err = ctx.Err(); err != nil {
+			break
+		}
 		err = dec.reset(d.rawInput, d.WindowSize)
 		if err != nil {
 			break
@@ -372,6 +483,7 @@ func (d *frameDec) runDecoder(dst []byte, dec *blockDec) ([]byte, error) {
 		if err != nil {
 			break
 		}
+		d.addStats(dec)
 		if uint64(len(d.history.b)-crcStart) > d.o.maxDecodedSize {
 			println("runDecoder: maxDecodedSize exceeded", uint64(len(d.history.b)-crcStart), ">", d.o.maxDecodedSize)
 			err = ErrDecoderSizeExceeded
@@ -406,6 +518,9 @@ func (d *frameDec) runDecoder(dst []byte, dec *blockDec) ([]byte, error) {
 				err = d.checkCRC()
 			}
 		}
+		if err == nil {
+			d.sendStats(uint64(len(dst) - crcStart))
+		}
 	}
 	d.history.b = saved
 	return dst, err
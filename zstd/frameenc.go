@@ -12,18 +12,46 @@ import (
 	"math/bits"
 )
 
+// FrameFormat is the format of the frames written by an Encoder
+// and read by a Decoder.
+type FrameFormat uint8
+
+const (
+	// FormatZstd1 is the standard frame format. This is the default.
+	FormatZstd1 FrameFormat = iota
+
+	// FormatMagicless is the standard frame format without the 4 byte magic number,
+	// similar to ZSTD_f_zstd1_magicless in the reference implementation.
+	// Skippable frames cannot be used.
+	FormatMagicless
+
+	// FormatMinimal is FormatMagicless, where the window size and dictionary ID are not stored.
+	// The decoder must be configured with the window size and dictionary ID used by the encoder.
+	// This format cannot be read by other implementations.
+	FormatMinimal
+)
+
 type frameHeader struct {
 	ContentSize   uint64
 	WindowSize    uint32
 	SingleSegment bool
 	Checksum      bool
 	DictID        uint32
+
+	// Format of the header.
+	// With FormatMinimal WindowSize and DictID are not written.
+	Format FrameFormat
 }
 
 const maxHeaderSize = 14
 
 func (f frameHeader) appendTo(dst []byte) []byte {
-	dst = append(dst, frameMagic...)
+	if f.Format == FormatZstd1 {
+		dst = append(dst, frameMagic...)
+	}
+	if f.Format == FormatMinimal {
+		f.DictID = 0
+	}
 	var fhd uint8
 	if f.Checksum {
 		fhd |= 1 << 2
@@ -63,7 +91,7 @@ func (f frameHeader) appendTo(dst []byte) []byte {
 	fhd |= fcs << 6
 
 	dst = append(dst, fhd)
-	if !f.SingleSegment {
+	if !f.SingleSegment && f.Format != FormatMinimal {
 		const winLogMin = 10
 		windowLog := (bits.Len32(f.WindowSize-1) - winLogMin) << 3
 		dst = append(dst, uint8(windowLog))
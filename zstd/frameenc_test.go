@@ -0,0 +1,443 @@
+package zstd
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sync/atomic"
+	"testing"
+)
+
+func TestFrameFormat(t *testing.T) {
+	text, err := os.ReadFile("../testdata/Mark.Twain-Tom.Sawyer.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	dict, err := BuildDict(BuildDictOptions{ID: 1234, Contents: [][]byte{text[:50000], text[50000:100000]}, History: text[:8000], Offsets: [3]int{1, 4, 8}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	inputs := [][]byte{{}, []byte("hello"), text[:2000], text}
+
+	for _, format := range []FrameFormat{FormatMagicless, FormatMinimal} {
+		for _, withDict := range []bool{false, true} {
+			t.Run(fmt.Sprintf("format-%d-dict-%v", format, withDict), func(t *testing.T) {
+				eOpts := []EOption{WithZeroFrames(true), WithWindowSize(1 << 20)}
+				var dOpts []DOption
+				var dictID uint32
+				if withDict {
+					eOpts = append(eOpts, WithEncoderDict(dict))
+					dOpts = append(dOpts, WithDecoderDicts(dict))
+					dictID = 1234
+				}
+				std, err := NewWriter(nil, eOpts...)
+				if err != nil {
+					t.Fatal(err)
+				}
+				defer std.Close()
+				enc, err := NewWriter(nil, append(eOpts, WithEncoderFormat(format))...)
+				if err != nil {
+					t.Fatal(err)
+				}
+				defer enc.Close()
+				if format == FormatMinimal {
+					dOpts = append(dOpts, WithDecoderMinimalHeader(1<<20, dictID))
+				} else {
+					dOpts = append(dOpts, WithDecoderFormat(format))
+				}
+				dec, err := NewReader(nil, dOpts...)
+				if err != nil {
+					t.Fatal(err)
+				}
+				defer dec.Close()
+
+				var all, allWant []byte
+				for _, in := range inputs {
+					want := std.EncodeAll(in, nil)
+					got := enc.EncodeAll(in, nil)
+					all = append(all, got...)
+					allWant = append(allWant, in...)
+
+					// Compare with the standard frame header.
+					var h Header
+					rest, err := h.DecodeAndStrip(want)
+					if err != nil {
+						t.Fatal(err)
+					}
+					if !bytes.HasSuffix(got, rest) {
+						t.Fatalf("%d bytes: frame content differs from standard frame", len(in))
+					}
+					hdr := got[:len(got)-len(rest)]
+					switch format {
+					case FormatMagicless:
+						if !bytes.Equal(hdr, want[4:len(want)-len(rest)]) {
+							t.Fatalf("%d bytes: header %x, want %x", len(in), hdr, want[4:len(want)-len(rest)])
+						}
+					case FormatMinimal:
+						// Only Frame_Header_Descriptor and Frame_Content_Size remain.
+						fcs := [4]int{0, 2, 4, 8}[hdr[0]>>6]
+						if hdr[0]&(1<<5) != 0 && fcs == 0 {
+							fcs = 1
+						}
+						if len(hdr) != 1+fcs || hdr[0]&3 != 0 {
+							t.Fatalf("%d bytes: unexpected minimal header %x", len(in), hdr)
+						}
+					}
+
+					dst, err := dec.DecodeAll(got, nil)
+					if err != nil {
+						t.Fatal(err)
+					}
+					if !bytes.Equal(dst, in) {
+						t.Fatalf("%d bytes: output mismatch", len(in))
+					}
+				}
+
+				// Streams of several frames.
+				var buf bytes.Buffer
+				enc.Reset(&buf)
+				if _, err := enc.Write(text); err != nil {
+					t.Fatal(err)
+				}
+				if err := enc.Close(); err != nil {
+					t.Fatal(err)
+				}
+				all = append(all, buf.Bytes()...)
+				allWant = append(allWant, text...)
+				var stdBuf bytes.Buffer
+				std.Reset(&stdBuf)
+				if _, err := std.Write(text); err != nil {
+					t.Fatal(err)
+				}
+				if err := std.Close(); err != nil {
+					t.Fatal(err)
+				}
+				// Streams have a window size, which is not stored in minimal headers.
+				removed := len(frameMagic)
+				if format == FormatMinimal {
+					removed++
+					if withDict {
+						removed += 2
+					}
+				}
+				if got := stdBuf.Len() - buf.Len(); got != removed {
+					t.Errorf("stream is %d bytes smaller than standard stream, want %d", got, removed)
+				}
+				dst, err := dec.DecodeAll(all, nil)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if !bytes.Equal(dst, allWant) {
+					t.Fatal("DecodeAll output mismatch")
+				}
+				if err := dec.Reset(bytes.NewReader(all)); err != nil {
+					t.Fatal(err)
+				}
+				dst, err = io.ReadAll(dec)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if !bytes.Equal(dst, allWant) {
+					t.Fatal("stream output mismatch")
+				}
+
+				// Standard frames must be rejected.
+				if _, err := dec.DecodeAll(std.EncodeAll(text, nil), nil); err == nil {
+					t.Error("no error decoding standard frame")
+				}
+			})
+		}
+	}
+
+	// Frames without magic can be read as standard frames by adding it.
+	enc, err := NewWriter(nil, WithEncoderFormat(FormatMagicless))
+	if err != nil {
+		t.Fatal(err)
+	}
+	dec, err := NewReader(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer dec.Close()
+	got, err := dec.DecodeAll(append([]byte(frameMagic), enc.EncodeAll(text, nil)...), nil)
+	if err != nil || !bytes.Equal(got, text) {
+		t.Fatal("magicless frame with magic added failed:", err)
+	}
+
+	// Recovery cannot find frames without magic, so the rest of the input is skipped.
+	frame := enc.EncodeAll(text[:5000], nil)
+	corrupt := append(append([]byte{}, frame...), frame...)
+	corrupt = append(corrupt, frame...)
+	corrupt[len(frame)+20] ^= 0xff
+	var skipped int64
+	rdec, err := NewReader(nil, WithDecoderFormat(FormatMagicless), WithDecoderRecovery(func(err *CorruptInputError) error {
+		skipped += err.Skipped
+		return nil
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rdec.Close()
+	got, err = rdec.DecodeAll(corrupt, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.HasPrefix(got, text[:5000]) || len(got) >= 15000 || skipped == 0 {
+		t.Errorf("recovered %d bytes, skipped %d", len(got), skipped)
+	}
+
+	// A minimal frame must not contain a dictionary ID.
+	mdec, err := NewReader(nil, WithDecoderFormat(FormatMinimal))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer mdec.Close()
+	denc, err := NewWriter(nil, WithEncoderFormat(FormatMagicless), WithEncoderDict(dict))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := mdec.DecodeAll(denc.EncodeAll(text, nil), nil); err == nil {
+		t.Error("no error for dictionary ID on minimal frame")
+	}
+
+	if _, err := NewWriter(nil, WithEncoderFormat(FormatMagicless), WithEncoderPadding(1024)); err == nil {
+		t.Error("no error for padding without magic")
+	}
+	if _, err := NewWriter(nil, WithEncoderFormat(FormatMinimal+1)); err == nil {
+		t.Error("no error for unknown encoder format")
+	}
+	if _, err := NewReader(nil, WithDecoderFormat(FormatMinimal+1)); err == nil {
+		t.Error("no error for unknown decoder format")
+	}
+	if _, err := NewReader(nil, WithDecoderMinimalHeader(MinWindowSize-1, 0)); err == nil {
+		t.Error("no error for too small window")
+	}
+	if _, err := NewSeekableWriter(io.Discard, enc, 1024); err == nil {
+		t.Error("no error for seekable writer without magic")
+	}
+}
+
+func TestSkippableFrames(t *testing.T) {
+	text, err := os.ReadFile("../testdata/Mark.Twain-Tom.Sawyer.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	part1, part2 := text[:300000], text[300000:]
+	schema, stamp := []byte("schema-v7"), []byte("2025-01-02T03:04:05Z")
+
+	for i, opts := range [][]EOption{
+		{WithEncoderConcurrency(1)},
+		{WithEncoderConcurrency(4)},
+		{WithEncoderConcurrency(4), WithEncoderJobSize(64 << 10)},
+		{WithEncoderPadding(4096)},
+	} {
+		t.Run(fmt.Sprint(i), func(t *testing.T) {
+			var buf bytes.Buffer
+			enc, err := NewWriter(&buf, opts...)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := enc.WriteSkippableFrame(1, schema); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := enc.Write(part1); err != nil {
+				t.Fatal(err)
+			}
+			if err := enc.WriteSkippableFrame(2, stamp); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := enc.Write(part2); err != nil {
+				t.Fatal(err)
+			}
+			if err := enc.Close(); err != nil {
+				t.Fatal(err)
+			}
+			if err := enc.WriteSkippableFrame(1, schema); err != ErrEncoderClosed {
+				t.Errorf("got %v, want ErrEncoderClosed", err)
+			}
+			compressed := buf.Bytes()
+
+			var kinds []string
+			err = WalkFrames(bytes.NewReader(compressed), func(f *FrameInfo) error {
+				if f.Header.Skippable {
+					kinds = append(kinds, fmt.Sprintf("skippable-%d", f.Header.SkippableID))
+				} else {
+					kinds = append(kinds, "frame")
+				}
+				return nil
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			want := []string{"skippable-1", "frame", "skippable-2", "frame"}
+			if len(kinds) > 0 && kinds[len(kinds)-1] == "skippable-0" {
+				// Padding.
+				kinds = kinds[:len(kinds)-1]
+				if len(compressed)%4096 != 0 {
+					t.Errorf("padded size %d", len(compressed))
+				}
+			}
+			if fmt.Sprint(kinds) != fmt.Sprint(want) {
+				t.Fatalf("got frames %v, want %v", kinds, want)
+			}
+
+			// Without callbacks, skippable frames are ignored.
+			dec, err := NewReader(nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer dec.Close()
+			got, err := dec.DecodeAll(compressed, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(got, text) {
+				t.Fatal("output mismatch")
+			}
+		})
+	}
+
+	var buf bytes.Buffer
+	enc, err := NewWriter(&buf, WithEncoderConcurrency(1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := enc.WriteSkippableFrame(1, schema); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := enc.Write(part1); err != nil {
+		t.Fatal(err)
+	}
+	if err := enc.WriteSkippableFrame(2, stamp); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := enc.Write(part2); err != nil {
+		t.Fatal(err)
+	}
+	if err := enc.Close(); err != nil {
+		t.Fatal(err)
+	}
+	compressed := buf.Bytes()
+
+	type frame struct {
+		id     int
+		data   string
+		output int
+	}
+	for _, opts := range [][]DOption{
+		{WithDecoderConcurrency(1)},
+		{WithDecoderConcurrency(4)},
+		{WithDecoderConcurrency(4), WithDecoderParallelFrames(1 << 20)},
+		{WithDecoderRecovery(nil)},
+	} {
+		var frames []frame
+		var output atomic.Int64
+		cb := func(id int) func(r io.Reader) error {
+			return func(r io.Reader) error {
+				b, err := io.ReadAll(r)
+				frames = append(frames, frame{id: id, data: string(b), output: int(output.Load())})
+				return err
+			}
+		}
+		dec, err := NewReader(nil, append(opts, WithDecoderSkippableCB(1, cb(1)), WithDecoderSkippableCB(2, cb(2)))...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer dec.Close()
+		got, err := dec.DecodeAll(compressed, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(got, text) {
+			t.Fatal("DecodeAll output mismatch")
+		}
+		want := []frame{{id: 1, data: string(schema)}, {id: 2, data: string(stamp)}}
+		if fmt.Sprint(frames) != fmt.Sprint(want) {
+			t.Fatalf("DecodeAll: got skippable frames %v, want %v", frames, want)
+		}
+
+		frames = frames[:0]
+		if err := dec.Reset(bytes.NewReader(compressed)); err != nil {
+			t.Fatal(err)
+		}
+		got = got[:0]
+		tmp := make([]byte, 1000)
+		for {
+			n, err := dec.Read(tmp)
+			got = append(got, tmp[:n]...)
+			output.Add(int64(n))
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+		if !bytes.Equal(got, text) {
+			t.Fatal("stream output mismatch")
+		}
+		if len(frames) != 2 || frames[0].data != string(schema) || frames[1].data != string(stamp) {
+			t.Fatalf("stream: got skippable frames %v", frames)
+		}
+		// Without concurrency, frames are read when the output before them has been returned.
+		if dec.o.concurrent == 1 && frames[1].output != len(part1) {
+			t.Errorf("callback called after %d bytes of output, want %d", frames[1].output, len(part1))
+		}
+	}
+
+	// Errors from the callback abort decoding, and unread content is skipped.
+	errStop := errors.New("stop")
+	for _, opts := range [][]DOption{{WithDecoderConcurrency(1)}, {WithDecoderRecovery(nil)}} {
+		dec, err := NewReader(nil, append(opts, WithDecoderSkippableCB(2, func(r io.Reader) error {
+			return errStop
+		}))...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := dec.DecodeAll(compressed, nil); err != errStop {
+			t.Errorf("got %v, want %v", err, errStop)
+		}
+		dec.Close()
+	}
+	dec, err := NewReader(nil, WithDecoderSkippableCB(1, func(r io.Reader) error {
+		var b [2]byte
+		_, err := io.ReadFull(r, b[:])
+		return err
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer dec.Close()
+	if got, err := dec.DecodeAll(compressed, nil); err != nil || !bytes.Equal(got, text) {
+		t.Errorf("partially read skippable frame: %v", err)
+	}
+
+	// A stream with only a skippable frame.
+	buf.Reset()
+	enc.Reset(&buf)
+	if err := enc.WriteSkippableFrame(15, nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := enc.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if want := []byte{0x5f, 0x2a, 0x4d, 0x18, 0, 0, 0, 0}; !bytes.Equal(buf.Bytes(), want) {
+		t.Errorf("got %x, want %x", buf.Bytes(), want)
+	}
+
+	if err := enc.WriteSkippableFrame(16, nil); err == nil {
+		t.Error("no error for id 16")
+	}
+	if _, err := NewReader(nil, WithDecoderSkippableCB(16, nil)); err == nil {
+		t.Error("no error for callback id 16")
+	}
+	menc, err := NewWriter(io.Discard, WithEncoderFormat(FormatMagicless))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := menc.WriteSkippableFrame(0, nil); err == nil {
+		t.Error("no error for skippable frame without magic")
+	}
+}
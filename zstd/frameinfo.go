@@ -0,0 +1,166 @@
+// Copyright 2025+ Klaus Post. All rights reserved.
+// License information can be found in the LICENSE file.
+
+package zstd
+
+import (
+	"bufio"
+	"encoding/binary"
+	"io"
+)
+
+// FrameInfo contains information about a frame.
+type FrameInfo struct {
+	// Offset of the frame in the input.
+	Offset int64
+
+	// Size of the frame in the input, including headers and checksum.
+	Size int64
+
+	// Header of the frame.
+	// For skippable frames only the skippable fields are set.
+	Header Header
+
+	// Blocks of the frame. Empty for skippable frames.
+	Blocks []BlockInfo
+
+	// CheckSum is the stored checksum if Header.HasCheckSum is set.
+	// This is the lower 32 bits of the XXH64 of the decompressed content.
+	CheckSum uint32
+}
+
+// BlockInfo contains information about a block.
+type BlockInfo struct {
+	// Offset of the block header in the input.
+	Offset int64
+
+	// Type of the block.
+	Type BlockType
+
+	// Last is set if this is the last block of the frame.
+	Last bool
+
+	// CompressedSize is the size of the block content in the input.
+	// Does not include the 3 byte block header.
+	CompressedSize int
+
+	// DecompressedSize is the decompressed size of the block.
+	// Will be -1 for compressed blocks, since that cannot be determined without decoding.
+	DecompressedSize int
+}
+
+// DecompressedSize returns the decompressed size of the frame.
+// If the size is not stored in the header, it is calculated from the blocks.
+// If that isn't possible, -1 is returned.
+// Skippable frames return 0.
+func (f *FrameInfo) DecompressedSize() int64 {
+	if f.Header.Skippable {
+		return 0
+	}
+	if f.Header.HasFCS {
+		return int64(f.Header.FrameContentSize)
+	}
+	var n int64
+	for _, b := range f.Blocks {
+		if b.DecompressedSize < 0 {
+			return -1
+		}
+		n += int64(b.DecompressedSize)
+	}
+	return n
+}
+
+// WalkFrames will read all frames from r and call fn with information about each frame,
+// including skippable frames, in order, similar to "zstd -lv".
+// Only headers are decoded, so content and checksums are not validated.
+// The frame is only valid until fn returns.
+// If fn returns an error, reading is stopped and the error is returned.
+// If r is empty, fn is not called and nil is returned.
+func WalkFrames(r io.Reader, fn func(f *FrameInfo) error) error {
+	br := bufio.NewReaderSize(r, 64<<10)
+	var f FrameInfo
+	var offset int64
+	skip := func(n int) error {
+		got, err := br.Discard(n)
+		offset += int64(got)
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return err
+	}
+	for {
+		hdr, err := br.Peek(HeaderMaxSize)
+		if len(hdr) == 0 && err == io.EOF {
+			return nil
+		}
+		if err != nil && err != io.EOF {
+			return err
+		}
+		f = FrameInfo{Offset: offset, Blocks: f.Blocks[:0]}
+		if _, err := f.Header.DecodeAndStrip(hdr); err != nil {
+			return err
+		}
+		if err := skip(f.Header.HeaderSize); err != nil {
+			return err
+		}
+		if f.Header.Skippable {
+			if err := skip(int(f.Header.SkippableSize)); err != nil {
+				return err
+			}
+		} else {
+			for {
+				var tmp [3]byte
+				if _, err := io.ReadFull(br, tmp[:]); err != nil {
+					if err == io.EOF {
+						err = io.ErrUnexpectedEOF
+					}
+					return err
+				}
+				bh := uint32(tmp[0]) | (uint32(tmp[1]) << 8) | (uint32(tmp[2]) << 16)
+				b := BlockInfo{
+					Offset:         offset,
+					Type:           BlockType((bh >> 1) & 3),
+					Last:           bh&1 != 0,
+					CompressedSize: int(bh >> 3),
+				}
+				offset += 3
+				switch b.Type {
+				case BlockTypeRaw:
+					b.DecompressedSize = b.CompressedSize
+				case BlockTypeRLE:
+					b.DecompressedSize = b.CompressedSize
+					b.CompressedSize = 1
+				case BlockTypeCompressed:
+					b.DecompressedSize = -1
+				default:
+					return ErrReservedBlockType
+				}
+				if b.CompressedSize > maxCompressedBlockSize || b.DecompressedSize > maxCompressedBlockSize {
+					return ErrCompressedSizeTooBig
+				}
+				if err := skip(b.CompressedSize); err != nil {
+					return err
+				}
+				f.Blocks = append(f.Blocks, b)
+				if b.Last {
+					break
+				}
+			}
+			if f.Header.HasCheckSum {
+				var tmp [4]byte
+				if _, err := io.ReadFull(br, tmp[:]); err != nil {
+					if err == io.EOF {
+						err = io.ErrUnexpectedEOF
+					}
+					return err
+				}
+				offset += 4
+				f.CheckSum = binary.LittleEndian.Uint32(tmp[:])
+			}
+		}
+		f.Size = offset - f.Offset
+		if err := fn(&f); err != nil {
+			return err
+		}
+	}
+}
@@ -0,0 +1,111 @@
+package zstd
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"os"
+	"testing"
+
+	"github.com/snissn/compress/zstd/internal/xxhash"
+)
+
+func TestWalkFrames(t *testing.T) {
+	in, err := os.ReadFile("../testdata/Mark.Twain-Tom.Sawyer.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var comp []byte
+	// Frame with content size and checksum.
+	e, err := NewWriter(nil, WithEncoderConcurrency(1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	comp = e.EncodeAll(in, comp)
+	// Stream without content size and with an RLE block.
+	var buf bytes.Buffer
+	e.Reset(&buf)
+	e.Write(in[:1000])
+	e.Flush()
+	e.Write(bytes.Repeat([]byte{0}, 1000))
+	if err := e.Close(); err != nil {
+		t.Fatal(err)
+	}
+	comp = append(comp, buf.Bytes()...)
+	// Raw dictionary without checksum, followed by padding in a skippable frame.
+	e, err = NewWriter(nil, WithEncoderDictRaw(1234, in[:10000]), WithEncoderCRC(false), WithEncoderPadding(1<<10), WithWindowSize(1<<20))
+	if err != nil {
+		t.Fatal(err)
+	}
+	comp = e.EncodeAll(in[10000:20000], comp)
+
+	var frames []FrameInfo
+	err = WalkFrames(bytes.NewReader(comp), func(f *FrameInfo) error {
+		fi := *f
+		fi.Blocks = append([]BlockInfo(nil), f.Blocks...)
+		frames = append(frames, fi)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(frames) != 4 {
+		t.Fatalf("want 4 frames, got %d", len(frames))
+	}
+	var total int64
+	for i, f := range frames {
+		if f.Offset != total {
+			t.Errorf("frame %d: offset %d, want %d", i, f.Offset, total)
+		}
+		total += f.Size
+		for _, b := range f.Blocks {
+			if b.Offset < f.Offset || b.Offset+3+int64(b.CompressedSize) > f.Offset+f.Size {
+				t.Errorf("frame %d: block outside frame: %+v", i, b)
+			}
+		}
+	}
+	if total != int64(len(comp)) {
+		t.Errorf("total size %d, want %d", total, len(comp))
+	}
+
+	f := frames[0]
+	if !f.Header.HasCheckSum || f.CheckSum != uint32(xxhash.Sum64(in)) || f.DecompressedSize() != int64(len(in)) {
+		t.Errorf("frame 0: unexpected %+v", f)
+	}
+	f = frames[1]
+	if f.Header.HasFCS || f.DecompressedSize() != -1 {
+		t.Errorf("frame 1: unexpected content size %+v", f)
+	}
+	var rle bool
+	for _, b := range f.Blocks {
+		rle = rle || b.Type == BlockTypeRLE && b.DecompressedSize == 1000
+	}
+	if !rle {
+		t.Errorf("frame 1: no RLE block: %+v", f.Blocks)
+	}
+	f = frames[2]
+	if f.Header.DictionaryID != 1234 || f.Header.HasCheckSum || f.DecompressedSize() != 10000 {
+		t.Errorf("frame 2: unexpected %+v", f)
+	}
+	if f = frames[3]; !f.Header.Skippable {
+		t.Errorf("frame 3: not skippable %+v", f)
+	}
+
+	// Truncated input.
+	for _, n := range []int{len(comp) - 1, int(frames[1].Offset) + 5, int(frames[3].Offset) + 2} {
+		err = WalkFrames(bytes.NewReader(comp[:n]), func(f *FrameInfo) error { return nil })
+		if !errors.Is(err, io.ErrUnexpectedEOF) {
+			t.Errorf("truncated at %d: want io.ErrUnexpectedEOF, got %v", n, err)
+		}
+	}
+	// Stop early.
+	errStop := errors.New("stop")
+	var n int
+	err = WalkFrames(bytes.NewReader(comp), func(f *FrameInfo) error {
+		n++
+		return errStop
+	})
+	if err != errStop || n != 1 {
+		t.Errorf("want errStop after 1 frame, got %v after %d", err, n)
+	}
+}
@@ -0,0 +1,394 @@
+// Copyright 2025+ Klaus Post. All rights reserved.
+// License information can be found in the LICENSE file.
+
+package zstd
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"sync"
+
+	"github.com/snissn/compress/zstd/internal/xxhash"
+)
+
+// Seekable format constants.
+// See https://github.com/facebook/zstd/blob/dev/contrib/seekable_format/zstd_seekable_compression_format.md
+const (
+	// SeekableMaxFrameSize is the maximum decompressed size of a single frame
+	// in the seekable format.
+	SeekableMaxFrameSize = 1 << 30
+
+	// seekableMaxFrames is the maximum number of frames in a seek table.
+	seekableMaxFrames = 0x8000000
+
+	seekTableMagic       = 0x8F92EAB1
+	seekTableFooterSize  = 9
+	seekTableSkippableID = 0xE
+)
+
+// ErrSeekTableInvalid is returned when the seek table of a seekable
+// stream cannot be found or is corrupt.
+var ErrSeekTableInvalid = errors.New("invalid input: seek table not found or corrupt")
+
+type seekTableEntry struct {
+	// Compressed and decompressed offset of the frame start.
+	cOffset, dOffset int64
+	cSize, dSize     uint32
+	checksum         uint32
+}
+
+// SeekableWriter writes a stream in the Zstandard Seekable Format.
+// Input is split into independent frames of a fixed decompressed size,
+// and a seek table is appended as a skippable frame when the writer is closed.
+// The output can be decoded as a regular zstd stream by any decoder,
+// or randomly accessed with a SeekableReader.
+type SeekableWriter struct {
+	enc       *Encoder
+	w         io.Writer
+	frameSize int
+	filling   []byte
+	out       []byte
+	entries   []seekTableEntry
+	cOffset   int64
+	dOffset   int64
+	err       error
+}
+
+// NewSeekableWriter returns a writer that compresses frames of frameSize
+// decompressed bytes with the supplied encoder, and writes them to w.
+// The encoder is only used through EncodeAll, so it may be shared with other users.
+// frameSize must be between 1 and SeekableMaxFrameSize.
+// Smaller frames give faster random access at the expense of compression.
+func NewSeekableWriter(w io.Writer, enc *Encoder, frameSize int) (*SeekableWriter, error) {
+	if enc == nil {
+		return nil, errors.New("zstd: nil encoder")
+	}
+	if enc.o.format != FormatZstd1 {
+		return nil, errors.New("zstd: seekable format requires frame magic")
+	}
+	if frameSize <= 0 || frameSize > SeekableMaxFrameSize {
+		return nil, fmt.Errorf("zstd: seekable frame size must be between 1 and %d", SeekableMaxFrameSize)
+	}
+	s := &SeekableWriter{enc: enc, frameSize: frameSize}
+	s.Reset(w)
+	return s, nil
+}
+
+// Reset will discard any buffered state and start a new seekable stream
+// that will be written to w.
+func (s *SeekableWriter) Reset(w io.Writer) {
+	s.w = w
+	s.filling = s.filling[:0]
+	s.entries = s.entries[:0]
+	s.cOffset = 0
+	s.dOffset = 0
+	s.err = nil
+}
+
+// Write buffers p and writes completed frames to the output.
+func (s *SeekableWriter) Write(p []byte) (n int, err error) {
+	if s.err != nil {
+		return 0, s.err
+	}
+	for len(p) > 0 {
+		if cap(s.filling) < s.frameSize && len(s.filling)+len(p) >= s.frameSize {
+			s.filling = append(make([]byte, 0, s.frameSize), s.filling...)
+		}
+		add := min(len(p), s.frameSize-len(s.filling))
+		s.filling = append(s.filling, p[:add]...)
+		p = p[add:]
+		n += add
+		if len(s.filling) == s.frameSize {
+			if err := s.writeFrame(); err != nil {
+				return n, err
+			}
+		}
+	}
+	return n, nil
+}
+
+// Flush ends the current frame, if any, and writes it to the output.
+// Flushing often will reduce compression.
+func (s *SeekableWriter) Flush() error {
+	if s.err != nil {
+		return s.err
+	}
+	if len(s.filling) == 0 {
+		return nil
+	}
+	return s.writeFrame()
+}
+
+// Close flushes any remaining content and writes the seek table.
+// The writer can be reused after calling Reset.
+func (s *SeekableWriter) Close() error {
+	if err := s.Flush(); err != nil {
+		return err
+	}
+	s.out = appendSeekTable(s.out[:0], s.entries)
+	_, s.err = s.w.Write(s.out)
+	if s.err != nil {
+		return s.err
+	}
+	s.err = ErrEncoderClosed
+	return nil
+}
+
+func (s *SeekableWriter) writeFrame() error {
+	if len(s.entries) >= seekableMaxFrames {
+		s.err = errors.New("zstd: seekable frame limit exceeded")
+		return s.err
+	}
+	s.out = s.enc.EncodeAll(s.filling, s.out[:0])
+	if int64(len(s.out)) > 0xffffffff {
+		s.err = errors.New("zstd: seekable compressed frame too large")
+		return s.err
+	}
+	e := seekTableEntry{
+		cOffset:  s.cOffset,
+		dOffset:  s.dOffset,
+		cSize:    uint32(len(s.out)),
+		dSize:    uint32(len(s.filling)),
+		checksum: uint32(xxhash.Sum64(s.filling)),
+	}
+	_, s.err = s.w.Write(s.out)
+	if s.err != nil {
+		return s.err
+	}
+	s.entries = append(s.entries, e)
+	s.cOffset += int64(e.cSize)
+	s.dOffset += int64(e.dSize)
+	s.filling = s.filling[:0]
+	return nil
+}
+
+// appendSeekTable appends a seek table skippable frame with checksums.
+func appendSeekTable(dst []byte, entries []seekTableEntry) []byte {
+	const entrySize = 12
+	size := uint32(len(entries)*entrySize + seekTableFooterSize)
+	dst = append(dst, 0x50|seekTableSkippableID, 0x2a, 0x4d, 0x18)
+	dst = binary.LittleEndian.AppendUint32(dst, size)
+	for _, e := range entries {
+		dst = binary.LittleEndian.AppendUint32(dst, e.cSize)
+		dst = binary.LittleEndian.AppendUint32(dst, e.dSize)
+		dst = binary.LittleEndian.AppendUint32(dst, e.checksum)
+	}
+	dst = binary.LittleEndian.AppendUint32(dst, uint32(len(entries)))
+	// Checksum_Flag set.
+	dst = append(dst, 1<<7)
+	return binary.LittleEndian.AppendUint32(dst, seekTableMagic)
+}
+
+// readSeekTable reads the seek table from the end of r, which is size bytes long.
+// It also returns whether the table contains checksums.
+func readSeekTable(r io.ReaderAt, size int64) (entries []seekTableEntry, hasChecksum bool, err error) {
+	if size < skippableFrameHeader+seekTableFooterSize {
+		return nil, false, ErrSeekTableInvalid
+	}
+	var footer [seekTableFooterSize]byte
+	if _, err := r.ReadAt(footer[:], size-seekTableFooterSize); err != nil {
+		return nil, false, err
+	}
+	if binary.LittleEndian.Uint32(footer[5:]) != seekTableMagic {
+		return nil, false, ErrSeekTableInvalid
+	}
+	desc := footer[4]
+	if desc&0x7c != 0 {
+		// Reserved bits set.
+		return nil, false, ErrSeekTableInvalid
+	}
+	nFrames := int64(binary.LittleEndian.Uint32(footer[:4]))
+	if nFrames > seekableMaxFrames {
+		return nil, false, ErrSeekTableInvalid
+	}
+	entrySize := int64(8)
+	hasChecksum = desc&(1<<7) != 0
+	if hasChecksum {
+		entrySize = 12
+	}
+	tableSize := nFrames*entrySize + seekTableFooterSize
+	if tableSize+skippableFrameHeader > size {
+		return nil, false, ErrSeekTableInvalid
+	}
+	table := make([]byte, tableSize+skippableFrameHeader-seekTableFooterSize)
+	if _, err := r.ReadAt(table, size-tableSize-skippableFrameHeader); err != nil {
+		return nil, false, err
+	}
+	if table[0] != 0x50|seekTableSkippableID || string(table[1:4]) != skippableFrameMagic {
+		return nil, false, ErrSeekTableInvalid
+	}
+	if int64(binary.LittleEndian.Uint32(table[4:8])) != tableSize {
+		return nil, false, ErrSeekTableInvalid
+	}
+	table = table[skippableFrameHeader:]
+
+	entries = make([]seekTableEntry, nFrames)
+	var cOff, dOff int64
+	for i := range entries {
+		e := &entries[i]
+		e.cOffset = cOff
+		e.dOffset = dOff
+		e.cSize = binary.LittleEndian.Uint32(table)
+		e.dSize = binary.LittleEndian.Uint32(table[4:])
+		if hasChecksum {
+			e.checksum = binary.LittleEndian.Uint32(table[8:])
+		}
+		table = table[entrySize:]
+		cOff += int64(e.cSize)
+		dOff += int64(e.dSize)
+	}
+	if cOff+tableSize+skippableFrameHeader > size {
+		return nil, false, ErrSeekTableInvalid
+	}
+	return entries, hasChecksum, nil
+}
+
+// SeekableReader provides random access to a stream in the Zstandard Seekable Format.
+// It implements io.ReadSeeker and io.ReaderAt.
+// ReadAt may be called concurrently, but Read and Seek should not be called
+// concurrently with other calls.
+type SeekableReader struct {
+	r           io.ReaderAt
+	dec         *Decoder
+	frames      []seekTableEntry
+	hasChecksum bool
+	size        int64
+
+	// Current position for Read and Seek.
+	pos int64
+
+	mu sync.Mutex
+	// Most recently decoded frame.
+	cached   int
+	cacheBuf []byte
+	cBuf     []byte
+}
+
+// NewSeekableReader reads the seek table from r, which must contain size bytes,
+// and returns a reader for the decompressed content.
+// Frames are decompressed with dec using DecodeAll, so the decoder may be shared.
+// Dictionaries registered on the decoder will be used.
+func NewSeekableReader(r io.ReaderAt, size int64, dec *Decoder) (*SeekableReader, error) {
+	if dec == nil {
+		return nil, errors.New("zstd: nil decoder")
+	}
+	frames, hasChecksum, err := readSeekTable(r, size)
+	if err != nil {
+		return nil, err
+	}
+	s := &SeekableReader{r: r, dec: dec, frames: frames, hasChecksum: hasChecksum, cached: -1}
+	if n := len(frames); n > 0 {
+		s.size = frames[n-1].dOffset + int64(frames[n-1].dSize)
+	}
+	return s, nil
+}
+
+// Size returns the total decompressed size of the stream.
+func (s *SeekableReader) Size() int64 {
+	return s.size
+}
+
+// NumFrames returns the number of frames in the stream.
+func (s *SeekableReader) NumFrames() int {
+	return len(s.frames)
+}
+
+// Read reads decompressed data from the current position.
+func (s *SeekableReader) Read(p []byte) (n int, err error) {
+	if s.pos >= s.size {
+		return 0, io.EOF
+	}
+	n, err = s.ReadAt(p, s.pos)
+	s.pos += int64(n)
+	if err == io.EOF && n > 0 {
+		err = nil
+	}
+	return n, err
+}
+
+// Seek sets the position of the next Read.
+// Seeking beyond the end is allowed, but reads will return io.EOF.
+func (s *SeekableReader) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekStart:
+	case io.SeekCurrent:
+		offset += s.pos
+	case io.SeekEnd:
+		offset += s.size
+	default:
+		return s.pos, errors.New("zstd: invalid whence")
+	}
+	if offset < 0 {
+		return s.pos, errors.New("zstd: seek before start of stream")
+	}
+	s.pos = offset
+	return s.pos, nil
+}
+
+// ReadAt reads len(p) decompressed bytes starting at offset off.
+// Only the frames that cover the requested range are decompressed.
+func (s *SeekableReader) ReadAt(p []byte, off int64) (n int, err error) {
+	if off < 0 {
+		return 0, errors.New("zstd: negative offset")
+	}
+	if off >= s.size {
+		return 0, io.EOF
+	}
+	// Find the first frame that ends after off.
+	idx := sort.Search(len(s.frames), func(i int) bool {
+		f := s.frames[i]
+		return f.dOffset+int64(f.dSize) > off
+	})
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for n < len(p) && idx < len(s.frames) {
+		b, err := s.decodeFrame(idx)
+		if err != nil {
+			return n, err
+		}
+		f := s.frames[idx]
+		n += copy(p[n:], b[off+int64(n)-f.dOffset:])
+		idx++
+	}
+	if n < len(p) {
+		return n, io.EOF
+	}
+	return n, nil
+}
+
+// decodeFrame returns the decompressed content of frame idx.
+// The returned slice is only valid until the next call.
+// s.mu must be held.
+func (s *SeekableReader) decodeFrame(idx int) ([]byte, error) {
+	if s.cached == idx {
+		return s.cacheBuf, nil
+	}
+	f := s.frames[idx]
+	if cap(s.cBuf) < int(f.cSize) {
+		s.cBuf = make([]byte, f.cSize)
+	}
+	s.cBuf = s.cBuf[:f.cSize]
+	if _, err := s.r.ReadAt(s.cBuf, f.cOffset); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, err
+	}
+	s.cached = -1
+	var err error
+	s.cacheBuf, err = s.dec.DecodeAll(s.cBuf, s.cacheBuf[:0])
+	if err != nil {
+		return nil, err
+	}
+	if len(s.cacheBuf) != int(f.dSize) {
+		return nil, ErrFrameSizeMismatch
+	}
+	if s.hasChecksum && uint32(xxhash.Sum64(s.cacheBuf)) != f.checksum {
+		return nil, ErrCRCMismatch
+	}
+	s.cached = idx
+	return s.cacheBuf, nil
+}
@@ -0,0 +1,170 @@
+package zstd
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"math/rand"
+	"testing"
+)
+
+func TestSeekableRoundTrip(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	input := make([]byte, 1<<20)
+	for i := range input {
+		input[i] = byte(rng.Intn(16))
+	}
+	enc, err := NewWriter(nil, WithEncoderConcurrency(2))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer enc.Close()
+	dec, err := NewReader(nil, WithDecoderConcurrency(2))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer dec.Close()
+
+	for _, frameSize := range []int{1, 1000, 64 << 10, 2 << 20} {
+		var buf bytes.Buffer
+		w, err := NewSeekableWriter(&buf, enc, frameSize)
+		if err != nil {
+			t.Fatal(err)
+		}
+		src := input
+		if frameSize == 1 {
+			src = input[:5000]
+		}
+		for rem := src; len(rem) > 0; {
+			n := min(len(rem), 1+rng.Intn(100000))
+			if _, err := w.Write(rem[:n]); err != nil {
+				t.Fatal(err)
+			}
+			rem = rem[n:]
+		}
+		if err := w.Close(); err != nil {
+			t.Fatal(err)
+		}
+
+		// Regular decoders must be able to read the stream.
+		got, err := dec.DecodeAll(buf.Bytes(), nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(got, src) {
+			t.Fatalf("frameSize %d: DecodeAll mismatch", frameSize)
+		}
+
+		r, err := NewSeekableReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()), dec)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if r.Size() != int64(len(src)) {
+			t.Fatalf("size: got %d, want %d", r.Size(), len(src))
+		}
+		if want := (len(src) + frameSize - 1) / frameSize; r.NumFrames() != want {
+			t.Fatalf("frames: got %d, want %d", r.NumFrames(), want)
+		}
+		for range 100 {
+			off := rng.Int63n(int64(len(src)))
+			p := make([]byte, rng.Intn(100000))
+			n, err := r.ReadAt(p, off)
+			want := src[off:]
+			if len(want) > len(p) {
+				want = want[:len(p)]
+			}
+			if n != len(want) {
+				t.Fatalf("ReadAt(%d, %d): got %d bytes, want %d (err: %v)", len(p), off, n, len(want), err)
+			}
+			if n < len(p) && err != io.EOF {
+				t.Fatalf("ReadAt short read: want io.EOF, got %v", err)
+			}
+			if !bytes.Equal(p[:n], want) {
+				t.Fatalf("ReadAt(%d, %d): content mismatch", len(p), off)
+			}
+		}
+
+		pos, err := r.Seek(-int64(len(src)/2), io.SeekEnd)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, err = io.ReadAll(r)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(got, src[pos:]) {
+			t.Fatalf("frameSize %d: read after seek mismatch", frameSize)
+		}
+	}
+}
+
+func TestSeekableEmpty(t *testing.T) {
+	enc, err := NewWriter(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer enc.Close()
+	var buf bytes.Buffer
+	w, err := NewSeekableWriter(&buf, enc, 1<<16)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	dec, err := NewReader(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer dec.Close()
+	r, err := NewSeekableReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()), dec)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.Size() != 0 || r.NumFrames() != 0 {
+		t.Fatalf("unexpected size %d, frames %d", r.Size(), r.NumFrames())
+	}
+	if n, err := r.Read(make([]byte, 10)); n != 0 || err != io.EOF {
+		t.Fatalf("want 0, io.EOF, got %d, %v", n, err)
+	}
+}
+
+func TestSeekableCorrupt(t *testing.T) {
+	enc, err := NewWriter(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer enc.Close()
+	dec, err := NewReader(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer dec.Close()
+
+	var buf bytes.Buffer
+	w, _ := NewSeekableWriter(&buf, enc, 1000)
+	w.Write(bytes.Repeat([]byte("hello world "), 1000))
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	b := buf.Bytes()
+
+	// Missing footer.
+	_, err = NewSeekableReader(bytes.NewReader(b[:len(b)-1]), int64(len(b)-1), dec)
+	if !errors.Is(err, ErrSeekTableInvalid) {
+		t.Fatalf("want ErrSeekTableInvalid, got %v", err)
+	}
+
+	// Corrupt checksum in first entry.
+	bad := append([]byte{}, b...)
+	nFrames := 12
+	entryStart := len(bad) - seekTableFooterSize - nFrames*12
+	bad[entryStart+8] ^= 0xff
+	r, err := NewSeekableReader(bytes.NewReader(bad), int64(len(bad)), dec)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := r.ReadAt(make([]byte, 10), 0); !errors.Is(err, ErrCRCMismatch) {
+		t.Fatalf("want ErrCRCMismatch, got %v", err)
+	}
+}
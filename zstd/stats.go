@@ -0,0 +1,75 @@
+// Copyright 2025+ Klaus Post. All rights reserved.
+// License information can be found in the LICENSE file.
+
+package zstd
+
+// FrameStats contains statistics about an encoded or decoded frame.
+// Use WithEncoderStats and WithDecoderStats to receive statistics.
+type FrameStats struct {
+	// Uncompressed is the size of the frame content.
+	Uncompressed int64
+
+	// Compressed is the size of the frame, including headers and checksum.
+	// Padding added with WithEncoderPadding is not included.
+	Compressed int64
+
+	// Number of blocks of each type.
+	RawBlocks, RLEBlocks, CompressedBlocks int
+
+	// Literals is the number of literal bytes in compressed blocks.
+	Literals int64
+
+	// LiteralsSize is the encoded size of the literals of compressed blocks,
+	// including headers and Huffman tables.
+	LiteralsSize int64
+
+	// Sequences is the number of sequences in compressed blocks.
+	Sequences int64
+
+	// SequencesSize is the encoded size of the sequences of compressed blocks,
+	// including headers and FSE tables.
+	SequencesSize int64
+
+	// HuffmanNew is the number of compressed blocks with Huffman compressed literals using a new table.
+	// HuffmanReused is the number that reused the table of a previous block.
+	HuffmanNew, HuffmanReused int
+}
+
+// HuffmanReuseRatio returns the fraction of blocks with Huffman compressed literals
+// that reused the table of a previous block.
+// If no literals were Huffman compressed, 0 is returned.
+func (s *FrameStats) HuffmanReuseRatio() float64 {
+	n := s.HuffmanNew + s.HuffmanReused
+	if n == 0 {
+		return 0
+	}
+	return float64(s.HuffmanReused) / float64(n)
+}
+
+// add adds the statistics in o to s.
+func (s *FrameStats) add(o *FrameStats) {
+	s.Uncompressed += o.Uncompressed
+	s.Compressed += o.Compressed
+	s.RawBlocks += o.RawBlocks
+	s.RLEBlocks += o.RLEBlocks
+	s.CompressedBlocks += o.CompressedBlocks
+	s.Literals += o.Literals
+	s.LiteralsSize += o.LiteralsSize
+	s.Sequences += o.Sequences
+	s.SequencesSize += o.SequencesSize
+	s.HuffmanNew += o.HuffmanNew
+	s.HuffmanReused += o.HuffmanReused
+}
+
+// addLiterals adds a literals section of type typ with n literals,
+// that is size bytes including the header.
+func (s *FrameStats) addLiterals(typ literalsBlockType, n, size int) {
+	s.Literals += int64(n)
+	s.LiteralsSize += int64(size)
+	switch typ {
+	case literalsBlockCompressed:
+		s.HuffmanNew++
+	case literalsBlockTreeless:
+		s.HuffmanReused++
+	}
+}
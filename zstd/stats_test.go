@@ -0,0 +1,210 @@
+package zstd
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"testing"
+)
+
+func TestFrameStats(t *testing.T) {
+	in, err := os.ReadFile("../testdata/Mark.Twain-Tom.Sawyer.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Add RLE and incompressible blocks.
+	in = append(bytes.Repeat([]byte{'a'}, 300000), in...)
+	rng := uint32(1)
+	for range 200000 {
+		rng = rng*1664525 + 1013904223
+		in = append(in, byte(rng>>24))
+	}
+
+	var mu sync.Mutex
+	var got []FrameStats
+	fn := func(s *FrameStats) {
+		mu.Lock()
+		got = append(got, *s)
+		mu.Unlock()
+	}
+	take := func(t *testing.T) FrameStats {
+		t.Helper()
+		mu.Lock()
+		defer mu.Unlock()
+		if len(got) != 1 {
+			t.Fatalf("want 1 frame, got %d", len(got))
+		}
+		s := got[0]
+		got = got[:0]
+		return s
+	}
+
+	// check compares s to the frame in comp.
+	check := func(t *testing.T, s FrameStats, comp []byte) {
+		t.Helper()
+		if s.Uncompressed != int64(len(in)) || s.Compressed != int64(len(comp)) {
+			t.Fatalf("sizes: got %d -> %d, want %d -> %d", s.Uncompressed, s.Compressed, len(in), len(comp))
+		}
+		var want FrameStats
+		err := WalkFrames(bytes.NewReader(comp), func(f *FrameInfo) error {
+			for _, b := range f.Blocks {
+				switch b.Type {
+				case BlockTypeRaw:
+					want.RawBlocks++
+				case BlockTypeRLE:
+					want.RLEBlocks++
+				case BlockTypeCompressed:
+					want.CompressedBlocks++
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		dec, err := NewReader(nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer dec.Close()
+		err = dec.DecodeSequences(comp, func(b *SequenceBlock) error {
+			if b.Type != BlockTypeCompressed {
+				return nil
+			}
+			want.Literals += int64(len(b.Literals))
+			want.Sequences += int64(len(b.Sequences))
+			switch b.LiteralsMode {
+			case LiteralsModeCompressed:
+				want.HuffmanNew++
+			case LiteralsModeTreeless:
+				want.HuffmanReused++
+			}
+			return nil
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if s.RawBlocks != want.RawBlocks || s.RLEBlocks != want.RLEBlocks || s.CompressedBlocks != want.CompressedBlocks {
+			t.Errorf("blocks: got %+v, want %+v", s, want)
+		}
+		if s.RawBlocks == 0 || s.RLEBlocks == 0 || s.CompressedBlocks == 0 {
+			t.Errorf("missing block types: %+v", s)
+		}
+		if s.Literals != want.Literals || s.Sequences != want.Sequences {
+			t.Errorf("literals/sequences: got %+v, want %+v", s, want)
+		}
+		if s.HuffmanNew != want.HuffmanNew || s.HuffmanReused != want.HuffmanReused || s.HuffmanNew == 0 {
+			t.Errorf("huffman tables: got %+v, want %+v", s, want)
+		}
+		if s.LiteralsSize <= 0 || s.SequencesSize <= 0 || s.LiteralsSize+s.SequencesSize >= s.Compressed {
+			t.Errorf("unexpected section sizes: %+v", s)
+		}
+		if r := s.HuffmanReuseRatio(); r < 0 || r > 1 {
+			t.Errorf("reuse ratio %v", r)
+		}
+	}
+
+	// decode decodes comp with all decoder modes and compares the stats to want.
+	decode := func(t *testing.T, comp []byte, want FrameStats) {
+		t.Helper()
+		for _, conc := range []int{1, 4} {
+			dec, err := NewReader(nil, WithDecoderConcurrency(conc), WithDecoderStats(fn))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, err := dec.DecodeAll(comp, nil); err != nil {
+				t.Fatal(err)
+			}
+			if s := take(t); s != want {
+				t.Errorf("DecodeAll, concurrency %d: got %+v, want %+v", conc, s, want)
+			}
+			// Hide Bytes, so the stream decoder is used.
+			if err := dec.Reset(struct{ io.Reader }{bytes.NewReader(comp)}); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := io.Copy(io.Discard, dec); err != nil {
+				t.Fatal(err)
+			}
+			if s := take(t); s != want {
+				t.Errorf("stream, concurrency %d: got %+v, want %+v", conc, s, want)
+			}
+			dec.Close()
+		}
+	}
+
+	t.Run("EncodeAll", func(t *testing.T) {
+		enc, err := NewWriter(nil, WithEncoderStats(fn))
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer enc.Close()
+		prefix := []byte("existing")
+		comp := enc.EncodeAll(in, prefix)[len(prefix):]
+		s := take(t)
+		check(t, s, comp)
+		decode(t, comp, s)
+	})
+	t.Run("EncodeAllParts", func(t *testing.T) {
+		enc, err := NewWriter(nil, WithEncoderStats(fn))
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer enc.Close()
+		comp := enc.EncodeAllParts([][]byte{in[:100000], in[100000:]}, nil)
+		s := take(t)
+		check(t, s, comp)
+		decode(t, comp, s)
+	})
+	for _, conc := range []int{1, 4} {
+		for _, jobs := range []bool{false, true} {
+			name := map[bool]string{false: "stream", true: "jobs"}[jobs]
+			t.Run(fmt.Sprintf("%s-%d", name, conc), func(t *testing.T) {
+				opts := []EOption{WithEncoderStats(fn), WithEncoderConcurrency(conc)}
+				if jobs {
+					opts = append(opts, WithEncoderJobSize(256<<10))
+				}
+				var buf bytes.Buffer
+				enc, err := NewWriter(&buf, opts...)
+				if err != nil {
+					t.Fatal(err)
+				}
+				for b := in; len(b) > 0; b = b[min(len(b), 10000):] {
+					if _, err := enc.Write(b[:min(len(b), 10000)]); err != nil {
+						t.Fatal(err)
+					}
+				}
+				if err := enc.Close(); err != nil {
+					t.Fatal(err)
+				}
+				s := take(t)
+				check(t, s, buf.Bytes())
+				decode(t, buf.Bytes(), s)
+			})
+		}
+	}
+	// Nothing is reported for empty input.
+	enc, err := NewWriter(io.Discard, WithEncoderStats(fn))
+	if err != nil {
+		t.Fatal(err)
+	}
+	enc.EncodeAll(nil, nil)
+	if err := enc.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 0 {
+		t.Errorf("got stats for empty input: %+v", got)
+	}
+
+	// Empty frames are reported, when written.
+	enc, err = NewWriter(nil, WithEncoderStats(fn), WithZeroFrames(true))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer enc.Close()
+	comp := enc.EncodeAll(nil, nil)
+	if s := take(t); s.Compressed != int64(len(comp)) || s.RawBlocks != 1 || s.Uncompressed != 0 {
+		t.Errorf("empty frame: got %+v, %d bytes", s, len(comp))
+	}
+}